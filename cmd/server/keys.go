@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	proxyConfig "github.com/rpay/apipod-smart-proxy/internal/config"
+)
+
+const keysUsage = `Usage: server keys <command>
+
+Commands:
+  generate [label]  Print a new client API key and a static config entry
+                    holding only its hash
+  hash [key]        Print the api_key_sha256 of a key (read from stdin if
+                    omitted)
+`
+
+// runKeys implements the "keys" subcommand and returns the exit code.
+func runKeys(args []string, logger *log.Logger) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "generate":
+		key, err := proxyConfig.GenerateAPIKey()
+		if err != nil {
+			logger.Printf("Failed to generate key: %v", err)
+			return 1
+		}
+		entry, _ := json.MarshalIndent(map[string]any{
+			"api_key_sha256": proxyConfig.HashAPIKey(key),
+			"label":          strings.Join(args[1:], " "),
+			"mode":           "platform",
+		}, "", "  ")
+		fmt.Printf("API key (shown once, give it to the client):\n  %s\n\n", key)
+		fmt.Printf("Add to \"keys\" in the static config file:\n%s\n", entry)
+		return 0
+
+	case "hash":
+		key := strings.Join(args[1:], " ")
+		if key == "" {
+			line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			key = strings.TrimRight(line, "\r\n")
+		}
+		if key == "" {
+			logger.Printf("No key given")
+			return 1
+		}
+		fmt.Println(proxyConfig.HashAPIKey(key))
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, keysUsage)
+	return 2
+}
@@ -9,18 +9,29 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // RATE_LIMIT_TIMEZONE must resolve on the alpine image, which ships no zoneinfo
 
 	proxyConfig "github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/credentials"
 	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/health"
 	"github.com/rpay/apipod-smart-proxy/internal/metrics"
 	"github.com/rpay/apipod-smart-proxy/internal/middleware"
 	"github.com/rpay/apipod-smart-proxy/internal/pool"
 	"github.com/rpay/apipod-smart-proxy/internal/proxy"
+	"github.com/rpay/apipod-smart-proxy/internal/secrets"
 )
 
 func main() {
 	logger := log.New(os.Stdout, "[apipod-smart-proxy] ", log.LstdFlags|log.Lshortfile)
 
+	if len(os.Args) > 1 && os.Args[1] == "secrets" {
+		os.Exit(runSecrets(os.Args[2:], logger))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:], logger))
+	}
+
 	// Open runner.log (truncate on each run) for proxy request logging
 	runnerFile, err := os.Create("runner.log")
 	if err != nil {
@@ -39,55 +50,206 @@ func main() {
 	logger.Printf("Config Mode: %s", cfg.ConfigMode)
 	logger.Printf("Port: %s", cfg.Port)
 
-	// Initialize PostgreSQL (still needed for routing/quota_items)
-	db, err := database.New(cfg.DatabaseURL)
+	keyring, err := secrets.Load(cfg.MasterKeys, cfg.MasterKeysFile)
 	if err != nil {
-		logger.Fatalf("Failed to initialize database: %v", err)
+		logger.Fatalf("Failed to load master keys: %v", err)
+	}
+	if keyring != nil {
+		logger.Printf("Upstream credentials encryption enabled (primary key: %s)", keyring.PrimaryID())
 	}
-	defer db.Close()
-	logger.Println("Database initialized successfully")
+
+	// Background loops stop when main returns; reloaders run on SIGHUP.
+	stopBackground := make(chan struct{})
+	defer close(stopBackground)
+	var reloaders []func()
 
 	// Initialize config loader based on mode
 	var configLoader proxyConfig.ConfigLoader
+	var remoteLoader *proxyConfig.RemoteConfigLoader
+	var staticRouting *proxyConfig.StaticConfigLoader // set if the static file declares providers
 	switch cfg.ConfigMode {
 	case "static":
 		if cfg.StaticConfigPath == "" {
 			logger.Fatalf("STATIC_CONFIG_PATH required for static config mode")
 		}
-		configLoader, err = proxyConfig.NewStaticConfigLoader(cfg.StaticConfigPath)
+		staticLoader, err := proxyConfig.NewStaticConfigLoader(cfg.StaticConfigPath, keyring, logger)
 		if err != nil {
 			logger.Fatalf("Failed to load static config: %v", err)
 		}
-		logger.Printf("Static config loaded from: %s", cfg.StaticConfigPath)
+		if cfg.StaticConfigWatchInterval > 0 {
+			staticLoader.Watch(cfg.StaticConfigWatchInterval, stopBackground)
+		}
+		reloaders = append(reloaders, func() { staticLoader.Reload("SIGHUP") })
+		configLoader = staticLoader
+		if staticLoader.HasRouting() {
+			staticRouting = staticLoader
+		}
+		logger.Printf("Static config loaded from: %s (reload with SIGHUP)", cfg.StaticConfigPath)
 	case "remote":
 		if cfg.BackendURL == "" || cfg.InternalAPISecret == "" {
 			logger.Fatalf("BACKEND_URL and INTERNAL_API_SECRET required for remote config mode")
 		}
-		configLoader = proxyConfig.NewRemoteConfigLoader(cfg.BackendURL, cfg.InternalAPISecret)
-		logger.Printf("Remote config loader: %s", cfg.BackendURL)
+		remoteLoader = proxyConfig.NewRemoteConfigLoader(cfg.BackendURL, cfg.InternalAPISecret,
+			cfg.RemoteConfigCacheTTL, cfg.RemoteConfigStaleTTL, cfg.RemoteConfigNegativeTTL, logger)
+		configLoader = remoteLoader
+		logger.Printf("Remote config loader: %s (cache %s, stale %s, negative %s)", cfg.BackendURL,
+			cfg.RemoteConfigCacheTTL, cfg.RemoteConfigStaleTTL, cfg.RemoteConfigNegativeTTL)
+	case "database":
+		if cfg.DatabaseURL == "" {
+			logger.Fatalf("DATABASE_URL required for database config mode")
+		}
+		// Created below, once the database and routing cache are up.
+	default:
+		logger.Fatalf("Invalid CONFIG_MODE: %s (expected 'remote', 'static' or 'database')", cfg.ConfigMode)
+	}
+
+	// Routing data (quota items, models, providers, accounts) comes from
+	// PostgreSQL, or from the static config file when there is no database.
+	var db *database.DB
+	var routingSource proxy.RoutingSource
+	if cfg.DatabaseURL != "" {
+		db, err = database.New(cfg.DatabaseURL, keyring)
+		if err != nil {
+			logger.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer db.Close()
+		logger.Println("Database initialized successfully")
+		routingSource = db
+		if staticRouting != nil {
+			logger.Printf("WARN: DATABASE_URL is set, so providers/models/subscriptions in %s are ignored", cfg.StaticConfigPath)
+		}
+	} else {
+		if staticRouting == nil {
+			logger.Fatalf("DATABASE_URL not set and STATIC_CONFIG_PATH declares no providers")
+		}
+		routingSource = staticRouting
+		logger.Printf("Running without a database: routing from %s", cfg.StaticConfigPath)
+	}
+
+	switch cfg.RoutingFallback {
+	case proxy.FallbackWeighted, proxy.FallbackReject:
+	case proxy.FallbackDefault:
+		if cfg.RoutingDefaultModel == "" {
+			logger.Fatalf("ROUTING_DEFAULT_MODEL required when ROUTING_FALLBACK=default")
+		}
 	default:
-		logger.Fatalf("Invalid CONFIG_MODE: %s (expected 'remote' or 'static')", cfg.ConfigMode)
+		logger.Fatalf("Invalid ROUTING_FALLBACK: %s (expected 'weighted', 'reject' or 'default')", cfg.RoutingFallback)
+	}
+
+	switch cfg.QueueOrder {
+	case pool.OrderFIFO, pool.OrderPriority:
+	default:
+		logger.Fatalf("Invalid QUEUE_ORDER: %s (expected 'fifo' or 'priority')", cfg.QueueOrder)
 	}
 
 	// Initialize components
-	authMiddleware := middleware.NewAuthMiddleware(configLoader, runnerLogger)
 	loggingMiddleware := middleware.NewLoggingMiddleware(logger)
-	proxyRouter := proxy.NewRouter(db)
-	modelLimiter := pool.NewModelLimiter()
+	breakerCfg := health.DefaultConfig()
+	breakerCfg.ConsecutiveFailures = cfg.BreakerConsecutiveFailures
+	breakerCfg.FailureRate = cfg.BreakerFailureRate
+	breakerCfg.OpenDuration = cfg.BreakerOpenDuration
+	healthTracker := health.NewTracker(breakerCfg)
+
+	var catalogSource proxyConfig.CatalogSource
+	if db != nil {
+		catalogSource = db
+	}
+	catalogLoader, err := proxyConfig.NewModelCatalogLoader(cfg.ModelCatalogPath, catalogSource, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load model catalog: %v", err)
+	}
+	if cfg.ModelCatalogReloadInterval > 0 {
+		catalogLoader.Start(cfg.ModelCatalogReloadInterval, stopBackground)
+	}
+	reloaders = append(reloaders, func() { catalogLoader.Reload("SIGHUP") })
+
+	var quotaItems proxy.QuotaItemSource = routingSource // the static file is already in memory
+	if db != nil {
+		routingCache := proxy.NewRoutingCache(db, cfg.RoutingCacheTTL, logger)
+		onRoutingChange := func(payload string) {
+			routingCache.HandleNotify(payload)
+			catalogLoader.HandleNotify(payload)
+		}
+		if err := db.Listen(database.RoutingChannel, onRoutingChange, logger); err != nil {
+			logger.Printf("WARN: routing change notifications unavailable, relying on %s cache TTL: %v", cfg.RoutingCacheTTL, err)
+		}
+		quotaItems = routingCache
 
-	// Initialize usage committer (only for remote mode)
+		if cfg.ConfigMode == "database" {
+			dbLoader := proxyConfig.NewDatabaseConfigLoader(db, routingCache, cfg.UserCacheTTL, logger)
+			if err := db.Listen(database.UsersChannel, dbLoader.HandleNotify, logger); err != nil {
+				logger.Printf("WARN: user change notifications unavailable, relying on %s cache TTL: %v", cfg.UserCacheTTL, err)
+			}
+			configLoader = dbLoader
+			logger.Printf("Database config loader: users table (cache %s)", cfg.UserCacheTTL)
+		}
+	}
+	authMiddleware := middleware.NewAuthMiddleware(configLoader, runnerLogger)
+	proxyRouter := proxy.NewRouter(quotaItems, healthTracker, cfg.SessionAffinityTTL, cfg.RoutingFallback, cfg.RoutingDefaultModel)
+	var counterStore pool.CounterStore
+	switch cfg.RateLimitStore {
+	case "memory":
+		counterStore = pool.NewMemoryStore()
+	case "postgres":
+		if db == nil {
+			logger.Fatalf("RATE_LIMIT_STORE=postgres requires DATABASE_URL")
+		}
+		counterStore = pool.NewSharedStore(db, cfg.RateLimitSyncInterval, logger)
+		logger.Printf("Rate limits shared via Postgres (sync every %s)", cfg.RateLimitSyncInterval)
+	default:
+		logger.Fatalf("Invalid RATE_LIMIT_STORE: %s (expected 'memory' or 'postgres')", cfg.RateLimitStore)
+	}
+	modelLimiter := pool.NewModelLimiter(counterStore, cfg.RateLimitTimezone)
+	admissionQueue := pool.NewAdmissionQueue(modelLimiter, cfg.QueueOrder)
+
+	// Initialize usage committer (remote mode, or static mode pointed at a backend)
 	var usageCommitter *proxy.UsageCommitter
-	if cfg.ConfigMode == "remote" {
+	if cfg.BackendURL != "" && cfg.InternalAPISecret != "" {
 		usageCommitter = proxy.NewUsageCommitter(cfg.BackendURL, cfg.InternalAPISecret, runnerLogger)
 	}
 
+	var credentialStore credentials.Store // OAuth accounts only exist in the database
+	if db != nil {
+		credentialStore = db
+	}
+	credentialManager := credentials.NewManager(credentialStore, cfg.OAuthRefreshBefore, logger)
+	credentialManager.Start(30*time.Second, stopBackground)
+
 	perfMetrics := metrics.New()
-	proxyHandler := proxy.NewHandler(proxyRouter, db, logger, runnerLogger, modelLimiter, usageCommitter, perfMetrics)
+	proxyHandler := proxy.NewHandler(proxyRouter, routingSource, logger, runnerLogger, modelLimiter, usageCommitter, perfMetrics, healthTracker, cfg.FailoverMaxAttempts, admissionQueue, cfg.QueueTimeout, proxy.NewRateLimiter(counterStore, cfg.RateLimitTimezone), credentialManager)
+	if err := proxyHandler.ConfigureOpenRouter(cfg.OpenRouterReferer, cfg.OpenRouterTitle, cfg.OpenRouterPreferences); err != nil {
+		logger.Fatalf("Failed to configure OpenRouter: %v", err)
+	}
+	if err := proxyHandler.ConfigureAzureOpenAI(cfg.AzureOpenAIAPIVersion, cfg.AzureOpenAIDeployments); err != nil {
+		logger.Fatalf("Failed to configure Azure OpenAI: %v", err)
+	}
+	proxyHandler.ConfigureAnthropic(cfg.AnthropicVersion, cfg.AnthropicBeta)
+
+	if cfg.PoolRefreshInterval > 0 {
+		proxyHandler.StartPoolRefresh(cfg.PoolRefreshInterval, stopBackground)
+	}
+	if staticRouting != nil && db == nil {
+		reloaders = append(reloaders, proxyHandler.RefreshPools)
+	}
+	if cfg.OrchestratorBaseURL != "" {
+		proxyHandler.SetOrchestratorUpstream(cfg.OrchestratorBaseURL, cfg.OrchestratorAPIKey, cfg.OrchestratorModel)
+		logger.Printf("Orchestrator calls go to %s (model %s)", cfg.OrchestratorBaseURL, cfg.OrchestratorModel)
+	}
+	if db != nil && cfg.ModelDiscoveryInterval > 0 {
+		proxy.NewModelDiscovery(db, runnerLogger).Start(cfg.ModelDiscoveryInterval, stopBackground)
+	}
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", proxy.HealthCheck)
 	mux.HandleFunc("/metrics", perfMetrics.Handler())
+	mux.Handle("/health/upstreams", middleware.AdminAuth(cfg.AdminAPISecret, healthTracker.Handler()))
+	adminPools := middleware.AdminAuth(cfg.AdminAPISecret, http.HandlerFunc(proxyHandler.HandleAdminPools))
+	mux.Handle("/admin/pools", adminPools)
+	mux.Handle("/admin/pools/refresh", adminPools)
+	if remoteLoader != nil {
+		mux.Handle("/internal/config/invalidate", middleware.InternalAuth(cfg.InternalAPISecret, http.HandlerFunc(remoteLoader.HandleInvalidate)))
+	}
 	mux.Handle("/v1/chat/completions",
 		loggingMiddleware.LogRequest(
 			authMiddleware.Authenticate(
@@ -110,12 +272,19 @@ func main() {
 		logger.Println("Routes:")
 		logger.Println("  GET  /health                 - Health check")
 		logger.Println("  GET  /metrics                - Performance snapshot")
+		if cfg.AdminAPISecret != "" {
+			logger.Println("  GET  /health/upstreams       - Circuit breaker state per provider/model (admin secret required)")
+			logger.Println("  GET  /admin/pools            - Provider account pools (admin secret required)")
+			logger.Println("  POST /admin/pools/refresh    - Reload provider account pools and re-enable accounts")
+		}
 		logger.Println("  POST /v1/chat/completions    - Chat completions (Bearer token required)")
 		logger.Println("  POST /v1/messages            - Anthropic Messages API (x-api-key or Bearer token)")
 		logger.Println("")
 		logger.Printf("Config Mode: %s", cfg.ConfigMode)
 		if cfg.ConfigMode == "static" {
 			logger.Println("  Running in SELF-HOST mode (static config)")
+		} else if cfg.ConfigMode == "database" {
+			logger.Println("  Running in SELF-HOST mode (users from PostgreSQL)")
 		} else {
 			logger.Println(fmt.Sprintf("  Running in SAAS mode (backend: %s)", cfg.BackendURL))
 		}
@@ -126,6 +295,17 @@ func main() {
 		}
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Println("SIGHUP received, reloading configuration")
+			for _, reload := range reloaders {
+				reload()
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	proxyConfig "github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/secrets"
+)
+
+const secretsUsage = `Usage: server secrets <command>
+
+Commands:
+  genkey [id]         Print a new master key entry for SECRETS_MASTER_KEYS
+  encrypt [value]     Encrypt a value (read from stdin if omitted), e.g. for
+                      upstream_keys in a static config file
+  migrate [-dry-run]  Encrypt plaintext credentials in the database and
+                      re-encrypt those sealed with a retired master key
+`
+
+// runSecrets implements the "secrets" subcommand and returns the exit code.
+func runSecrets(args []string, logger *log.Logger) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, secretsUsage)
+		return 2
+	}
+	_ = godotenv.Load()
+
+	switch args[0] {
+	case "genkey":
+		id := "k1"
+		if len(args) > 1 {
+			id = args[1]
+		}
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			logger.Printf("Failed to generate key: %v", err)
+			return 1
+		}
+		fmt.Printf("%s:%s\n", id, key)
+		return 0
+
+	case "encrypt":
+		keyring, err := secrets.Load(os.Getenv("SECRETS_MASTER_KEYS"), os.Getenv("SECRETS_MASTER_KEYS_FILE"))
+		if err != nil || keyring == nil {
+			logger.Printf("SECRETS_MASTER_KEYS or SECRETS_MASTER_KEYS_FILE required: %v", err)
+			return 1
+		}
+		value := strings.Join(args[1:], " ")
+		if value == "" {
+			line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			value = strings.TrimRight(line, "\r\n")
+		}
+		sealed, err := keyring.Encrypt(value)
+		if err != nil {
+			logger.Printf("Failed to encrypt: %v", err)
+			return 1
+		}
+		fmt.Println(sealed)
+		return 0
+
+	case "migrate":
+		fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "only count the values that would be rewritten")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		cfg, err := proxyConfig.Load()
+		if err != nil {
+			logger.Printf("Failed to load configuration: %v", err)
+			return 1
+		}
+		keyring, err := secrets.Load(cfg.MasterKeys, cfg.MasterKeysFile)
+		if err != nil || keyring == nil {
+			logger.Printf("SECRETS_MASTER_KEYS or SECRETS_MASTER_KEYS_FILE required: %v", err)
+			return 1
+		}
+		db, err := database.New(cfg.DatabaseURL, keyring)
+		if err != nil {
+			logger.Printf("Failed to initialize database: %v", err)
+			return 1
+		}
+		defer db.Close()
+
+		counts, err := db.ReencryptSecrets(*dryRun)
+		columns := make([]string, 0, len(counts))
+		for c := range counts {
+			columns = append(columns, c)
+		}
+		sort.Strings(columns)
+		verb := "encrypted"
+		if *dryRun {
+			verb = "would encrypt"
+		}
+		for _, c := range columns {
+			fmt.Printf("%s: %s %d value(s) with key %s\n", c, verb, counts[c], keyring.PrimaryID())
+		}
+		if len(columns) == 0 {
+			fmt.Println("All credentials are already encrypted with the primary key")
+		}
+		if err != nil {
+			logger.Printf("Migration stopped: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, secretsUsage)
+	return 2
+}
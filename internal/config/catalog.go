@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ModelSpec is what the model catalog knows about a model. Zero values and nil
+// pointers mean "not set by this entry".
+type ModelSpec struct {
+	ContextWindow   int
+	MaxInputTokens  int // prompt budget the orchestrator trims to
+	MaxOutputTokens int // max_tokens is capped to this
+
+	// max_tokens of the orchestrator's classify/plan calls, kept well below
+	// MaxOutputTokens since they are on every request's path
+	OrchestratorMaxTokens int
+
+	RequestTimeout      time.Duration
+	ToolContinueTimeout time.Duration
+	MaxRetries          *int
+	RetryDelay          time.Duration
+	Slow                *bool // free/slow tier
+
+	// USD per million tokens.
+	InputPricePerMTok  float64
+	OutputPricePerMTok float64
+
+	SupportsTools     *bool
+	SupportsVision    *bool
+	SupportsThinking  *bool
+	SupportsStreaming *bool
+}
+
+// Cost returns the price of a request in USD, or 0 if the model is not priced.
+func (s ModelSpec) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*s.InputPricePerMTok + float64(outputTokens)*s.OutputPricePerMTok) / 1e6
+}
+
+// merge fills the fields of s that are unset from o.
+func (s *ModelSpec) merge(o ModelSpec) {
+	setInt := func(dst *int, v int) {
+		if *dst == 0 {
+			*dst = v
+		}
+	}
+	setDur := func(dst *time.Duration, v time.Duration) {
+		if *dst == 0 {
+			*dst = v
+		}
+	}
+	setFloat := func(dst *float64, v float64) {
+		if *dst == 0 {
+			*dst = v
+		}
+	}
+	setBool := func(dst **bool, v *bool) {
+		if *dst == nil {
+			*dst = v
+		}
+	}
+	setInt(&s.ContextWindow, o.ContextWindow)
+	setInt(&s.MaxInputTokens, o.MaxInputTokens)
+	setInt(&s.MaxOutputTokens, o.MaxOutputTokens)
+	setInt(&s.OrchestratorMaxTokens, o.OrchestratorMaxTokens)
+	setDur(&s.RequestTimeout, o.RequestTimeout)
+	setDur(&s.ToolContinueTimeout, o.ToolContinueTimeout)
+	if s.MaxRetries == nil {
+		s.MaxRetries = o.MaxRetries
+	}
+	setDur(&s.RetryDelay, o.RetryDelay)
+	setBool(&s.Slow, o.Slow)
+	setFloat(&s.InputPricePerMTok, o.InputPricePerMTok)
+	setFloat(&s.OutputPricePerMTok, o.OutputPricePerMTok)
+	setBool(&s.SupportsTools, o.SupportsTools)
+	setBool(&s.SupportsVision, o.SupportsVision)
+	setBool(&s.SupportsThinking, o.SupportsThinking)
+	setBool(&s.SupportsStreaming, o.SupportsStreaming)
+}
+
+// catalogEntry applies spec to the model names matching pattern.
+type catalogEntry struct {
+	pattern string
+	re      *regexp.Regexp
+	spec    ModelSpec
+}
+
+// compilePattern turns a catalog pattern into a regexp matching whole model
+// names, case-insensitively. "re:<regexp>" is a regular expression; anything
+// else is a glob where * matches any run of characters (including "/") and ?
+// a single one.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		return regexp.Compile("(?i)^(?:" + expr + ")$")
+	}
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// ModelCatalog resolves per-model behaviour from an ordered list of entries.
+// Every entry matching a model contributes the fields that earlier matches
+// left unset, so specific entries go first and broad ones (down to the
+// built-in defaults) fill in the rest.
+type ModelCatalog struct {
+	entries []catalogEntry
+	lookups sync.Map // model name → ModelSpec
+	cached  atomic.Int32
+}
+
+// maxCachedLookups bounds the lookup cache, since model names come from clients.
+const maxCachedLookups = 4096
+
+// Lookup returns the merged spec for model.
+func (c *ModelCatalog) Lookup(model string) ModelSpec {
+	if spec, ok := c.lookups.Load(model); ok {
+		return spec.(ModelSpec)
+	}
+	var spec ModelSpec
+	for _, e := range c.entries {
+		if e.re.MatchString(model) {
+			spec.merge(e.spec)
+		}
+	}
+	if c.cached.Load() < maxCachedLookups {
+		if _, loaded := c.lookups.LoadOrStore(model, spec); !loaded {
+			c.cached.Add(1)
+		}
+	}
+	return spec
+}
+
+// Len returns the number of entries, built-in defaults included.
+func (c *ModelCatalog) Len() int {
+	return len(c.entries)
+}
+
+// newModelCatalog compiles entries and appends the built-in defaults.
+func newModelCatalog(patterns []string, specs []ModelSpec) (*ModelCatalog, error) {
+	c := &ModelCatalog{}
+	for i, p := range patterns {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match %q: %w", p, err)
+		}
+		c.entries = append(c.entries, catalogEntry{pattern: p, re: re, spec: specs[i]})
+	}
+	for _, b := range builtinModels {
+		re, err := compilePattern(b.pattern)
+		if err != nil {
+			panic(err)
+		}
+		c.entries = append(c.entries, catalogEntry{pattern: b.pattern, re: re, spec: b.spec})
+	}
+	return c, nil
+}
+
+var activeCatalog atomic.Pointer[ModelCatalog]
+
+// Models returns the catalog in use: the last one installed with
+// SetModelCatalog, or the built-in defaults.
+func Models() *ModelCatalog {
+	if c := activeCatalog.Load(); c != nil {
+		return c
+	}
+	c, _ := newModelCatalog(nil, nil)
+	activeCatalog.CompareAndSwap(nil, c)
+	return activeCatalog.Load()
+}
+
+// SetModelCatalog replaces the catalog used by LookupModel.
+func SetModelCatalog(c *ModelCatalog) {
+	activeCatalog.Store(c)
+}
+
+// LookupModel returns the spec of model in the active catalog.
+func LookupModel(model string) ModelSpec {
+	return Models().Lookup(model)
+}
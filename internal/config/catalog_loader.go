@@ -0,0 +1,231 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// CatalogSource supplies per-model metadata from llm_models. *database.DB
+// implements it.
+type CatalogSource interface {
+	GetModelCatalog() ([]database.ModelCatalogRow, error)
+}
+
+// CatalogFile is the format of the MODEL_CATALOG_PATH file. Entries are
+// applied in order; see ModelCatalog.
+type CatalogFile struct {
+	Models []CatalogFileEntry `json:"models"`
+}
+
+type CatalogFileEntry struct {
+	Match string `json:"match"` // glob ("gpt-4o*") or "re:<regexp>"
+
+	ContextWindow   int `json:"context_window"`
+	MaxInputTokens  int `json:"max_input_tokens"`
+	MaxOutputTokens int `json:"max_output_tokens"`
+
+	OrchestratorMaxTokens int `json:"orchestrator_max_tokens"`
+
+	RequestTimeout      string `json:"request_timeout"` // e.g. "3m"
+	ToolContinueTimeout string `json:"tool_continue_timeout"`
+	MaxRetries          *int   `json:"max_retries"`
+	RetryDelay          string `json:"retry_delay"`
+	Slow                *bool  `json:"slow"`
+
+	InputPricePerMTok  float64 `json:"input_price_per_mtok"`
+	OutputPricePerMTok float64 `json:"output_price_per_mtok"`
+
+	SupportsTools     *bool `json:"supports_tools"`
+	SupportsVision    *bool `json:"supports_vision"`
+	SupportsThinking  *bool `json:"supports_thinking"`
+	SupportsStreaming *bool `json:"supports_streaming"`
+}
+
+// ModelCatalogLoader builds the model catalog from llm_models (exact model
+// names and aliases, most specific) and the catalog file, on top of the
+// built-in defaults, and installs it with SetModelCatalog. A source that
+// fails to load leaves the current catalog in place.
+type ModelCatalogLoader struct {
+	path   string        // optional
+	source CatalogSource // optional
+	logger *log.Logger
+
+	mu     sync.Mutex // serializes Reload
+	digest [sha256.Size]byte
+}
+
+// NewModelCatalogLoader loads and installs the catalog. path and source may
+// be empty/nil.
+func NewModelCatalogLoader(path string, source CatalogSource, logger *log.Logger) (*ModelCatalogLoader, error) {
+	l := &ModelCatalogLoader{path: path, source: source, logger: logger}
+	if err := l.Reload("startup"); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload rebuilds the catalog. reason only goes into the log line.
+func (l *ModelCatalogLoader) Reload(reason string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var fileData []byte
+	var rows []database.ModelCatalogRow
+	var err error
+	if l.path != "" {
+		if fileData, err = os.ReadFile(l.path); err != nil {
+			err = fmt.Errorf("failed to read model catalog: %w", err)
+		}
+	}
+	if err == nil && l.source != nil {
+		rows, err = l.source.GetModelCatalog()
+	}
+	var catalog *ModelCatalog
+	var fileEntries int
+	if err == nil {
+		catalog, fileEntries, err = buildModelCatalog(fileData, rows)
+	}
+	if err != nil {
+		l.logger.Printf("CATALOG [reload failed] trigger=%s err=%v (keeping previous catalog)", reason, err)
+		return err
+	}
+
+	rowsJSON, _ := json.Marshal(rows)
+	digest := sha256.Sum256(append(fileData, rowsJSON...))
+	if digest == l.digest && reason != "startup" {
+		if reason != "watch" {
+			l.logger.Printf("CATALOG [unchanged] trigger=%s", reason)
+		}
+		return nil
+	}
+	l.digest = digest
+	SetModelCatalog(catalog)
+	l.logger.Printf("CATALOG [reloaded] trigger=%s file_entries=%d db_models=%d", reason, fileEntries, len(rows))
+	return nil
+}
+
+// Start reloads the catalog every interval until stop is closed, picking up
+// edits to the file and to llm_models.
+func (l *ModelCatalogLoader) Start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Reload("watch")
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// HandleNotify reloads on database.RoutingChannel payloads that concern
+// llm_models (only sent as database.NotifyAll).
+func (l *ModelCatalogLoader) HandleNotify(payload string) {
+	if payload == database.NotifyAll {
+		l.Reload("notify")
+	}
+}
+
+// buildModelCatalog orders llm_models rows before file entries and returns
+// the number of file entries.
+func buildModelCatalog(fileData []byte, rows []database.ModelCatalogRow) (*ModelCatalog, int, error) {
+	var patterns []string
+	var specs []ModelSpec
+	for _, r := range rows {
+		spec := ModelSpec{
+			SupportsTools:     r.SupportsTools,
+			SupportsVision:    r.SupportsVision,
+			SupportsThinking:  r.SupportsThinking,
+			SupportsStreaming: r.SupportsStreaming,
+		}
+		if r.MaxContext != nil {
+			spec.ContextWindow = *r.MaxContext
+		}
+		if r.MaxOutputTokens != nil {
+			spec.MaxOutputTokens = *r.MaxOutputTokens
+		}
+		if r.RequestTimeoutSeconds != nil {
+			spec.RequestTimeout = time.Duration(*r.RequestTimeoutSeconds) * time.Second
+		}
+		if r.InputPricePerMTok != nil {
+			spec.InputPricePerMTok = *r.InputPricePerMTok
+		}
+		if r.OutputPricePerMTok != nil {
+			spec.OutputPricePerMTok = *r.OutputPricePerMTok
+		}
+		// Names are literal, not globs.
+		for _, name := range append([]string{r.ModelName}, r.Aliases...) {
+			patterns = append(patterns, "re:"+regexp.QuoteMeta(name))
+			specs = append(specs, spec)
+		}
+	}
+
+	var file CatalogFile
+	if len(fileData) > 0 {
+		if err := json.Unmarshal(fileData, &file); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse model catalog: %w", err)
+		}
+	}
+	for i, e := range file.Models {
+		if e.Match == "" {
+			return nil, 0, fmt.Errorf("models[%d]: match is empty", i)
+		}
+		if _, err := compilePattern(e.Match); err != nil {
+			return nil, 0, fmt.Errorf("models[%d]: invalid match %q: %w", i, e.Match, err)
+		}
+		spec := ModelSpec{
+			ContextWindow:         e.ContextWindow,
+			MaxInputTokens:        e.MaxInputTokens,
+			MaxOutputTokens:       e.MaxOutputTokens,
+			OrchestratorMaxTokens: e.OrchestratorMaxTokens,
+			MaxRetries:            e.MaxRetries,
+			Slow:                  e.Slow,
+			InputPricePerMTok:     e.InputPricePerMTok,
+			OutputPricePerMTok:    e.OutputPricePerMTok,
+			SupportsTools:         e.SupportsTools,
+			SupportsVision:        e.SupportsVision,
+			SupportsThinking:      e.SupportsThinking,
+			SupportsStreaming:     e.SupportsStreaming,
+		}
+		for _, d := range []struct {
+			name  string
+			value string
+			dst   *time.Duration
+		}{
+			{"request_timeout", e.RequestTimeout, &spec.RequestTimeout},
+			{"tool_continue_timeout", e.ToolContinueTimeout, &spec.ToolContinueTimeout},
+			{"retry_delay", e.RetryDelay, &spec.RetryDelay},
+		} {
+			if d.value == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.value)
+			if err != nil || v <= 0 {
+				return nil, 0, fmt.Errorf("models[%d] %q: invalid %s %q", i, e.Match, d.name, d.value)
+			}
+			*d.dst = v
+		}
+		if e.MaxRetries != nil && *e.MaxRetries < 0 {
+			return nil, 0, fmt.Errorf("models[%d] %q: negative max_retries", i, e.Match)
+		}
+		patterns = append(patterns, e.Match)
+		specs = append(specs, spec)
+	}
+
+	catalog, err := newModelCatalog(patterns, specs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return catalog, len(file.Models), nil
+}
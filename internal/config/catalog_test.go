@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+type fakeCatalogSource struct {
+	rows []database.ModelCatalogRow
+	err  error
+}
+
+func (f *fakeCatalogSource) GetModelCatalog() ([]database.ModelCatalogRow, error) {
+	return f.rows, f.err
+}
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern, model string
+		want           bool
+	}{
+		{"gpt-4o*", "gpt-4o-mini", true},
+		{"gpt-4o*", "GPT-4O", true},
+		{"gpt-4o*", "chatgpt-4o-latest", false},
+		{"*/llama-3.?-70b*", "meta/llama-3.3-70b-instruct", true},
+		{"llama-3.?", "llama-3x3", false}, // dots are literal in globs
+		{"re:claude-(3|4)-.*", "claude-4-opus", true},
+		{"re:claude-(3|4)-.*", "my-claude-4-opus", false}, // regexps match whole names
+	}
+	for _, tt := range tests {
+		re, err := compilePattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.MatchString(tt.model); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestBuiltinCatalogFamilies(t *testing.T) {
+	catalog, _, err := buildModelCatalog(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Anything merely containing "gpt" used to get GPT timeouts.
+	if got := catalog.Lookup("chatgpt-4o-latest").RequestTimeout; got != 5*time.Minute {
+		t.Errorf("chatgpt-4o-latest timeout = %v, want the default 5m", got)
+	}
+	if got := catalog.Lookup("openai/gpt-4.1").RequestTimeout; got != 3*time.Minute {
+		t.Errorf("openai/gpt-4.1 timeout = %v, want 3m", got)
+	}
+	if got := catalog.Lookup("deepseek-reasoner"); got.MaxOutputTokens != 64000 || !*got.Slow || *got.MaxRetries != 3 {
+		t.Errorf("unexpected deepseek-reasoner spec %+v", got)
+	}
+	if got := catalog.Lookup("unknown-model").MaxOutputTokens; got != 8192 {
+		t.Errorf("default max output = %d, want 8192", got)
+	}
+}
+
+func TestBuildModelCatalogLayers(t *testing.T) {
+	maxContext, maxOutput := 200000, 64000
+	price := 3.0
+	rows := []database.ModelCatalogRow{{
+		ModelName:         "claude-sonnet-4-5",
+		Aliases:           []string{"sonnet"},
+		MaxContext:        &maxContext,
+		MaxOutputTokens:   &maxOutput,
+		InputPricePerMTok: &price,
+	}}
+	file := []byte(`{"models": [
+		{"match": "claude-*", "request_timeout": "7m", "output_price_per_mtok": 15, "max_output_tokens": 1000, "supports_vision": true},
+		{"match": "re:.*-free", "slow": false}
+	]}`)
+	catalog, n, err := buildModelCatalog(file, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d file entries, want 2", n)
+	}
+
+	spec := catalog.Lookup("sonnet")
+	if spec.ContextWindow != 200000 || spec.MaxOutputTokens != 64000 || spec.InputPricePerMTok != 3 {
+		t.Fatalf("llm_models row should apply to its alias: %+v", spec)
+	}
+
+	// The row wins over the file, the file over the built-ins, and fields
+	// nobody sets come from the built-ins.
+	spec = catalog.Lookup("claude-sonnet-4-5")
+	if spec.MaxOutputTokens != 64000 || spec.RequestTimeout != 7*time.Minute || spec.OutputPricePerMTok != 15 ||
+		spec.SupportsVision == nil || !*spec.SupportsVision || spec.MaxInputTokens != 100000 {
+		t.Fatalf("unexpected merged spec %+v", spec)
+	}
+	if got := spec.Cost(1_000_000, 100_000); got != 4.5 {
+		t.Fatalf("cost = %v, want 4.5", got)
+	}
+	if *catalog.Lookup("model-free").Slow {
+		t.Fatal("file entry should override the built-in slow flag")
+	}
+}
+
+func TestBuildModelCatalogValidation(t *testing.T) {
+	for _, content := range []string{
+		`{"models": [{"match": ""}]}`,
+		`{"models": [{"match": "re:("}]}`,
+		`{"models": [{"match": "x", "request_timeout": "soon"}]}`,
+		`{"models": [{"match": "x", "max_retries": -1}]}`,
+		`{"models": [`,
+	} {
+		if _, _, err := buildModelCatalog([]byte(content), nil); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestModelCatalogLoaderReload(t *testing.T) {
+	defer SetModelCatalog(nil)
+	path := filepath.Join(t.TempDir(), "models.json")
+	writeConfig(t, path, `{"models": [{"match": "acme-*", "request_timeout": "42s"}]}`)
+	source := &fakeCatalogSource{}
+	var logs bytes.Buffer
+	loader, err := NewModelCatalogLoader(path, source, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := GetModelTimeouts("acme-large").RequestTimeout; got != 42*time.Second {
+		t.Fatalf("timeout = %v, want 42s", got)
+	}
+
+	// A broken source keeps the previous catalog.
+	source.err = errors.New("connection refused")
+	if err := loader.Reload("test"); err == nil {
+		t.Fatal("expected the source error")
+	}
+	source.err = nil
+	writeConfig(t, path, `{"models": [{"match": "acme-*", "request_timeout": "later"}]}`)
+	if err := loader.Reload("test"); err == nil {
+		t.Fatal("expected a validation error")
+	}
+	if got := GetModelTimeouts("acme-large").RequestTimeout; got != 42*time.Second {
+		t.Fatalf("previous catalog should stay active, got %v", got)
+	}
+
+	writeConfig(t, path, `{"models": [{"match": "acme-*", "request_timeout": "1m"}]}`)
+	if err := loader.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetModelTimeouts("acme-large").RequestTimeout; got != time.Minute {
+		t.Fatalf("timeout = %v after reload, want 1m", got)
+	}
+	if !strings.Contains(logs.String(), "CATALOG [reload failed]") || !strings.Contains(logs.String(), "CATALOG [reloaded] trigger=test file_entries=1") {
+		t.Fatalf("unexpected log:\n%s", logs.String())
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,15 +13,116 @@ type Config struct {
 	Port        string
 	DatabaseURL string
 
-	// Config mode: "remote" (SaaS) or "static" (OSS self-host)
+	// Config mode: "remote" (SaaS), "static" (OSS self-host) or "database"
+	// (self-host, users from Postgres)
 	ConfigMode string
 
 	// Remote mode: Laravel backend URL + shared secret
 	BackendURL        string
 	InternalAPISecret string
 
-	// Static mode: path to config.json
-	StaticConfigPath string
+	// Remote mode: runtime configs are used for RemoteConfigCacheTTL, then
+	// served while revalidating (or while the backend is down) until
+	// RemoteConfigStaleTTL. Rejected keys are cached for RemoteConfigNegativeTTL.
+	RemoteConfigCacheTTL    time.Duration
+	RemoteConfigStaleTTL    time.Duration
+	RemoteConfigNegativeTTL time.Duration
+
+	// Database mode: users are cached this long; changes announced via
+	// Postgres NOTIFY take effect immediately regardless.
+	UserCacheTTL time.Duration
+
+	// Static mode: path to config.json, checked for changes every
+	// StaticConfigWatchInterval (0 disables; SIGHUP always reloads)
+	StaticConfigPath          string
+	StaticConfigWatchInterval time.Duration
+
+	// Routing: what to do when no quota item matches the requested model.
+	// "weighted" (any item in the plan), "reject", or "default" (RoutingDefaultModel).
+	RoutingFallback     string
+	RoutingDefaultModel string
+
+	// How long a subscription's routing table is cached before it is reloaded.
+	// Changes announced via Postgres NOTIFY take effect immediately regardless.
+	RoutingCacheTTL time.Duration
+
+	// Upstream attempts per request, including failovers to other quota items
+	FailoverMaxAttempts int
+
+	// Circuit breaker: trip after N consecutive failures or a failure rate over
+	// the recent window, stay open for BreakerOpenDuration, then half-open.
+	BreakerConsecutiveFailures int
+	BreakerFailureRate         float64
+	BreakerOpenDuration        time.Duration
+
+	// Session affinity: keep a conversation on the quota item that first served
+	// it for this long after its last request (0 disables).
+	SessionAffinityTTL time.Duration
+
+	// Admission queue: when a model is rate-limited, wait up to QueueTimeout for
+	// capacity (0 answers 429 immediately). QueueOrder is "fifo" or "priority".
+	QueueTimeout time.Duration
+	QueueOrder   string
+
+	// Per-model daily request limits (RPD) and org daily quotas reset at
+	// midnight in this location.
+	RateLimitTimezone *time.Location
+
+	// Where rate-limit counters live: "memory" (per replica) or "postgres"
+	// (shared by all replicas, synced every RateLimitSyncInterval).
+	RateLimitStore        string
+	RateLimitSyncInterval time.Duration
+
+	// Provider account pools are reloaded from the database this often (0
+	// disables; POST /admin/pools/refresh reloads on demand).
+	PoolRefreshInterval time.Duration
+
+	// Shared secret for the /admin endpoints and /health/upstreams; they are
+	// disabled when unset.
+	AdminAPISecret string
+
+	// OAuth provider accounts get a new access token this long before the
+	// current one expires.
+	OAuthRefreshBefore time.Duration
+
+	// Model catalog: optional JSON file of per-model limits, timeouts, pricing
+	// and capabilities, merged with llm_models and reloaded every
+	// ModelCatalogReloadInterval (0 disables; SIGHUP always reloads).
+	ModelCatalogPath           string
+	ModelCatalogReloadInterval time.Duration
+
+	// Master keys for upstream credentials encrypted at rest, as
+	// "id:base64key,..." (first is primary) or a file with one entry per line.
+	MasterKeys     string
+	MasterKeysFile string
+
+	// OpenRouter: app attribution headers (HTTP-Referer, X-Title) and default
+	// provider routing preferences, a JSON "provider" object used when the
+	// request does not set one.
+	OpenRouterReferer     string
+	OpenRouterTitle       string
+	OpenRouterPreferences string
+
+	// Azure OpenAI: the api-version query parameter and a JSON object mapping
+	// llm_models.model_name to deployment names (unmapped models use their
+	// own name as the deployment).
+	AzureOpenAIAPIVersion  string
+	AzureOpenAIDeployments string
+
+	// Anthropic API: anthropic-version for clients that send none, and
+	// comma-separated anthropic-beta flags added to every request.
+	AnthropicVersion string
+	AnthropicBeta    string
+
+	// local_ollama providers' installed models are synced into llm_models
+	// this often (0 disables; needs DATABASE_URL).
+	ModelDiscoveryInterval time.Duration
+
+	// Orchestrator classify/plan calls go to this Anthropic Messages endpoint
+	// (e.g. an on-prem Ollama box) instead of the routed provider when set.
+	OrchestratorBaseURL string
+	OrchestratorAPIKey  string
+	OrchestratorModel   string
 }
 
 func Load() (*Config, error) {
@@ -30,14 +133,197 @@ func Load() (*Config, error) {
 		port = "8081"
 	}
 
+	configMode := os.Getenv("CONFIG_MODE")
+	if configMode == "" {
+		configMode = "remote" // default to SaaS mode
+	}
+
+	// Static mode may run without a database if the config file declares providers.
 	databaseURL := os.Getenv("DATABASE_URL")
-	if databaseURL == "" {
+	if databaseURL == "" && configMode != "static" {
 		return nil, fmt.Errorf("DATABASE_URL not set")
 	}
 
-	configMode := os.Getenv("CONFIG_MODE")
-	if configMode == "" {
-		configMode = "remote" // default to SaaS mode
+	remoteCacheTTL := 30 * time.Second
+	if v := os.Getenv("REMOTE_CONFIG_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid REMOTE_CONFIG_CACHE_TTL: %q", v)
+		}
+		remoteCacheTTL = d
+	}
+
+	remoteStaleTTL := 5 * time.Minute
+	if v := os.Getenv("REMOTE_CONFIG_STALE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < remoteCacheTTL {
+			return nil, fmt.Errorf("invalid REMOTE_CONFIG_STALE_TTL: %q (must be at least REMOTE_CONFIG_CACHE_TTL)", v)
+		}
+		remoteStaleTTL = d
+	}
+
+	remoteNegativeTTL := 10 * time.Second
+	if v := os.Getenv("REMOTE_CONFIG_NEGATIVE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid REMOTE_CONFIG_NEGATIVE_TTL: %q", v)
+		}
+		remoteNegativeTTL = d
+	}
+
+	userCacheTTL := time.Minute
+	if v := os.Getenv("USER_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid USER_CACHE_TTL: %q", v)
+		}
+		userCacheTTL = d
+	}
+
+	routingFallback := os.Getenv("ROUTING_FALLBACK")
+	if routingFallback == "" {
+		routingFallback = "weighted"
+	}
+
+	routingCacheTTL := 30 * time.Second
+	if v := os.Getenv("ROUTING_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ROUTING_CACHE_TTL: %q", v)
+		}
+		routingCacheTTL = d
+	}
+
+	failoverMaxAttempts := 3
+	if v := os.Getenv("FAILOVER_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid FAILOVER_MAX_ATTEMPTS: %q", v)
+		}
+		failoverMaxAttempts = n
+	}
+
+	breakerFailures := 5
+	if v := os.Getenv("BREAKER_CONSECUTIVE_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid BREAKER_CONSECUTIVE_FAILURES: %q", v)
+		}
+		breakerFailures = n
+	}
+
+	breakerRate := 0.5
+	if v := os.Getenv("BREAKER_FAILURE_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("invalid BREAKER_FAILURE_RATE: %q", v)
+		}
+		breakerRate = f
+	}
+
+	breakerOpen := 30 * time.Second
+	if v := os.Getenv("BREAKER_OPEN_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid BREAKER_OPEN_DURATION: %q", v)
+		}
+		breakerOpen = d
+	}
+
+	sessionAffinityTTL := 30 * time.Minute
+	if v := os.Getenv("SESSION_AFFINITY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid SESSION_AFFINITY_TTL: %q", v)
+		}
+		sessionAffinityTTL = d
+	}
+
+	queueTimeout := 30 * time.Second
+	if v := os.Getenv("QUEUE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid QUEUE_TIMEOUT: %q", v)
+		}
+		queueTimeout = d
+	}
+
+	queueOrder := os.Getenv("QUEUE_ORDER")
+	if queueOrder == "" {
+		queueOrder = "fifo"
+	}
+
+	rateLimitTZ := time.UTC
+	if v := os.Getenv("RATE_LIMIT_TIMEZONE"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_TIMEZONE: %q", v)
+		}
+		rateLimitTZ = loc
+	}
+
+	rateLimitStore := os.Getenv("RATE_LIMIT_STORE")
+	if rateLimitStore == "" {
+		rateLimitStore = "memory"
+	}
+
+	rateLimitSync := time.Second
+	if v := os.Getenv("RATE_LIMIT_SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_SYNC_INTERVAL: %q", v)
+		}
+		rateLimitSync = d
+	}
+
+	poolRefresh := time.Minute
+	if v := os.Getenv("POOL_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid POOL_REFRESH_INTERVAL: %q", v)
+		}
+		poolRefresh = d
+	}
+
+	oauthRefreshBefore := 5 * time.Minute
+	if v := os.Getenv("OAUTH_REFRESH_BEFORE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid OAUTH_REFRESH_BEFORE: %q", v)
+		}
+		oauthRefreshBefore = d
+	}
+
+	catalogReload := time.Minute
+	if v := os.Getenv("MODEL_CATALOG_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid MODEL_CATALOG_RELOAD_INTERVAL: %q", v)
+		}
+		catalogReload = d
+	}
+
+	discoveryInterval := time.Minute
+	if v := os.Getenv("MODEL_DISCOVERY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid MODEL_DISCOVERY_INTERVAL: %q", v)
+		}
+		discoveryInterval = d
+	}
+
+	orchestratorBaseURL, orchestratorModel := os.Getenv("ORCHESTRATOR_BASE_URL"), os.Getenv("ORCHESTRATOR_MODEL")
+	if (orchestratorBaseURL == "") != (orchestratorModel == "") {
+		return nil, fmt.Errorf("ORCHESTRATOR_BASE_URL and ORCHESTRATOR_MODEL must be set together")
+	}
+
+	staticWatch := 2 * time.Second
+	if v := os.Getenv("STATIC_CONFIG_WATCH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid STATIC_CONFIG_WATCH_INTERVAL: %q", v)
+		}
+		staticWatch = d
 	}
 
 	return &Config{
@@ -47,5 +333,58 @@ func Load() (*Config, error) {
 		BackendURL:        os.Getenv("BACKEND_URL"),
 		InternalAPISecret: os.Getenv("INTERNAL_API_SECRET"),
 		StaticConfigPath:  os.Getenv("STATIC_CONFIG_PATH"),
+
+		RemoteConfigCacheTTL:    remoteCacheTTL,
+		RemoteConfigStaleTTL:    remoteStaleTTL,
+		RemoteConfigNegativeTTL: remoteNegativeTTL,
+
+		UserCacheTTL: userCacheTTL,
+
+		StaticConfigWatchInterval: staticWatch,
+
+		RoutingFallback:     routingFallback,
+		RoutingDefaultModel: os.Getenv("ROUTING_DEFAULT_MODEL"),
+		RoutingCacheTTL:     routingCacheTTL,
+		FailoverMaxAttempts: failoverMaxAttempts,
+
+		BreakerConsecutiveFailures: breakerFailures,
+		BreakerFailureRate:         breakerRate,
+		BreakerOpenDuration:        breakerOpen,
+
+		SessionAffinityTTL: sessionAffinityTTL,
+
+		QueueTimeout: queueTimeout,
+		QueueOrder:   queueOrder,
+
+		RateLimitTimezone:     rateLimitTZ,
+		RateLimitStore:        rateLimitStore,
+		RateLimitSyncInterval: rateLimitSync,
+
+		PoolRefreshInterval: poolRefresh,
+		AdminAPISecret:      os.Getenv("ADMIN_API_SECRET"),
+
+		OAuthRefreshBefore: oauthRefreshBefore,
+
+		ModelCatalogPath:           os.Getenv("MODEL_CATALOG_PATH"),
+		ModelCatalogReloadInterval: catalogReload,
+
+		MasterKeys:     os.Getenv("SECRETS_MASTER_KEYS"),
+		MasterKeysFile: os.Getenv("SECRETS_MASTER_KEYS_FILE"),
+
+		OpenRouterReferer:     os.Getenv("OPENROUTER_HTTP_REFERER"),
+		OpenRouterTitle:       os.Getenv("OPENROUTER_X_TITLE"),
+		OpenRouterPreferences: os.Getenv("OPENROUTER_PROVIDER_PREFERENCES"),
+
+		AzureOpenAIAPIVersion:  os.Getenv("AZURE_OPENAI_API_VERSION"),
+		AzureOpenAIDeployments: os.Getenv("AZURE_OPENAI_DEPLOYMENTS"),
+
+		AnthropicVersion: os.Getenv("ANTHROPIC_VERSION"),
+		AnthropicBeta:    os.Getenv("ANTHROPIC_BETA"),
+
+		ModelDiscoveryInterval: discoveryInterval,
+
+		OrchestratorBaseURL: orchestratorBaseURL,
+		OrchestratorAPIKey:  os.Getenv("ORCHESTRATOR_API_KEY"),
+		OrchestratorModel:   orchestratorModel,
 	}, nil
 }
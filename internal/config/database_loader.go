@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// --- Database Config Loader (self-host with Postgres) ---
+
+// maxStaleUser bounds how long a cached user may be served while the database
+// is unreachable.
+const maxStaleUser = time.Hour
+
+// UserStore looks up users by API token. *database.DB implements it.
+type UserStore interface {
+	GetUserByAPIToken(apiToken string) (*database.User, error)
+}
+
+// PlanStore loads a subscription's quota items, which define the models its
+// users may request. *database.DB and the proxy's routing cache implement it.
+type PlanStore interface {
+	GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error)
+}
+
+// DatabaseConfigLoader builds runtime configs from the users and
+// subscriptions tables. Users are cached for ttl by token hash and dropped
+// as soon as the users trigger announces a change (see HandleNotify).
+type DatabaseConfigLoader struct {
+	users  UserStore
+	plans  PlanStore
+	ttl    time.Duration
+	logger *log.Logger
+
+	mu        sync.Mutex
+	cache     map[string]*cachedUser // by hex SHA-256 of the API token
+	gen       uint64                 // bumped on every invalidation
+	lastSweep time.Time
+}
+
+type cachedUser struct {
+	user      *database.User // nil: no such token
+	fetchedAt time.Time
+}
+
+// NewDatabaseConfigLoader creates a loader reading users from users and
+// allowed models from plans.
+func NewDatabaseConfigLoader(users UserStore, plans PlanStore, ttl time.Duration, logger *log.Logger) *DatabaseConfigLoader {
+	return &DatabaseConfigLoader{
+		users:  users,
+		plans:  plans,
+		ttl:    ttl,
+		logger: logger,
+		cache:  make(map[string]*cachedUser),
+	}
+}
+
+func (d *DatabaseConfigLoader) GetRuntimeConfig(apiKey string) (*RuntimeConfig, error) {
+	user, err := d.user(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case user == nil:
+		return &RuntimeConfig{Allowed: false, Reason: "Invalid API key"}, nil
+	case !user.Active:
+		return &RuntimeConfig{Allowed: false, Reason: "API key has been revoked"}, nil
+	case user.ExpiresAt != nil && !time.Now().Before(*user.ExpiresAt):
+		return &RuntimeConfig{Allowed: false, Reason: "API key has expired"}, nil
+	case user.SubID == 0:
+		return &RuntimeConfig{Allowed: false, Reason: "No subscription assigned to this API key"}, nil
+	}
+
+	items, err := d.plans.GetQuotaItemsBySubID(user.SubID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan %d: %w", user.SubID, err)
+	}
+	var models []string
+	for _, it := range items {
+		models = append(models, it.ModelName)
+		models = append(models, it.Aliases...)
+	}
+
+	return &RuntimeConfig{
+		Allowed:       true,
+		Mode:          "platform",
+		OrgID:         user.OrgID,
+		Label:         user.Username,
+		SubID:         user.SubID,
+		RateLimitRPM:  user.RateLimitRPM,
+		DailyQuota:    user.DailyQuota,
+		AllowedModels: models,
+		Priority:      "normal",
+	}, nil
+}
+
+// user returns the cached user for apiKey, loading it when missing or older
+// than ttl. While the database is down, a cached user is served for up to
+// maxStaleUser.
+func (d *DatabaseConfigLoader) user(apiKey string) (*database.User, error) {
+	id := HashAPIKey(apiKey)
+	d.mu.Lock()
+	cached, ok := d.cache[id]
+	gen := d.gen
+	d.mu.Unlock()
+
+	if ok && time.Since(cached.fetchedAt) < d.ttl {
+		return cached.user, nil
+	}
+
+	user, err := d.users.GetUserByAPIToken(apiKey)
+	if err != nil {
+		if ok && time.Since(cached.fetchedAt) < maxStaleUser {
+			d.logger.Printf("User lookup failed, serving cached entry: %v", err)
+			return cached.user, nil
+		}
+		return nil, err
+	}
+
+	d.mu.Lock()
+	if gen == d.gen {
+		d.cache[id] = &cachedUser{user: user, fetchedAt: time.Now()}
+	}
+	if time.Since(d.lastSweep) > maxStaleUser {
+		// Drop entries nobody asked for in a while, e.g. random invalid tokens.
+		d.lastSweep = time.Now()
+		for id, c := range d.cache {
+			if time.Since(c.fetchedAt) > maxStaleUser {
+				delete(d.cache, id)
+			}
+		}
+	}
+	d.mu.Unlock()
+	return user, nil
+}
+
+// HandleNotify handles a payload from database.UsersChannel: the token hash of
+// a changed user, or database.NotifyAll.
+func (d *DatabaseConfigLoader) HandleNotify(payload string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gen++
+	if payload == database.NotifyAll {
+		d.cache = make(map[string]*cachedUser)
+		return
+	}
+	delete(d.cache, payload)
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+type fakeUsers struct {
+	users map[string]*database.User
+	calls int
+	err   error
+}
+
+func (f *fakeUsers) GetUserByAPIToken(apiToken string) (*database.User, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.users[apiToken], nil
+}
+
+type fakePlans map[int64][]database.QuotaItem
+
+func (f fakePlans) GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error) {
+	return f[subID], nil
+}
+
+func TestDatabaseConfigLoader(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	users := &fakeUsers{users: map[string]*database.User{
+		"sk-alice": {Username: "alice", Active: true, SubID: 1, OrgID: 3, RateLimitRPM: 60, DailyQuota: 500},
+		"sk-bob":   {Username: "bob", Active: false, SubID: 1},
+		"sk-carol": {Username: "carol", Active: true, SubID: 1, ExpiresAt: &past},
+		"sk-dave":  {Username: "dave", Active: true},
+	}}
+	plans := fakePlans{1: {
+		{ModelName: "gpt-4o-mini", Aliases: []string{"mini"}},
+		{ModelName: "llama-3.3-70b"},
+	}}
+	loader := NewDatabaseConfigLoader(users, plans, time.Minute, log.New(io.Discard, "", 0))
+
+	cfg, err := loader.GetRuntimeConfig("sk-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Allowed || cfg.OrgID != 3 || cfg.SubID != 1 || cfg.RateLimitRPM != 60 || cfg.DailyQuota != 500 || cfg.Label != "alice" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if want := []string{"gpt-4o-mini", "mini", "llama-3.3-70b"}; !reflect.DeepEqual(cfg.AllowedModels, want) {
+		t.Fatalf("allowed models %v, want %v", cfg.AllowedModels, want)
+	}
+
+	for key, reason := range map[string]string{
+		"sk-nobody": "Invalid API key",
+		"sk-bob":    "API key has been revoked",
+		"sk-carol":  "API key has expired",
+		"sk-dave":   "No subscription assigned to this API key",
+	} {
+		if cfg, _ := loader.GetRuntimeConfig(key); cfg.Allowed || cfg.Reason != reason {
+			t.Errorf("%s: got %+v, want reason %q", key, cfg, reason)
+		}
+	}
+
+	// Cached until the users trigger announces a change.
+	calls := users.calls
+	users.users["sk-alice"] = &database.User{Username: "alice", Active: false, SubID: 1}
+	if cfg, _ := loader.GetRuntimeConfig("sk-alice"); !cfg.Allowed || users.calls != calls {
+		t.Fatal("user should be served from cache")
+	}
+	loader.HandleNotify(HashAPIKey("sk-alice"))
+	if cfg, _ := loader.GetRuntimeConfig("sk-alice"); cfg.Allowed {
+		t.Fatal("revoked user still allowed after notify")
+	}
+
+	// A stale entry survives a database outage.
+	loader.mu.Lock()
+	loader.cache[HashAPIKey("sk-bob")].fetchedAt = time.Now().Add(-2 * time.Minute)
+	loader.mu.Unlock()
+	users.err = errors.New("connection refused")
+	if cfg, err := loader.GetRuntimeConfig("sk-bob"); err != nil || cfg.Reason != "API key has been revoked" {
+		t.Fatalf("got %+v, %v", cfg, err)
+	}
+	loader.HandleNotify(database.NotifyAll)
+	if _, err := loader.GetRuntimeConfig("sk-bob"); err == nil {
+		t.Fatal("expected the database error without a cached entry")
+	}
+}
@@ -4,125 +4,132 @@ import "time"
 
 // ModelLimits defines token limits for different models to prevent bloated requests
 type ModelLimits struct {
-	MaxInputTokens  int
-	MaxOutputTokens int
+	MaxInputTokens        int
+	MaxOutputTokens       int // hard cap on a request's max_tokens
+	OrchestratorMaxTokens int // max_tokens of orchestrator classify/plan calls
 }
 
 // ModelTimeouts defines timeout configurations for different model tiers
 type ModelTimeouts struct {
-	RequestTimeout       time.Duration // HTTP request timeout
-	ToolContinueTimeout  time.Duration // Timeout for tool continuation requests
+	RequestTimeout      time.Duration // HTTP request timeout
+	ToolContinueTimeout time.Duration // Timeout for tool continuation requests
 	MaxRetries          int           // Maximum retry attempts
 	RetryDelay          time.Duration // Base delay between retries
 }
 
 // GetModelLimits returns token limits for a given model
 func GetModelLimits(model string) ModelLimits {
-	switch {
-	// DeepSeek models - aggressive limits to prevent token bloat
-	case contains(model, "deepseek"):
-		return ModelLimits{
-			MaxInputTokens:  8000,  // Reduced from default to prevent bloat
-			MaxOutputTokens: 4096,
-		}
-	
-	// Claude models - standard limits
-	case contains(model, "claude"):
-		return ModelLimits{
-			MaxInputTokens:  100000, // Claude can handle larger contexts
-			MaxOutputTokens: 8192,
-		}
-	
-	// GPT models - standard limits
-	case contains(model, "gpt"):
-		return ModelLimits{
-			MaxInputTokens:  16000,
-			MaxOutputTokens: 4096,
-		}
-	
-	// Gemini models
-	case contains(model, "gemini"):
-		return ModelLimits{
-			MaxInputTokens:  30000,
-			MaxOutputTokens: 8192,
-		}
-	
-	// Default conservative limits
-	default:
-		return ModelLimits{
-			MaxInputTokens:  8000,
-			MaxOutputTokens: 2048,
-		}
+	spec := LookupModel(model)
+	return ModelLimits{
+		MaxInputTokens:        spec.MaxInputTokens,
+		MaxOutputTokens:       spec.MaxOutputTokens,
+		OrchestratorMaxTokens: spec.OrchestratorMaxTokens,
 	}
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && s[:len(substr)] == substr ||
-		   len(s) > len(substr) && findInString(s, substr)
-}
-
-func findInString(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 // GetModelTimeouts returns timeout configurations for a given model
 func GetModelTimeouts(model string) ModelTimeouts {
-	switch {
-	// Free/slow models - much longer timeouts and more retries
-	case contains(model, "deepseek"):
-		return ModelTimeouts{
-			RequestTimeout:      10 * time.Minute, // Very long for slow models
-			ToolContinueTimeout: 15 * time.Minute, // Extra time for tool continuations
-			MaxRetries:         3,
-			RetryDelay:         30 * time.Second,
-		}
-	
-	// Claude models - standard timeouts
-	case contains(model, "claude"):
-		return ModelTimeouts{
-			RequestTimeout:      5 * time.Minute,
-			ToolContinueTimeout: 8 * time.Minute,
-			MaxRetries:         2,
-			RetryDelay:         10 * time.Second,
-		}
-	
-	// GPT models - faster response times
-	case contains(model, "gpt"):
-		return ModelTimeouts{
-			RequestTimeout:      3 * time.Minute,
-			ToolContinueTimeout: 5 * time.Minute,
-			MaxRetries:         2,
-			RetryDelay:         5 * time.Second,
-		}
-	
-	// Gemini models
-	case contains(model, "gemini"):
-		return ModelTimeouts{
-			RequestTimeout:      4 * time.Minute,
-			ToolContinueTimeout: 6 * time.Minute,
-			MaxRetries:         2,
-			RetryDelay:         10 * time.Second,
-		}
-	
-	// Default conservative settings for unknown models
-	default:
-		return ModelTimeouts{
-			RequestTimeout:      5 * time.Minute,
-			ToolContinueTimeout: 8 * time.Minute,
-			MaxRetries:         2,
-			RetryDelay:         10 * time.Second,
-		}
+	spec := LookupModel(model)
+	return ModelTimeouts{
+		RequestTimeout:      spec.RequestTimeout,
+		ToolContinueTimeout: spec.ToolContinueTimeout,
+		MaxRetries:          *spec.MaxRetries,
+		RetryDelay:          spec.RetryDelay,
 	}
 }
 
 // IsSlowModel returns true if the model is considered slow/free tier
 func IsSlowModel(model string) bool {
-	return contains(model, "deepseek") || 
-		   contains(model, "free") ||
-		   contains(model, "3.5-turbo") // GPT-3.5 is often rate-limited
-}
\ No newline at end of file
+	return *LookupModel(model).Slow
+}
+
+func intPtr(v int) *int    { return &v }
+func boolPtr(v bool) *bool { return &v }
+
+// builtinModels close every catalog, so each lookup ends with all of
+// MaxInputTokens, MaxOutputTokens, OrchestratorMaxTokens, timeouts, MaxRetries
+// and Slow set.
+var builtinModels = []struct {
+	pattern string
+	spec    ModelSpec
+}{
+	// Output caps of well-known models.
+	{"gpt-3.5-turbo", ModelSpec{MaxOutputTokens: 4096}},
+	{"gpt-4", ModelSpec{MaxOutputTokens: 8192}},
+	{"gpt-4-turbo", ModelSpec{MaxOutputTokens: 16384}},
+	{"gpt-4o", ModelSpec{MaxOutputTokens: 16384}},
+	{"gpt-4o-mini", ModelSpec{MaxOutputTokens: 16384}},
+	{"claude-3-haiku", ModelSpec{MaxOutputTokens: 4096}},
+	{"claude-3-sonnet", ModelSpec{MaxOutputTokens: 4096}},
+	{"claude-3-opus", ModelSpec{MaxOutputTokens: 4096}},
+	{"claude-3.5-sonnet", ModelSpec{MaxOutputTokens: 8192}},
+	{"claude-sonnet-4", ModelSpec{MaxOutputTokens: 16384}},
+	{"claude-sonnet-4.5", ModelSpec{MaxOutputTokens: 16384}},
+	{"claude-sonnet-4-5", ModelSpec{MaxOutputTokens: 16384}},
+	{"claude-opus-4", ModelSpec{MaxOutputTokens: 32768}},
+	{"claude-opus-4-6", ModelSpec{MaxOutputTokens: 32768}},
+	{"llama3-8b-8192", ModelSpec{MaxOutputTokens: 8192}},
+	{"llama3-70b-8192", ModelSpec{MaxOutputTokens: 8192}},
+	{"mixtral-8x7b-32768", ModelSpec{MaxOutputTokens: 32768}},
+	{"gemma-7b-it", ModelSpec{MaxOutputTokens: 8192}},
+	{"moonshotai/kimi-k2-instruct-0905", ModelSpec{MaxOutputTokens: 16384}},
+	{"moonshot-v1-8k", ModelSpec{MaxOutputTokens: 8192}},
+	{"moonshot-v1-32k", ModelSpec{MaxOutputTokens: 32768}},
+	{"moonshot-v1-128k", ModelSpec{MaxOutputTokens: 128000}},
+	{"deepseek-chat", ModelSpec{MaxOutputTokens: 8192}},
+	{"deepseek-reasoner", ModelSpec{MaxOutputTokens: 64000}},
+
+	// Free tiers and models that are often rate-limited: any name containing
+	// "deepseek", "free" or "3.5-turbo".
+	{"*deepseek*", ModelSpec{Slow: boolPtr(true)}},
+	{"*free*", ModelSpec{Slow: boolPtr(true)}},
+	{"*3.5-turbo*", ModelSpec{Slow: boolPtr(true)}},
+
+	// Families, optionally behind a vendor prefix ("deepseek/deepseek-chat").
+	// DeepSeek is slow: long timeouts, more retries and a tight prompt budget.
+	{"re:(.*/)?deepseek-.*", ModelSpec{
+		MaxInputTokens:        8000,
+		OrchestratorMaxTokens: 4096,
+		RequestTimeout:        10 * time.Minute,
+		ToolContinueTimeout:   15 * time.Minute,
+		MaxRetries:            intPtr(3),
+		RetryDelay:            30 * time.Second,
+		Slow:                  boolPtr(true),
+	}},
+	{"re:(.*/)?claude-.*", ModelSpec{
+		MaxInputTokens:        100000,
+		OrchestratorMaxTokens: 8192,
+		RequestTimeout:        5 * time.Minute,
+		ToolContinueTimeout:   8 * time.Minute,
+		MaxRetries:            intPtr(2),
+		RetryDelay:            10 * time.Second,
+	}},
+	{"re:(.*/)?gpt-.*", ModelSpec{
+		MaxInputTokens:        16000,
+		OrchestratorMaxTokens: 4096,
+		RequestTimeout:        3 * time.Minute,
+		ToolContinueTimeout:   5 * time.Minute,
+		MaxRetries:            intPtr(2),
+		RetryDelay:            5 * time.Second,
+	}},
+	{"re:(.*/)?gemini-.*", ModelSpec{
+		MaxInputTokens:        30000,
+		OrchestratorMaxTokens: 8192,
+		RequestTimeout:        4 * time.Minute,
+		ToolContinueTimeout:   6 * time.Minute,
+		MaxRetries:            intPtr(2),
+		RetryDelay:            10 * time.Second,
+	}},
+
+	// Default conservative settings for unknown models
+	{"*", ModelSpec{
+		MaxInputTokens:        8000,
+		MaxOutputTokens:       8192,
+		OrchestratorMaxTokens: 2048,
+		RequestTimeout:        5 * time.Minute,
+		ToolContinueTimeout:   8 * time.Minute,
+		MaxRetries:            intPtr(2),
+		RetryDelay:            10 * time.Second,
+		Slow:                  boolPtr(false),
+	}},
+}
@@ -60,6 +60,32 @@ func TestGetModelTimeouts(t *testing.T) {
 	}
 }
 
+func TestGetModelLimits(t *testing.T) {
+	tests := []struct {
+		model        string
+		maxOutput    int
+		orchestrator int
+	}{
+		{"deepseek-reasoner", 64000, 4096},
+		{"claude-sonnet-4-5", 16384, 8192},
+		{"gpt-4o", 16384, 4096},
+		{"gemini-pro", 8192, 8192},
+		{"unknown-model", 8192, 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			limits := GetModelLimits(tt.model)
+			if limits.MaxOutputTokens != tt.maxOutput {
+				t.Errorf("MaxOutputTokens = %d, want %d", limits.MaxOutputTokens, tt.maxOutput)
+			}
+			if limits.OrchestratorMaxTokens != tt.orchestrator {
+				t.Errorf("OrchestratorMaxTokens = %d, want %d", limits.OrchestratorMaxTokens, tt.orchestrator)
+			}
+		})
+	}
+}
+
 func TestIsSlowModel(t *testing.T) {
 	tests := []struct {
 		model    string
@@ -69,6 +95,9 @@ func TestIsSlowModel(t *testing.T) {
 		{"deepseek-reasoner", true},
 		{"gpt-3.5-turbo", true},
 		{"model-free", true},
+		{"google/gemma-2-9b-it:free", true},
+		{"freeform-7b", true}, // "free" anywhere in the name, as before the catalog
+		{"openrouter/gpt-3.5-turbo-0125", true},
 		{"claude-3-haiku", false},
 		{"gpt-4", false},
 		{"gemini-pro", false},
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Remote Config Loader (SaaS mode) ---
+
+// RemoteConfigLoader calls the Laravel backend for runtime config.
+//
+// Answers are cached per key: for freshTTL they are used as-is, then for up to
+// staleTTL they are still served while one background request revalidates
+// them (and serve as a fallback while the backend is unreachable). Rejections
+// are cached for negativeTTL. Concurrent lookups of the same key share a
+// single backend call, and the backend can drop entries early through
+// HandleInvalidate.
+type RemoteConfigLoader struct {
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+	logger     *log.Logger
+
+	freshTTL    time.Duration
+	staleTTL    time.Duration
+	negativeTTL time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]*cachedConfig // by hex SHA-256 of the API key
+	inflight  map[string]*configFetch
+	gen       uint64 // bumped by every invalidation
+	lastSweep time.Time
+}
+
+type cachedConfig struct {
+	config    *RuntimeConfig
+	fetchedAt time.Time
+}
+
+// configFetch is a backend lookup that concurrent callers wait on.
+type configFetch struct {
+	done   chan struct{}
+	config *RuntimeConfig
+	err    error
+}
+
+// NewRemoteConfigLoader creates a loader for the backend at baseURL. A
+// freshTTL of 0 asks the backend on every request and uses the cache only
+// when it is unreachable.
+func NewRemoteConfigLoader(baseURL, secret string, freshTTL, staleTTL, negativeTTL time.Duration, logger *log.Logger) *RemoteConfigLoader {
+	return &RemoteConfigLoader{
+		baseURL: baseURL,
+		secret:  secret,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		logger:      logger,
+		freshTTL:    freshTTL,
+		staleTTL:    staleTTL,
+		negativeTTL: negativeTTL,
+		cache:       make(map[string]*cachedConfig),
+		inflight:    make(map[string]*configFetch),
+	}
+}
+
+func (r *RemoteConfigLoader) GetRuntimeConfig(apiKey string) (*RuntimeConfig, error) {
+	id := HashAPIKey(apiKey)
+
+	r.mu.Lock()
+	cached := r.cache[id]
+	r.mu.Unlock()
+
+	var age time.Duration
+	if cached != nil {
+		age = time.Since(cached.fetchedAt)
+		switch {
+		case !cached.config.Allowed:
+			if age < r.negativeTTL {
+				return cached.config, nil
+			}
+		case age < r.freshTTL:
+			return cached.config, nil
+		case r.freshTTL > 0 && age < r.staleTTL:
+			r.revalidate(id, apiKey)
+			return cached.config, nil
+		}
+	}
+
+	cfg, err := r.fetchShared(id, apiKey)
+	if err != nil {
+		// Backend unreachable: fall back to a recent positive answer.
+		if cached != nil && cached.config.Allowed && age < r.staleTTL {
+			return cached.config, nil
+		}
+		return nil, fmt.Errorf("runtime config API unreachable and no valid cache: %w", err)
+	}
+	return cfg, nil
+}
+
+// revalidate refreshes a stale entry in the background unless a lookup for
+// the key is already running.
+func (r *RemoteConfigLoader) revalidate(id, apiKey string) {
+	r.mu.Lock()
+	_, running := r.inflight[id]
+	r.mu.Unlock()
+	if running {
+		return
+	}
+	go func() {
+		if _, err := r.fetchShared(id, apiKey); err != nil {
+			r.logger.Printf("Runtime config revalidation failed (serving cached config): %v", err)
+		}
+	}()
+}
+
+// fetchShared asks the backend for the key's config, joining a lookup that is
+// already in flight, and caches the answer.
+func (r *RemoteConfigLoader) fetchShared(id, apiKey string) (*RuntimeConfig, error) {
+	r.mu.Lock()
+	if f, ok := r.inflight[id]; ok {
+		r.mu.Unlock()
+		<-f.done
+		return f.config, f.err
+	}
+	f := &configFetch{done: make(chan struct{})}
+	r.inflight[id] = f
+	gen := r.gen
+	r.mu.Unlock()
+
+	f.config, f.err = r.fetch(apiKey)
+
+	r.mu.Lock()
+	delete(r.inflight, id)
+	// An invalidation during the call may have been meant for this very
+	// answer; don't cache it then.
+	if f.err == nil && gen == r.gen {
+		r.cache[id] = &cachedConfig{config: f.config, fetchedAt: time.Now()}
+		r.sweepLocked()
+	}
+	r.mu.Unlock()
+	close(f.done)
+	return f.config, f.err
+}
+
+// fetch calls the backend. The key goes in the POST body so it stays out of
+// URLs and access logs.
+func (r *RemoteConfigLoader) fetch(apiKey string) (*RuntimeConfig, error) {
+	payload, _ := json.Marshal(map[string]string{"api_key": apiKey})
+	req, err := http.NewRequest("POST", r.baseURL+"/api/internal/runtime-config", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Secret", r.secret)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("runtime config API returned %d", resp.StatusCode)
+	}
+
+	var cfg RuntimeConfig
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// sweepLocked drops expired entries, at most once per staleTTL, so negative
+// entries for random keys don't pile up. r.mu must be held.
+func (r *RemoteConfigLoader) sweepLocked() {
+	if time.Since(r.lastSweep) < r.staleTTL {
+		return
+	}
+	r.lastSweep = time.Now()
+	for id, c := range r.cache {
+		age := time.Since(c.fetchedAt)
+		if age >= r.staleTTL || (!c.config.Allowed && age >= r.negativeTTL) {
+			delete(r.cache, id)
+		}
+	}
+}
+
+// InvalidateRequest selects cached keys to drop. The backend identifies a key
+// by its value or its hex SHA-256, or drops all keys of an org.
+type InvalidateRequest struct {
+	APIKey       string `json:"api_key"`
+	APIKeySHA256 string `json:"api_key_sha256"`
+	OrgID        uint   `json:"org_id"`
+	All          bool   `json:"all"`
+}
+
+// Invalidate drops the matching cache entries and returns how many were
+// dropped. Lookups already in flight are not cached.
+func (r *RemoteConfigLoader) Invalidate(req InvalidateRequest) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gen++
+
+	n := 0
+	drop := func(id string) {
+		if _, ok := r.cache[id]; ok {
+			delete(r.cache, id)
+			n++
+		}
+	}
+	if req.APIKey != "" {
+		drop(HashAPIKey(req.APIKey))
+	}
+	if req.APIKeySHA256 != "" {
+		drop(strings.ToLower(req.APIKeySHA256))
+	}
+	for id, c := range r.cache {
+		if req.All || (req.OrgID != 0 && c.config.OrgID == req.OrgID) {
+			drop(id)
+		}
+	}
+	return n
+}
+
+// HandleInvalidate serves POST /internal/config/invalidate, which the backend
+// calls when a key is revoked or its plan changes. It must be wrapped in
+// middleware.InternalAuth.
+func (r *RemoteConfigLoader) HandleInvalidate(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	var body InvalidateRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, `{"error": "Invalid JSON body"}`, http.StatusBadRequest)
+		return
+	}
+	if body.APIKey == "" && body.APIKeySHA256 == "" && body.OrgID == 0 && !body.All {
+		http.Error(w, `{"error": "Specify api_key, api_key_sha256, org_id or all"}`, http.StatusBadRequest)
+		return
+	}
+
+	n := r.Invalidate(body)
+	r.logger.Printf("Runtime config cache invalidated: org=%d all=%v entries=%d", body.OrgID, body.All, n)
+	json.NewEncoder(w).Encode(map[string]int{"invalidated": n})
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeBackend answers runtime-config lookups: "sk-good" is allowed, anything
+// else is rejected. release, if set, holds every answer until it is closed.
+type fakeBackend struct {
+	calls   atomic.Int32
+	orgID   atomic.Uint32
+	down    atomic.Bool
+	release chan struct{}
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.calls.Add(1)
+	if r.Method != http.MethodPost || r.URL.RawQuery != "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if b.release != nil {
+		<-b.release
+	}
+	if b.down.Load() {
+		http.Error(w, "down", http.StatusBadGateway)
+		return
+	}
+	var body struct {
+		APIKey string `json:"api_key"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.APIKey != "sk-good" {
+		json.NewEncoder(w).Encode(RuntimeConfig{Allowed: false, Reason: "Invalid API key"})
+		return
+	}
+	json.NewEncoder(w).Encode(RuntimeConfig{Allowed: true, OrgID: uint(b.orgID.Load())})
+}
+
+func newTestRemoteLoader(t *testing.T, b *fakeBackend, fresh, stale, negative time.Duration) *RemoteConfigLoader {
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	return NewRemoteConfigLoader(srv.URL, "secret", fresh, stale, negative, log.New(io.Discard, "", 0))
+}
+
+// age makes the cached entry for apiKey look d old.
+func (r *RemoteConfigLoader) age(apiKey string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache[HashAPIKey(apiKey)].fetchedAt = time.Now().Add(-d)
+}
+
+func TestRemoteLoaderCoalescesConcurrentLookups(t *testing.T) {
+	b := &fakeBackend{release: make(chan struct{})}
+	loader := newTestRemoteLoader(t, b, time.Minute, 5*time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cfg, err := loader.GetRuntimeConfig("sk-good"); err != nil || !cfg.Allowed {
+				t.Errorf("got %+v, %v", cfg, err)
+			}
+		}()
+	}
+	for b.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the others join
+	close(b.release)
+	wg.Wait()
+
+	if n := b.calls.Load(); n != 1 {
+		t.Fatalf("backend called %d times, want 1", n)
+	}
+	loader.GetRuntimeConfig("sk-good")
+	if n := b.calls.Load(); n != 1 {
+		t.Fatal("fresh entry should be served from cache")
+	}
+}
+
+func TestRemoteLoaderServesStaleWhileRevalidating(t *testing.T) {
+	b := &fakeBackend{}
+	b.orgID.Store(1)
+	loader := newTestRemoteLoader(t, b, time.Minute, 5*time.Minute, time.Minute)
+	loader.GetRuntimeConfig("sk-good")
+
+	b.orgID.Store(2)
+	loader.age("sk-good", 2*time.Minute)
+	if cfg, _ := loader.GetRuntimeConfig("sk-good"); cfg.OrgID != 1 {
+		t.Fatalf("stale entry should be served, got org %d", cfg.OrgID)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if cfg, _ := loader.GetRuntimeConfig("sk-good"); cfg.OrgID == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry was not revalidated in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Backend down: a stale entry still works until staleTTL, then errors.
+	b.down.Store(true)
+	loader.age("sk-good", 2*time.Minute)
+	loader.revalidate(HashAPIKey("sk-good"), "sk-good")
+	if cfg, err := loader.GetRuntimeConfig("sk-good"); err != nil || !cfg.Allowed {
+		t.Fatalf("got %+v, %v", cfg, err)
+	}
+	loader.age("sk-good", 6*time.Minute)
+	if _, err := loader.GetRuntimeConfig("sk-good"); err == nil {
+		t.Fatal("expired entry should not be served")
+	}
+}
+
+func TestRemoteLoaderNegativeCacheAndInvalidate(t *testing.T) {
+	b := &fakeBackend{}
+	b.orgID.Store(7)
+	loader := newTestRemoteLoader(t, b, time.Minute, 5*time.Minute, 10*time.Second)
+
+	for i := 0; i < 3; i++ {
+		if cfg, _ := loader.GetRuntimeConfig("sk-bad"); cfg.Allowed {
+			t.Fatal("bad key allowed")
+		}
+	}
+	if n := b.calls.Load(); n != 1 {
+		t.Fatalf("backend called %d times for a rejected key, want 1", n)
+	}
+	loader.age("sk-bad", 11*time.Second)
+	loader.GetRuntimeConfig("sk-bad")
+	if n := b.calls.Load(); n != 2 {
+		t.Fatal("negative entry should expire after negativeTTL")
+	}
+
+	loader.GetRuntimeConfig("sk-good")
+	if n := loader.Invalidate(InvalidateRequest{OrgID: 7}); n != 1 {
+		t.Fatalf("invalidated %d entries, want 1", n)
+	}
+	if n := loader.Invalidate(InvalidateRequest{APIKeySHA256: HashAPIKey("sk-bad")}); n != 1 {
+		t.Fatalf("invalidated %d entries, want 1", n)
+	}
+	calls := b.calls.Load()
+	loader.GetRuntimeConfig("sk-good")
+	if b.calls.Load() != calls+1 {
+		t.Fatal("invalidated key should be looked up again")
+	}
+}
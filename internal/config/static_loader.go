@@ -0,0 +1,338 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/secrets"
+)
+
+// --- Static Config Loader (OSS / self-host mode) ---
+
+// StaticConfigLoader reads config from a JSON file for self-hosted users.
+// The file can be reloaded while running (see Reload and Watch); a file that
+// fails to load leaves the current config in place.
+type StaticConfigLoader struct {
+	path    string
+	keyring *secrets.Keyring
+	logger  *log.Logger
+
+	mu      sync.RWMutex
+	configs map[string]*staticKey // hex SHA-256 of the API key → entry
+	routing *staticRouting
+	digest  [sha256.Size]byte // of the file content currently loaded
+
+	reloadMu   sync.Mutex // serializes Reload
+	hasRouting bool       // file declared providers at startup
+}
+
+// StaticConfigFile is the format of the config.json file.
+type StaticConfigFile struct {
+	Keys []StaticKeyConfig `json:"keys"`
+
+	// Routing data for running without a database (see static_routing.go).
+	Providers     []StaticProvider     `json:"providers"`
+	Models        []StaticModel        `json:"models"`
+	Subscriptions []StaticSubscription `json:"subscriptions"`
+}
+
+// StaticKeyConfig is one client API key. Set either api_key or
+// api_key_sha256 (hex SHA-256 of the key, see `server keys`), so the file
+// does not have to hold usable keys.
+type StaticKeyConfig struct {
+	APIKey        string        `json:"api_key"`
+	APIKeySHA256  string        `json:"api_key_sha256"`
+	Label         string        `json:"label"`
+	OrgID         uint          `json:"org_id"`
+	APIKeyID      uint          `json:"api_key_id"`
+	ExpiresAt     *time.Time    `json:"expires_at"` // RFC 3339
+	Disabled      bool          `json:"disabled"`
+	Priority      string        `json:"priority"` // "high" | "normal" | "low"
+	Mode          string        `json:"mode"`
+	SubID         int64         `json:"sub_id"`
+	RateLimitRPM  int           `json:"rate_limit_rpm"`
+	DailyQuota    int           `json:"daily_quota"`
+	AllowedModels []string      `json:"allowed_models"`
+	UpstreamKeys  []UpstreamKey `json:"upstream_keys"`
+}
+
+// NewStaticConfigLoader reads filePath. Upstream keys may be sealed with
+// keyring (see `secrets encrypt`); plaintext keys are used as-is.
+func NewStaticConfigLoader(filePath string, keyring *secrets.Keyring, logger *log.Logger) (*StaticConfigLoader, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	file, configs, routing, err := parseStaticConfig(data, keyring)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticConfigLoader{
+		path:       filePath,
+		keyring:    keyring,
+		logger:     logger,
+		configs:    configs,
+		routing:    routing,
+		digest:     sha256.Sum256(data),
+		hasRouting: file.HasRouting(),
+	}, nil
+}
+
+// staticKey is a loaded key entry. Only the hash of the API key is kept, as
+// its map key.
+type staticKey struct {
+	name      string // for logs: label, masked key or hash prefix
+	expiresAt *time.Time
+	disabled  bool
+	cfg       *RuntimeConfig
+}
+
+// GetRuntimeConfig looks the key up by its SHA-256. Hashing is the timing
+// defence: the map lookup compares digests, and how much of a digest matches
+// tells nothing about the key, so no constant-time comparison is needed.
+func (s *StaticConfigLoader) GetRuntimeConfig(apiKey string) (*RuntimeConfig, error) {
+	sum := sha256.Sum256([]byte(apiKey))
+	s.mu.RLock()
+	key, ok := s.configs[hex.EncodeToString(sum[:])]
+	s.mu.RUnlock()
+	if !ok {
+		return &RuntimeConfig{Allowed: false, Reason: "Invalid API key"}, nil
+	}
+	if key.disabled {
+		return &RuntimeConfig{Allowed: false, Reason: "API key has been revoked"}, nil
+	}
+	if key.expiresAt != nil && !time.Now().Before(*key.expiresAt) {
+		return &RuntimeConfig{Allowed: false, Reason: "API key has expired"}, nil
+	}
+	return key.cfg, nil
+}
+
+// Reload re-reads the config file and, if it is valid, swaps it in. An
+// invalid file is logged and otherwise ignored. reason ("watch", "SIGHUP")
+// only goes into the log line.
+func (s *StaticConfigLoader) Reload(reason string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		s.logger.Printf("CONFIG [reload failed] trigger=%s path=%s err=%v (keeping previous config)", reason, s.path, err)
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	s.mu.RLock()
+	unchanged := digest == s.digest
+	s.mu.RUnlock()
+	if unchanged {
+		if reason != "watch" {
+			s.logger.Printf("CONFIG [unchanged] trigger=%s path=%s", reason, s.path)
+		}
+		return nil
+	}
+
+	file, configs, routing, err := parseStaticConfig(data, s.keyring)
+	if err == nil && s.hasRouting && !file.HasRouting() {
+		err = fmt.Errorf("file no longer declares any providers; the proxy would have nothing to route to")
+	}
+	if err != nil {
+		s.logger.Printf("CONFIG [reload failed] trigger=%s path=%s err=%v (keeping previous config)", reason, s.path, err)
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.configs
+	s.configs = configs
+	s.routing = routing
+	s.digest = digest
+	s.mu.Unlock()
+
+	d := diffConfigs(old, configs)
+	s.logger.Printf("CONFIG [reloaded] trigger=%s path=%s keys=%d added=%v removed=%v changed=%v providers=%d models=%d subscriptions=%d",
+		reason, s.path, len(configs), d.Added, d.Removed, d.Changed, len(file.Providers), len(file.Models), len(file.Subscriptions))
+	return nil
+}
+
+// Watch polls the file's modification time and size every interval and
+// reloads it when either changes, until stop is closed.
+func (s *StaticConfigLoader) Watch(interval time.Duration, stop <-chan struct{}) {
+	stamp := func() (time.Time, int64) {
+		fi, err := os.Stat(s.path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	lastMod, lastSize := stamp()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mod, size := stamp()
+				if size < 0 || (mod.Equal(lastMod) && size == lastSize) {
+					continue // missing (mid-rename) or untouched
+				}
+				lastMod, lastSize = mod, size
+				s.Reload("watch")
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// HasRouting reports whether the loaded file declares providers, models and
+// subscriptions, i.e. whether it can replace the database for routing.
+func (s *StaticConfigLoader) HasRouting() bool {
+	return s.hasRouting
+}
+
+// parseStaticConfig decodes and validates a config file.
+func parseStaticConfig(data []byte, keyring *secrets.Keyring) (*StaticConfigFile, map[string]*staticKey, *staticRouting, error) {
+	var file StaticConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	routing, err := buildStaticRouting(&file, keyring)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	configs := make(map[string]*staticKey, len(file.Keys))
+	for i, key := range file.Keys {
+		hash, name, err := staticKeyHash(key)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		id := hex.EncodeToString(hash[:])
+		if _, dup := configs[id]; dup {
+			return nil, nil, nil, fmt.Errorf("keys[%d]: duplicate key %s", i, name)
+		}
+		switch key.Mode {
+		case "", "platform", "byok":
+		default:
+			return nil, nil, nil, fmt.Errorf("keys[%d]: invalid mode %q (expected 'platform' or 'byok')", i, key.Mode)
+		}
+		switch key.Priority {
+		case "":
+			key.Priority = "normal"
+		case "high", "normal", "low":
+		default:
+			return nil, nil, nil, fmt.Errorf("keys[%d]: invalid priority %q (expected 'high', 'normal' or 'low')", i, key.Priority)
+		}
+
+		for j, uk := range key.UpstreamKeys {
+			apiKey, err := keyring.Decrypt(uk.APIKey)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to decrypt upstream key for provider %d: %w", uk.ProviderID, err)
+			}
+			key.UpstreamKeys[j].APIKey = apiKey
+		}
+		configs[id] = &staticKey{
+			name:      name,
+			expiresAt: key.ExpiresAt,
+			disabled:  key.Disabled,
+			cfg: &RuntimeConfig{
+				Allowed:       true,
+				Mode:          key.Mode,
+				OrgID:         key.OrgID,
+				APIKeyID:      key.APIKeyID,
+				Label:         key.Label,
+				SubID:         key.SubID,
+				RateLimitRPM:  key.RateLimitRPM,
+				DailyQuota:    key.DailyQuota,
+				AllowedModels: key.AllowedModels,
+				Priority:      key.Priority,
+				UpstreamKeys:  key.UpstreamKeys,
+			},
+		}
+	}
+	return &file, configs, routing, nil
+}
+
+// GenerateAPIKey returns a new random client API key.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sk-apipod-" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKey returns the api_key_sha256 value for key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// staticKeyHash returns the SHA-256 of a key entry's API key and the name it
+// goes by in logs.
+func staticKeyHash(key StaticKeyConfig) (hash [sha256.Size]byte, name string, err error) {
+	switch {
+	case key.APIKey != "" && key.APIKeySHA256 != "":
+		return hash, "", fmt.Errorf("set only one of api_key and api_key_sha256")
+	case key.APIKey != "":
+		hash = sha256.Sum256([]byte(key.APIKey))
+		name = maskKey(key.APIKey)
+	case key.APIKeySHA256 != "":
+		b, err := hex.DecodeString(key.APIKeySHA256)
+		if err != nil || len(b) != sha256.Size {
+			return hash, "", fmt.Errorf("api_key_sha256 must be 64 hex characters")
+		}
+		copy(hash[:], b)
+		name = "sha256:" + hex.EncodeToString(b[:4])
+	default:
+		return hash, "", fmt.Errorf("api_key is empty")
+	}
+	if key.Label != "" {
+		name = key.Label
+	}
+	return hash, name, nil
+}
+
+// configDiff lists keys (by log name) by how they changed between two configs.
+type configDiff struct {
+	Added, Removed, Changed []string
+}
+
+func diffConfigs(old, new map[string]*staticKey) configDiff {
+	var d configDiff
+	for id, key := range new {
+		prev, ok := old[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, key.name)
+		case !reflect.DeepEqual(prev, key):
+			d.Changed = append(d.Changed, key.name)
+		}
+	}
+	for id, key := range old {
+		if _, ok := new[id]; !ok {
+			d.Removed = append(d.Removed, key.name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+// maskKey keeps only the ends of an API key for logs.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "***"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
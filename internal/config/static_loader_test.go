@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, `{"keys": [
+		{"api_key": "sk-keep-00000001", "mode": "platform", "sub_id": 1},
+		{"api_key": "sk-edit-00000002", "mode": "platform", "sub_id": 1},
+		{"api_key": "sk-drop-00000003", "mode": "byok"}
+	]}`)
+	var logs bytes.Buffer
+	loader, err := NewStaticConfigLoader(path, nil, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid file is rejected and the previous config stays in place.
+	writeConfig(t, path, `{"keys": [{"api_key": "sk-keep-00000001", "mode": "bogus"}]}`)
+	if err := loader.Reload("test"); err == nil {
+		t.Fatal("invalid mode should fail the reload")
+	}
+	if cfg, _ := loader.GetRuntimeConfig("sk-drop-00000003"); !cfg.Allowed {
+		t.Fatal("previous config should be kept after a failed reload")
+	}
+
+	writeConfig(t, path, `{"keys": [
+		{"api_key": "sk-keep-00000001", "mode": "platform", "sub_id": 1},
+		{"api_key": "sk-edit-00000002", "mode": "platform", "sub_id": 1, "allowed_models": ["gpt-4o"]},
+		{"api_key": "sk-new0-00000004", "mode": "platform", "sub_id": 2}
+	]}`)
+	if err := loader.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _ := loader.GetRuntimeConfig("sk-drop-00000003"); cfg.Allowed {
+		t.Fatal("removed key still allowed")
+	}
+	if cfg, _ := loader.GetRuntimeConfig("sk-edit-00000002"); !reflect.DeepEqual(cfg.AllowedModels, []string{"gpt-4o"}) {
+		t.Fatalf("edited key not updated: %+v", cfg)
+	}
+	if !strings.Contains(logs.String(), "added=[sk-n...0004] removed=[sk-d...0003] changed=[sk-e...0002]") {
+		t.Fatalf("missing diff summary in log:\n%s", logs.String())
+	}
+}
+
+func TestParseStaticConfigValidation(t *testing.T) {
+	for _, content := range []string{
+		`{"keys": [{"api_key": ""}]}`,
+		`{"keys": [{"api_key": "sk-a"}, {"api_key": "sk-a"}]}`,
+		`{"keys": [{"api_key": "sk-a", "upstream_keys": [{"provider_id": 1, "api_key": "enc:v1:k1:x:y"}]}]}`,
+		`{"keys": [{"api_key": "sk-a", "api_key_sha256": "` + HashAPIKey("sk-a") + `"}]}`,
+		`{"keys": [{"api_key_sha256": "abc"}]}`,
+		`{"keys": [{"api_key": "sk-a"}, {"api_key_sha256": "` + HashAPIKey("sk-a") + `"}]}`,
+		`{"keys": [{"api_key": "sk-a", "priority": "urgent"}]}`,
+		`{"keys": [`,
+	} {
+		if _, _, _, err := parseStaticConfig([]byte(content), nil); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestStaticHashedKeys(t *testing.T) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	_, configs, _, err := parseStaticConfig([]byte(`{"keys": [
+		{"api_key_sha256": "`+HashAPIKey(key)+`", "label": "ci", "org_id": 4, "api_key_id": 9, "priority": "high", "sub_id": 1},
+		{"api_key": "sk-off-00000001", "disabled": true},
+		{"api_key": "sk-old-00000002", "expires_at": "`+past+`"}
+	]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := &StaticConfigLoader{configs: configs, routing: &staticRouting{}}
+
+	cfg, _ := loader.GetRuntimeConfig(key)
+	if !cfg.Allowed || cfg.Label != "ci" || cfg.OrgID != 4 || cfg.APIKeyID != 9 || cfg.Priority != "high" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg, _ := loader.GetRuntimeConfig(key + "x"); cfg.Allowed {
+		t.Fatal("wrong key accepted")
+	}
+	if cfg, _ := loader.GetRuntimeConfig("sk-off-00000001"); cfg.Allowed || cfg.Reason != "API key has been revoked" {
+		t.Fatalf("disabled key: %+v", cfg)
+	}
+	if cfg, _ := loader.GetRuntimeConfig("sk-old-00000002"); cfg.Allowed || cfg.Reason != "API key has expired" {
+		t.Fatalf("expired key: %+v", cfg)
+	}
+}
+
+func TestStaticRouting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, `{
+		"keys": [{"api_key": "sk-team-00000001", "mode": "platform", "sub_id": 7}],
+		"providers": [
+			{"name": "groq", "type": "groq", "base_url": "https://api.groq.com", "api_key": "gsk-main", "keys": ["gsk-1", "gsk-2"]},
+			{"id": 10, "name": "oai", "type": "openai", "base_url": "https://api.openai.com", "api_key": "sk-oai"}
+		],
+		"models": [
+			{"name": "llama-3.3-70b", "provider": "groq", "rpm": 30, "supports_vision": false},
+			{"name": "gpt-4o-mini", "provider": "oai", "aliases": ["mini"]}
+		],
+		"subscriptions": [
+			{"sub_id": 7, "items": [{"model": "llama-3.3-70b", "weight": 80}, {"model": "gpt-4o-mini", "weight": 20}]}
+		]
+	}`)
+	loader, err := NewStaticConfigLoader(path, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loader.HasRouting() {
+		t.Fatal("file declares providers")
+	}
+
+	items, _ := loader.GetQuotaItemsBySubID(7)
+	if len(items) != 2 {
+		t.Fatalf("got %d quota items, want 2", len(items))
+	}
+	llama, mini := items[0], items[1]
+	if llama.ProviderID != 1 || llama.ProviderType != "groq" || llama.APIKey != "gsk-main" || *llama.RPM != 30 || llama.PercentageWeight != 80 {
+		t.Fatalf("unexpected item %+v", llama)
+	}
+	if mini.ProviderID != 10 || mini.LLMModelID != 2 || mini.QuotaID != 2 || mini.Aliases[0] != "mini" {
+		t.Fatalf("unexpected item %+v", mini)
+	}
+
+	accounts, _ := loader.GetAccountsForProvider(1)
+	if len(accounts) != 2 || accounts[1].APIKey != "gsk-2" || accounts[1].ID != 2 {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+	if accounts, _ := loader.GetAccountsForProvider(10); len(accounts) != 0 {
+		t.Fatal("provider without extra keys should have no pool")
+	}
+
+	// A file without providers cannot replace one that routes.
+	writeConfig(t, path, `{"keys": [{"api_key": "sk-team-00000001", "sub_id": 7}]}`)
+	if err := loader.Reload("test"); err == nil {
+		t.Fatal("dropping all providers should be rejected")
+	}
+}
+
+func TestStaticRoutingValidation(t *testing.T) {
+	provider := `{"name": "p", "type": "openai", "base_url": "http://x"}`
+	for _, content := range []string{
+		`{"providers": [{"name": "p", "type": "openai"}]}`,
+		`{"providers": [` + provider + `, ` + provider + `]}`,
+		`{"providers": [` + provider + `], "models": [{"name": "m", "provider": "nope"}]}`,
+		`{"providers": [` + provider + `], "models": [{"name": "m", "provider": "p"}], "subscriptions": [{"sub_id": 1, "items": [{"model": "other"}]}]}`,
+		`{"providers": [` + provider + `], "models": [{"name": "m", "provider": "p"}], "subscriptions": [{"sub_id": 1}, {"sub_id": 1}]}`,
+	} {
+		if _, _, _, err := parseStaticConfig([]byte(content), nil); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
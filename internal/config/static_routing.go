@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/secrets"
+)
+
+// Routing data declared in the static config file, so a self-hosted proxy can
+// run without Postgres. Providers, models and quota items get IDs by position
+// (1-based) unless the file sets "id"; give them explicit IDs if you reorder
+// entries, since rate limits, circuit breakers and session pins are keyed by ID.
+
+type StaticProvider struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // provider_type, e.g. "openai", "groq", "google_ai_studio"
+	BaseURL string   `json:"base_url"`
+	APIKey  string   `json:"api_key"`
+	Keys    []string `json:"keys"` // extra keys, pooled like provider_accounts
+}
+
+type StaticModel struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Provider string   `json:"provider"` // StaticProvider.Name
+	Aliases  []string `json:"aliases"`
+	RPM      *int     `json:"rpm"`
+	TPM      *int     `json:"tpm"`
+	RPD      *int     `json:"rpd"`
+
+	MaxContext        *int  `json:"max_context"`
+	SupportsTools     *bool `json:"supports_tools"`
+	SupportsVision    *bool `json:"supports_vision"`
+	SupportsThinking  *bool `json:"supports_thinking"`
+	SupportsStreaming *bool `json:"supports_streaming"`
+}
+
+type StaticSubscription struct {
+	SubID int64             `json:"sub_id"`
+	Items []StaticQuotaItem `json:"items"`
+}
+
+type StaticQuotaItem struct {
+	ID     int64  `json:"id"`
+	Model  string `json:"model"` // StaticModel.Name
+	Weight int    `json:"weight"`
+}
+
+// staticRouting is the routing data of one config file, in the shapes the
+// Postgres queries return.
+type staticRouting struct {
+	quotaItems map[int64][]database.QuotaItem       // by sub_id
+	accounts   map[int64][]database.ProviderAccount // by provider ID
+}
+
+// HasRouting reports whether the file declares providers, which makes it the
+// routing source instead of the database.
+func (f *StaticConfigFile) HasRouting() bool {
+	return len(f.Providers) > 0
+}
+
+func buildStaticRouting(file *StaticConfigFile, keyring *secrets.Keyring) (*staticRouting, error) {
+	r := &staticRouting{
+		quotaItems: make(map[int64][]database.QuotaItem),
+		accounts:   make(map[int64][]database.ProviderAccount),
+	}
+
+	providers := make(map[string]*StaticProvider, len(file.Providers))
+	providerIDs := make(map[int64]bool, len(file.Providers))
+	for i := range file.Providers {
+		p := &file.Providers[i]
+		if p.ID == 0 {
+			p.ID = int64(i + 1)
+		}
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("providers[%d]: name is empty", i)
+		case providers[p.Name] != nil:
+			return nil, fmt.Errorf("providers[%d]: duplicate name %q", i, p.Name)
+		case providerIDs[p.ID]:
+			return nil, fmt.Errorf("providers[%d]: duplicate id %d", i, p.ID)
+		case p.Type == "" || p.BaseURL == "":
+			return nil, fmt.Errorf("providers[%d] %q: type and base_url are required", i, p.Name)
+		}
+		apiKey, err := keyring.Decrypt(p.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("providers[%d] %q: api_key: %w", i, p.Name, err)
+		}
+		p.APIKey = apiKey
+		for j, key := range p.Keys {
+			plain, err := keyring.Decrypt(key)
+			if err != nil {
+				return nil, fmt.Errorf("providers[%d] %q: keys[%d]: %w", i, p.Name, j, err)
+			}
+			r.accounts[p.ID] = append(r.accounts[p.ID], database.ProviderAccount{
+				ID:         uint(j + 1),
+				ProviderID: uint(p.ID),
+				Email:      fmt.Sprintf("%s#%d", p.Name, j+1),
+				APIKey:     plain,
+			})
+		}
+		providers[p.Name] = p
+		providerIDs[p.ID] = true
+	}
+
+	models := make(map[string]*StaticModel, len(file.Models))
+	modelIDs := make(map[int64]bool, len(file.Models))
+	for i := range file.Models {
+		m := &file.Models[i]
+		if m.ID == 0 {
+			m.ID = int64(i + 1)
+		}
+		switch {
+		case m.Name == "":
+			return nil, fmt.Errorf("models[%d]: name is empty", i)
+		case models[m.Name] != nil:
+			return nil, fmt.Errorf("models[%d]: duplicate name %q", i, m.Name)
+		case modelIDs[m.ID]:
+			return nil, fmt.Errorf("models[%d]: duplicate id %d", i, m.ID)
+		case providers[m.Provider] == nil:
+			return nil, fmt.Errorf("models[%d] %q: unknown provider %q", i, m.Name, m.Provider)
+		}
+		models[m.Name] = m
+		modelIDs[m.ID] = true
+	}
+
+	var nextQuotaID int64
+	quotaIDs := make(map[int64]bool)
+	for i, sub := range file.Subscriptions {
+		if _, dup := r.quotaItems[sub.SubID]; dup {
+			return nil, fmt.Errorf("subscriptions[%d]: duplicate sub_id %d", i, sub.SubID)
+		}
+		items := make([]database.QuotaItem, 0, len(sub.Items))
+		for j, it := range sub.Items {
+			nextQuotaID++
+			if it.ID == 0 {
+				it.ID = nextQuotaID
+			}
+			m := models[it.Model]
+			switch {
+			case m == nil:
+				return nil, fmt.Errorf("subscriptions[%d].items[%d]: unknown model %q", i, j, it.Model)
+			case it.Weight < 0:
+				return nil, fmt.Errorf("subscriptions[%d].items[%d]: negative weight", i, j)
+			case quotaIDs[it.ID]:
+				return nil, fmt.Errorf("subscriptions[%d].items[%d]: duplicate id %d", i, j, it.ID)
+			}
+			quotaIDs[it.ID] = true
+			p := providers[m.Provider]
+			items = append(items, database.QuotaItem{
+				QuotaID:           it.ID,
+				SubID:             sub.SubID,
+				LLMModelID:        m.ID,
+				ModelName:         m.Name,
+				Aliases:           m.Aliases,
+				PercentageWeight:  it.Weight,
+				BaseURL:           p.BaseURL,
+				APIKey:            p.APIKey,
+				ProviderType:      p.Type,
+				ProviderID:        p.ID,
+				RPM:               m.RPM,
+				TPM:               m.TPM,
+				RPD:               m.RPD,
+				MaxContext:        m.MaxContext,
+				SupportsTools:     m.SupportsTools,
+				SupportsVision:    m.SupportsVision,
+				SupportsThinking:  m.SupportsThinking,
+				SupportsStreaming: m.SupportsStreaming,
+			})
+		}
+		r.quotaItems[sub.SubID] = items
+	}
+	return r, nil
+}
+
+// GetQuotaItemsBySubID returns the quota items the file declares for subID.
+func (s *StaticConfigLoader) GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.routing.quotaItems[subID], nil
+}
+
+// GetAccountsForProvider returns the provider's pooled keys.
+func (s *StaticConfigLoader) GetAccountsForProvider(providerID uint) ([]database.ProviderAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.routing.accounts[int64(providerID)], nil
+}
+
+// LogUsage is a no-op: without a database, usage is only in runner.log.
+func (s *StaticConfigLoader) LogUsage(ctx database.UsageContext, inputTokens, outputTokens int) error {
+	return nil
+}
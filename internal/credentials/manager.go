@@ -0,0 +1,276 @@
+// Package credentials exchanges OAuth refresh tokens stored for provider
+// accounts for short-lived access tokens, caching them until shortly before
+// they expire.
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// ErrNoCredential is returned for accounts without a stored refresh token.
+var ErrNoCredential = errors.New("no OAuth credential for account")
+
+const (
+	// minValidity is how long a cached token must still be valid to be handed
+	// out; anything shorter is refreshed inline.
+	minValidity = 30 * time.Second
+	// defaultLifetime applies when the token endpoint omits expires_in.
+	defaultLifetime = 10 * time.Minute
+)
+
+// Store persists refresh tokens. *database.DB implements it; a nil Store has
+// no credentials. UpdateRefreshToken replaces oldToken only if it is still
+// the stored token and reports whether it did.
+type Store interface {
+	GetAccountCredential(accountID uint) (*database.AccountCredential, error)
+	UpdateRefreshToken(accountID uint, oldToken, newToken string) (bool, error)
+}
+
+// RefreshError is a refresh rejected by the token endpoint.
+type RefreshError struct {
+	Status      int
+	Code        string // OAuth error code, e.g. "invalid_grant"
+	Description string
+}
+
+func (e *RefreshError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("token endpoint returned %d", e.Status)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("token endpoint returned %d: %s", e.Status, e.Code)
+	}
+	return fmt.Sprintf("token endpoint returned %d: %s (%s)", e.Status, e.Code, e.Description)
+}
+
+// Permanent reports whether retrying cannot help: the grant was revoked or the
+// client is misconfigured.
+func (e *RefreshError) Permanent() bool {
+	switch e.Code {
+	case "invalid_grant", "invalid_client", "unauthorized_client":
+		return true
+	}
+	return false
+}
+
+// IsPermanent reports whether err is a RefreshError that retrying cannot fix,
+// or a missing credential.
+func IsPermanent(err error) bool {
+	var re *RefreshError
+	if errors.As(err, &re) {
+		return re.Permanent()
+	}
+	return errors.Is(err, ErrNoCredential)
+}
+
+type token struct {
+	mu          sync.Mutex // serializes refreshes of one account
+	accessToken string
+	expiresAt   time.Time
+}
+
+// Manager hands out access tokens for OAuth provider accounts.
+type Manager struct {
+	store         Store
+	client        *http.Client
+	refreshBefore time.Duration
+	logger        *log.Logger
+	now           func() time.Time
+
+	mu     sync.Mutex
+	tokens map[uint]*token
+}
+
+// NewManager creates a manager that refreshes access tokens refreshBefore
+// their expiry (see Start).
+func NewManager(store Store, refreshBefore time.Duration, logger *log.Logger) *Manager {
+	return &Manager{
+		store:         store,
+		client:        &http.Client{Timeout: 15 * time.Second},
+		refreshBefore: refreshBefore,
+		logger:        logger,
+		now:           time.Now,
+		tokens:        make(map[uint]*token),
+	}
+}
+
+// Token returns a valid access token for the account, refreshing it first if
+// it is missing or about to expire. Concurrent callers for the same account
+// share a single refresh.
+func (m *Manager) Token(accountID uint) (string, error) {
+	t := m.entry(accountID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.accessToken != "" && m.now().Add(minValidity).Before(t.expiresAt) {
+		return t.accessToken, nil
+	}
+	if err := m.refresh(accountID, t); err != nil {
+		return "", err
+	}
+	return t.accessToken, nil
+}
+
+// Invalidate drops the cached access token, e.g. after the upstream rejected it.
+func (m *Manager) Invalidate(accountID uint) {
+	t := m.entry(accountID)
+	t.mu.Lock()
+	t.accessToken = ""
+	t.mu.Unlock()
+}
+
+// Start refreshes cached tokens that expire within refreshBefore every
+// interval until stop is closed, so requests rarely wait on the token endpoint.
+// A failed proactive refresh keeps the current token while it lasts.
+func (m *Manager) Start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.refreshExpiring()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) refreshExpiring() {
+	m.mu.Lock()
+	ids := make([]uint, 0, len(m.tokens))
+	for id := range m.tokens {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		t := m.entry(id)
+		t.mu.Lock()
+		if t.accessToken != "" && !m.now().Add(m.refreshBefore).Before(t.expiresAt) {
+			if err := m.refresh(id, t); err != nil {
+				m.logger.Printf("OAuth refresh for account %d failed: %v", id, err)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (m *Manager) entry(accountID uint) *token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[accountID]
+	if !ok {
+		t = &token{}
+		m.tokens[accountID] = t
+	}
+	return t
+}
+
+// refresh exchanges the account's refresh token; t.mu must be held. The
+// refresh token is read from the store every time, so edits and rotations by
+// other replicas are picked up. An invalid_grant is only final if the stored
+// token is still the one sent: another replica may have rotated it while this
+// exchange was in flight, in which case the new token is tried once.
+func (m *Manager) refresh(accountID uint, t *token) error {
+	cred, err := m.credential(accountID)
+	if err != nil {
+		return err
+	}
+	err = m.exchange(accountID, t, cred)
+	var re *RefreshError
+	if !errors.As(err, &re) || re.Code != "invalid_grant" {
+		return err
+	}
+
+	latest, lerr := m.credential(accountID)
+	if lerr != nil || latest.RefreshToken == cred.RefreshToken {
+		return err
+	}
+	m.logger.Printf("OAuth refresh token of account %d was rotated elsewhere, retrying with the stored one", accountID)
+	return m.exchange(accountID, t, latest)
+}
+
+func (m *Manager) credential(accountID uint) (*database.AccountCredential, error) {
+	if m.store == nil {
+		return nil, ErrNoCredential
+	}
+	cred, err := m.store.GetAccountCredential(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+	return cred, nil
+}
+
+// exchange trades cred's refresh token for an access token stored in t.
+func (m *Manager) exchange(accountID uint, t *token, cred *database.AccountCredential) error {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {cred.RefreshToken},
+	}
+	if cred.ClientID != "" {
+		form.Set("client_id", cred.ClientID)
+	}
+	if cred.ClientSecret != "" {
+		form.Set("client_secret", cred.ClientSecret)
+	}
+	if cred.Scope != "" {
+		form.Set("scope", cred.Scope)
+	}
+
+	issued := m.now()
+	resp, err := m.client.Post(cred.TokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		RefreshToken     string `json:"refresh_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return &RefreshError{Status: resp.StatusCode, Code: result.Error, Description: result.ErrorDescription}
+	}
+
+	lifetime := defaultLifetime
+	if result.ExpiresIn > 0 {
+		lifetime = time.Duration(result.ExpiresIn) * time.Second
+	}
+	t.accessToken = result.AccessToken
+	t.expiresAt = issued.Add(lifetime)
+
+	// Some providers rotate the refresh token on every use; the old one stops
+	// working, so the new one has to be saved. It only replaces the token that
+	// was sent, so a replica that lost a concurrent refresh cannot overwrite
+	// the winner's.
+	if result.RefreshToken != "" && result.RefreshToken != cred.RefreshToken {
+		stored, err := m.store.UpdateRefreshToken(accountID, cred.RefreshToken, result.RefreshToken)
+		if err != nil {
+			m.logger.Printf("Failed to store rotated refresh token for account %d: %v", accountID, err)
+		} else if !stored {
+			m.logger.Printf("Rotated refresh token for account %d not stored: the stored token changed during the refresh", accountID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+type fakeStore struct {
+	mu    sync.Mutex
+	creds map[uint]*database.AccountCredential
+}
+
+func (s *fakeStore) GetAccountCredential(accountID uint) (*database.AccountCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.creds[accountID]
+	if !ok {
+		return nil, nil
+	}
+	cp := *c
+	return &cp, nil
+}
+
+func (s *fakeStore) UpdateRefreshToken(accountID uint, oldToken, newToken string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds[accountID].RefreshToken != oldToken {
+		return false, nil
+	}
+	s.creds[accountID].RefreshToken = newToken
+	return true, nil
+}
+
+// staleStore hands out an outdated refresh token on the first read, as if
+// another replica rotated it right after this one read it.
+type staleStore struct {
+	*fakeStore
+	stale string
+}
+
+func (s *staleStore) GetAccountCredential(accountID uint) (*database.AccountCredential, error) {
+	c, err := s.fakeStore.GetAccountCredential(accountID)
+	if c != nil && s.stale != "" {
+		c.RefreshToken, s.stale = s.stale, ""
+	}
+	return c, err
+}
+
+func (s *fakeStore) refreshToken(accountID uint) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.creds[accountID].RefreshToken
+}
+
+// tokenServer is a fake OAuth token endpoint that rotates refresh tokens and
+// issues access tokens valid for an hour.
+type tokenServer struct {
+	*httptest.Server
+	calls atomic.Int32
+	mu    sync.Mutex
+	valid map[string]bool // refresh tokens that are still accepted
+}
+
+func newTokenServer(t *testing.T, refreshTokens ...string) *tokenServer {
+	ts := &tokenServer{valid: map[string]bool{}}
+	for _, rt := range refreshTokens {
+		ts.valid[rt] = true
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := ts.calls.Add(1)
+		time.Sleep(10 * time.Millisecond) // widen the window for concurrent refreshes
+		r.ParseForm()
+		rt := r.PostForm.Get("refresh_token")
+
+		ts.mu.Lock()
+		ok := ts.valid[rt] && r.PostForm.Get("grant_type") == "refresh_token" && r.PostForm.Get("client_id") == "cid"
+		if ok {
+			delete(ts.valid, rt)
+			ts.valid[fmt.Sprintf("rt-%d", n)] = true
+		}
+		ts.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": "invalid_grant", "error_description": "token revoked"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("at-%d", n),
+			"expires_in":    3600,
+			"refresh_token": fmt.Sprintf("rt-%d", n),
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestManager(ts *tokenServer, refreshToken string) (*Manager, *fakeStore, *time.Time) {
+	store := &fakeStore{creds: map[uint]*database.AccountCredential{
+		1: {AccountID: 1, TokenURL: ts.URL, ClientID: "cid", RefreshToken: refreshToken},
+	}}
+	m := NewManager(store, 5*time.Minute, log.New(io.Discard, "", 0))
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	return m, store, &now
+}
+
+func TestTokenIsCachedUntilExpiry(t *testing.T) {
+	ts := newTokenServer(t, "rt-0")
+	m, store, now := newTestManager(ts, "rt-0")
+
+	tok, err := m.Token(1)
+	if err != nil || tok != "at-1" {
+		t.Fatalf("got %q, %v", tok, err)
+	}
+	if got := store.refreshToken(1); got != "rt-1" {
+		t.Fatalf("rotated refresh token not stored, have %q", got)
+	}
+
+	*now = now.Add(50 * time.Minute)
+	if tok, _ := m.Token(1); tok != "at-1" || ts.calls.Load() != 1 {
+		t.Fatalf("cached token should be reused, got %q after %d calls", tok, ts.calls.Load())
+	}
+
+	*now = now.Add(10 * time.Minute) // expired
+	if tok, err := m.Token(1); err != nil || tok != "at-2" {
+		t.Fatalf("expected a refreshed token, got %q, %v", tok, err)
+	}
+}
+
+func TestConcurrentCallersShareOneRefresh(t *testing.T) {
+	ts := newTokenServer(t, "rt-0")
+	m, _, _ := newTestManager(ts, "rt-0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tok, err := m.Token(1); err != nil || tok != "at-1" {
+				t.Errorf("got %q, %v", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := ts.calls.Load(); n != 1 {
+		t.Fatalf("%d refreshes, want 1", n)
+	}
+}
+
+func TestProactiveRefresh(t *testing.T) {
+	ts := newTokenServer(t, "rt-0")
+	m, _, now := newTestManager(ts, "rt-0")
+	m.Token(1)
+
+	m.refreshExpiring()
+	if ts.calls.Load() != 1 {
+		t.Fatal("fresh token should not be refreshed")
+	}
+
+	*now = now.Add(56 * time.Minute) // within refreshBefore of expiry
+	m.refreshExpiring()
+	if tok, _ := m.Token(1); tok != "at-2" || ts.calls.Load() != 2 {
+		t.Fatalf("expected proactive refresh, got %q after %d calls", tok, ts.calls.Load())
+	}
+}
+
+func TestRevokedGrantIsPermanent(t *testing.T) {
+	ts := newTokenServer(t) // accepts nothing
+	m, _, _ := newTestManager(ts, "revoked")
+
+	_, err := m.Token(1)
+	if !IsPermanent(err) {
+		t.Fatalf("invalid_grant should be permanent, got %v", err)
+	}
+	if _, err := m.Token(2); err != ErrNoCredential {
+		t.Fatalf("unknown account: got %v, want ErrNoCredential", err)
+	}
+}
+
+func TestInvalidGrantRetriesTokenRotatedElsewhere(t *testing.T) {
+	ts := newTokenServer(t, "rt-new")
+	m, store, _ := newTestManager(ts, "rt-new")
+	m.store = &staleStore{fakeStore: store, stale: "rt-old"}
+
+	tok, err := m.Token(1)
+	if err != nil || tok != "at-2" {
+		t.Fatalf("got %q, %v; want a token from the re-read refresh token", tok, err)
+	}
+	if got := store.refreshToken(1); got != "rt-2" {
+		t.Fatalf("stored refresh token = %q, want rt-2", got)
+	}
+}
+
+func TestLostRotationDoesNotOverwriteStoredToken(t *testing.T) {
+	ts := newTokenServer(t, "rt-old")
+	m, store, _ := newTestManager(ts, "rt-new")
+	m.store = &staleStore{fakeStore: store, stale: "rt-old"}
+
+	if _, err := m.Token(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.refreshToken(1); got != "rt-new" {
+		t.Fatalf("stored refresh token = %q, want the other replica's rt-new", got)
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"fmt"
 
 	_ "github.com/lib/pq"
+
+	"github.com/rpay/apipod-smart-proxy/internal/secrets"
 )
 
 // DB wraps the PostgreSQL database connection
 type DB struct {
 	conn *sql.DB
+	dsn  string // kept for dedicated LISTEN connections
+
+	// Opens encrypted upstream credentials; nil stores and loads them as plaintext.
+	keyring *secrets.Keyring
 }
 
 const schema = `
@@ -53,6 +59,26 @@ CREATE TABLE IF NOT EXISTS usage_logs (
     timestamp     TIMESTAMP DEFAULT NOW()
 );
 
+-- Windowed rate-limit counters shared by all proxy replicas.
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    counter_key  VARCHAR(200) NOT NULL,
+    window_start TIMESTAMPTZ  NOT NULL,
+    count        BIGINT       NOT NULL DEFAULT 0,
+    PRIMARY KEY (counter_key, window_start)
+);
+
+-- OAuth refresh tokens for provider_accounts rows that authenticate with
+-- short-lived access tokens instead of a static api_key.
+CREATE TABLE IF NOT EXISTS provider_account_credentials (
+    account_id    INTEGER PRIMARY KEY,
+    token_url     TEXT NOT NULL,
+    client_id     TEXT,
+    client_secret TEXT,
+    scope         TEXT,
+    refresh_token TEXT NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 CREATE INDEX IF NOT EXISTS idx_apitoken    ON users(apitoken);
 CREATE INDEX IF NOT EXISTS idx_user_active ON users(active);
 CREATE INDEX IF NOT EXISTS idx_quota_sub   ON quota_items(sub_id);
@@ -61,10 +87,106 @@ CREATE INDEX IF NOT EXISTS idx_usage_quota ON usage_logs(quota_item_id);
 ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS rpm INTEGER;
 ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS tpm INTEGER;
 ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS rpd INTEGER;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS aliases TEXT[];
+
+-- Capability metadata used by the router; NULL means unknown (not filtered on).
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS max_context        INTEGER;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS supports_tools     BOOLEAN;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS supports_vision    BOOLEAN;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS supports_thinking  BOOLEAN;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS supports_streaming BOOLEAN;
+
+-- Model catalog metadata (see config.ModelCatalog); NULL falls through to the
+-- catalog file and built-in defaults.
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS max_output_tokens       INTEGER;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS request_timeout_seconds INTEGER;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS input_price_per_mtok    NUMERIC;
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS output_price_per_mtok   NUMERIC;
+
+-- Set by model discovery (local_ollama): whether a discovered model is still
+-- installed. NULL for rows managed by hand; FALSE rows are not routed to.
+ALTER TABLE llm_models ADD COLUMN IF NOT EXISTS installed BOOLEAN;
+
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS attempt INTEGER DEFAULT 1;
+
+-- USD charged by providers that report it per request (OpenRouter usage.cost).
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS cost_usd NUMERIC;
+
+-- Anthropic prompt-cache writes and reads, not included in input_tokens.
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS cache_creation_input_tokens INTEGER DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS cache_read_input_tokens INTEGER DEFAULT 0;
+
+-- CONFIG_MODE=database: per-user limits (NULL = unlimited), and a numeric id
+-- that rate limits and usage are keyed on (user_id is a ULID).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS rate_limit_rpm INTEGER;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS daily_quota    INTEGER;
+
+-- ADD COLUMN IF NOT EXISTS would still create the SERIAL sequence on some versions.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'org_id') THEN
+        ALTER TABLE users ADD COLUMN org_id SERIAL;
+    END IF;
+END $$;
+
+-- User changes are announced on the apipod_users channel so proxies can drop
+-- cached runtime configs. The payload is the hex SHA-256 of the affected apitoken.
+CREATE OR REPLACE FUNCTION apipod_notify_users() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('apipod_users', encode(sha256(convert_to(OLD.apitoken, 'UTF8')), 'hex'));
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('apipod_users', encode(sha256(convert_to(NEW.apitoken, 'UTF8')), 'hex'));
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS apipod_users_notify ON users;
+CREATE TRIGGER apipod_users_notify AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE PROCEDURE apipod_notify_users();
+
+-- Routing changes are announced on the apipod_routing channel so proxies can drop
+-- cached quota items. The payload is the affected sub_id, or '*' for everything.
+CREATE OR REPLACE FUNCTION apipod_notify_routing() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'quota_items' THEN
+        IF TG_OP IN ('UPDATE', 'DELETE') THEN
+            PERFORM pg_notify('apipod_routing', OLD.sub_id::text);
+        END IF;
+        IF TG_OP IN ('INSERT', 'UPDATE') THEN
+            PERFORM pg_notify('apipod_routing', NEW.sub_id::text);
+        END IF;
+    ELSE
+        PERFORM pg_notify('apipod_routing', '*');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS apipod_routing_notify ON quota_items;
+CREATE TRIGGER apipod_routing_notify AFTER INSERT OR UPDATE OR DELETE ON quota_items
+    FOR EACH ROW EXECUTE PROCEDURE apipod_notify_routing();
+
+DROP TRIGGER IF EXISTS apipod_routing_notify ON llm_models;
+CREATE TRIGGER apipod_routing_notify AFTER INSERT OR UPDATE OR DELETE ON llm_models
+    FOR EACH STATEMENT EXECUTE PROCEDURE apipod_notify_routing();
+
+-- providers is owned by the admin backend and may not exist yet on a fresh database.
+DO $$
+BEGIN
+    IF to_regclass('providers') IS NOT NULL THEN
+        DROP TRIGGER IF EXISTS apipod_routing_notify ON providers;
+        CREATE TRIGGER apipod_routing_notify AFTER INSERT OR UPDATE OR DELETE ON providers
+            FOR EACH STATEMENT EXECUTE PROCEDURE apipod_notify_routing();
+    END IF;
+END $$;
 `
 
 // New creates a new PostgreSQL database connection and initializes the schema
-func New(dsn string) (*DB, error) {
+func New(dsn string, keyring *secrets.Keyring) (*DB, error) {
 	conn, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -75,7 +197,7 @@ func New(dsn string) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, dsn: dsn, keyring: keyring}
 
 	if err := db.initSchema(); err != nil {
 		conn.Close()
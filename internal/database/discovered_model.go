@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DiscoveryProvider is a providers row whose installed models are discovered
+// by polling the upstream.
+type DiscoveryProvider struct {
+	ID      int64
+	BaseURL string
+	APIKey  string
+}
+
+// GetProvidersByType returns the providers of one provider_type.
+func (db *DB) GetProvidersByType(providerType string) ([]DiscoveryProvider, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, base_url, COALESCE(api_key, '') FROM providers WHERE provider_type = $1 ORDER BY id`,
+		providerType,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query providers: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DiscoveryProvider
+	for rows.Next() {
+		var p DiscoveryProvider
+		if err := rows.Scan(&p.ID, &p.BaseURL, &p.APIKey); err != nil {
+			return nil, fmt.Errorf("failed to scan provider: %w", err)
+		}
+		if p.APIKey, err = db.keyring.Decrypt(p.APIKey); err != nil {
+			return nil, fmt.Errorf("failed to decrypt api_key of provider %d: %w", p.ID, err)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// SyncDiscoveredModels makes the provider's llm_models rows match the models
+// installed on it. Missing models are inserted with installed = TRUE, and
+// discovered rows that are gone are set to installed = FALSE rather than
+// deleted, since quota_items may reference them. Rows created by hand
+// (installed IS NULL) are left alone. Nothing is written when nothing changed,
+// so an idle poll does not invalidate routing caches.
+func (db *DB) SyncDiscoveredModels(providerID int64, names []string) (added, removed []string, err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT model_name, installed FROM llm_models WHERE provider_id = $1`, providerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query models of provider %d: %w", providerID, err)
+	}
+	existing := make(map[string]sql.NullBool)
+	for rows.Next() {
+		var name string
+		var installed sql.NullBool
+		if err := rows.Scan(&name, &installed); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		existing[name] = installed
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	upstream := fmt.Sprintf("discovered:%d", providerID)
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+		installed, ok := existing[name]
+		switch {
+		case !ok:
+			_, err = tx.Exec(
+				`INSERT INTO llm_models (model_name, upstream, provider_id, installed)
+				 VALUES ($1, $2, $3, TRUE)
+				 ON CONFLICT (model_name, upstream) DO UPDATE SET installed = TRUE`,
+				name, upstream, providerID,
+			)
+		case installed.Valid && !installed.Bool:
+			_, err = tx.Exec(`UPDATE llm_models SET installed = TRUE WHERE provider_id = $1 AND model_name = $2`, providerID, name)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to add model %q of provider %d: %w", name, providerID, err)
+		}
+		added = append(added, name)
+	}
+	for name, installed := range existing {
+		if present[name] || !installed.Valid || !installed.Bool {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE llm_models SET installed = FALSE WHERE provider_id = $1 AND model_name = $2`, providerID, name); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove model %q of provider %d: %w", name, providerID, err)
+		}
+		removed = append(removed, name)
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil, nil
+	}
+	return added, removed, tx.Commit()
+}
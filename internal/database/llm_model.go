@@ -0,0 +1,80 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ModelCatalogRow is the per-model metadata in llm_models that feeds the model
+// catalog. Nil and zero fields are not set.
+type ModelCatalogRow struct {
+	ModelName             string
+	Aliases               []string
+	MaxContext            *int
+	MaxOutputTokens       *int
+	RequestTimeoutSeconds *int
+	InputPricePerMTok     *float64
+	OutputPricePerMTok    *float64
+
+	SupportsTools     *bool
+	SupportsVision    *bool
+	SupportsThinking  *bool
+	SupportsStreaming *bool
+}
+
+// GetModelCatalog loads the catalog metadata of every llm_models row.
+func (db *DB) GetModelCatalog() ([]ModelCatalogRow, error) {
+	rows, err := db.conn.Query(`
+		SELECT model_name, COALESCE(aliases, '{}'),
+		       max_context, max_output_tokens, request_timeout_seconds,
+		       input_price_per_mtok, output_price_per_mtok,
+		       supports_tools, supports_vision, supports_thinking, supports_streaming
+		FROM llm_models
+		ORDER BY llm_model_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var out []ModelCatalogRow
+	for rows.Next() {
+		var r ModelCatalogRow
+		var maxContext, maxOutput, timeout sql.NullInt64
+		var inPrice, outPrice sql.NullFloat64
+		var tools, vision, thinking, streaming sql.NullBool
+		if err := rows.Scan(
+			&r.ModelName, pq.Array(&r.Aliases),
+			&maxContext, &maxOutput, &timeout,
+			&inPrice, &outPrice,
+			&tools, &vision, &thinking, &streaming,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan model catalog row: %w", err)
+		}
+		r.MaxContext = nullIntPtr(maxContext)
+		r.MaxOutputTokens = nullIntPtr(maxOutput)
+		r.RequestTimeoutSeconds = nullIntPtr(timeout)
+		if inPrice.Valid {
+			r.InputPricePerMTok = &inPrice.Float64
+		}
+		if outPrice.Valid {
+			r.OutputPricePerMTok = &outPrice.Float64
+		}
+		r.SupportsTools = nullBoolPtr(tools)
+		r.SupportsVision = nullBoolPtr(vision)
+		r.SupportsThinking = nullBoolPtr(thinking)
+		r.SupportsStreaming = nullBoolPtr(streaming)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
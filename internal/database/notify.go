@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RoutingChannel is notified by triggers on quota_items, llm_models and providers.
+// The payload is the affected sub_id, or NotifyAll when every subscription may be affected.
+const RoutingChannel = "apipod_routing"
+
+// UsersChannel is notified by a trigger on users. The payload is the hex SHA-256
+// of the affected apitoken.
+const UsersChannel = "apipod_users"
+
+// NotifyAll is the payload meaning "invalidate everything". It is also delivered
+// after the listener reconnects, since notifications may have been missed.
+const NotifyAll = "*"
+
+// Listen subscribes to a NOTIFY channel on a dedicated connection and calls
+// onNotify for every payload until the process exits. The connection is
+// re-established automatically if it drops.
+func (db *DB) Listen(channel string, onNotify func(payload string), logger *log.Logger) error {
+	listener := pq.NewListener(db.dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Printf("LISTEN %s: %v", channel, err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+
+	go func() {
+		keepalive := time.NewTicker(90 * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case n := <-listener.Notify:
+				if n == nil {
+					// Reconnected: anything could have changed in between.
+					onNotify(NotifyAll)
+					continue
+				}
+				onNotify(n.Extra)
+			case <-keepalive.C:
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
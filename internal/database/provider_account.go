@@ -1,14 +1,34 @@
 package database
 
+import (
+	"database/sql"
+	"fmt"
+)
+
 type ProviderAccount struct {
 	ID         uint   `db:"id"`
 	ProviderID uint   `db:"provider_id"`
 	Email      string `db:"email"`
 	APIKey     string `db:"api_key"`
+	OAuth      bool   // has a row in provider_account_credentials
+}
+
+// AccountCredential is an account's OAuth refresh-token grant.
+type AccountCredential struct {
+	AccountID    uint
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	RefreshToken string
 }
 
 func (db *DB) GetAccountsForProvider(providerID uint) ([]ProviderAccount, error) {
-	rows, err := db.conn.Query("SELECT id, provider_id, email, api_key FROM provider_accounts WHERE provider_id = $1", providerID)
+	rows, err := db.conn.Query(`
+		SELECT a.id, a.provider_id, a.email, COALESCE(a.api_key, ''), c.account_id IS NOT NULL
+		FROM provider_accounts a
+		LEFT JOIN provider_account_credentials c ON c.account_id = a.id
+		WHERE a.provider_id = $1`, providerID)
 	if err != nil {
 		return nil, err
 	}
@@ -17,10 +37,91 @@ func (db *DB) GetAccountsForProvider(providerID uint) ([]ProviderAccount, error)
 	var accounts []ProviderAccount
 	for rows.Next() {
 		var acc ProviderAccount
-		if err := rows.Scan(&acc.ID, &acc.ProviderID, &acc.Email, &acc.APIKey); err != nil {
+		if err := rows.Scan(&acc.ID, &acc.ProviderID, &acc.Email, &acc.APIKey, &acc.OAuth); err != nil {
 			return nil, err
 		}
+		if acc.APIKey, err = db.keyring.Decrypt(acc.APIKey); err != nil {
+			return nil, fmt.Errorf("failed to decrypt api_key of account %d: %w", acc.ID, err)
+		}
 		accounts = append(accounts, acc)
 	}
 	return accounts, nil
 }
+
+// GetAccountCredential returns the OAuth grant for an account, or nil if it has none.
+func (db *DB) GetAccountCredential(accountID uint) (*AccountCredential, error) {
+	var c AccountCredential
+	var clientID, clientSecret, scope sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT account_id, token_url, client_id, client_secret, scope, refresh_token
+		 FROM provider_account_credentials WHERE account_id = $1`,
+		accountID,
+	).Scan(&c.AccountID, &c.TokenURL, &clientID, &clientSecret, &scope, &c.RefreshToken)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credential for account %d: %w", accountID, err)
+	}
+	c.ClientID, c.Scope = clientID.String, scope.String
+	if c.ClientSecret, err = db.keyring.Decrypt(clientSecret.String); err != nil {
+		return nil, fmt.Errorf("failed to decrypt client_secret of account %d: %w", accountID, err)
+	}
+	if c.RefreshToken, err = db.keyring.Decrypt(c.RefreshToken); err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh_token of account %d: %w", accountID, err)
+	}
+	return &c, nil
+}
+
+// UpdateRefreshToken replaces the account's refresh token with newToken, but
+// only if it is still oldToken: when replicas refresh the same account at
+// once, the first rotation wins and later ones are not written over it. It
+// reports whether the token was replaced. The new token is encrypted when a
+// keyring is configured.
+func (db *DB) UpdateRefreshToken(accountID uint, oldToken, newToken string) (bool, error) {
+	if db.keyring != nil {
+		sealed, err := db.keyring.Encrypt(newToken)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt refresh token for account %d: %w", accountID, err)
+		}
+		newToken = sealed
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Stored tokens may be sealed with a random nonce, so compare plaintexts
+	// under a row lock rather than in the UPDATE's WHERE clause.
+	var current string
+	err = tx.QueryRow(
+		`SELECT refresh_token FROM provider_account_credentials WHERE account_id = $1 FOR UPDATE`,
+		accountID,
+	).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to lock credential for account %d: %w", accountID, err)
+	}
+	if current, err = db.keyring.Decrypt(current); err != nil {
+		return false, fmt.Errorf("failed to decrypt refresh_token of account %d: %w", accountID, err)
+	}
+	if current != oldToken {
+		return false, nil
+	}
+
+	_, err = tx.Exec(
+		`UPDATE provider_account_credentials SET refresh_token = $2, updated_at = NOW() WHERE account_id = $1`,
+		accountID, newToken,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update refresh token for account %d: %w", accountID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to update refresh token for account %d: %w", accountID, err)
+	}
+	return true, nil
+}
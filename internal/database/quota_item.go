@@ -3,6 +3,8 @@ package database
 import (
 	"database/sql"
 	"fmt"
+
+	"github.com/lib/pq"
 )
 
 // QuotaItem represents a weighted model entry for a subscription plan
@@ -11,6 +13,7 @@ type QuotaItem struct {
 	SubID            int64
 	LLMModelID       int64
 	ModelName        string
+	Aliases          []string
 	PercentageWeight int
 	BaseURL          string
 	APIKey           string
@@ -19,6 +22,13 @@ type QuotaItem struct {
 	RPM              *int
 	TPM              *int
 	RPD              *int
+
+	// Capabilities; nil means unknown and is treated as supported.
+	MaxContext        *int
+	SupportsTools     *bool
+	SupportsVision    *bool
+	SupportsThinking  *bool
+	SupportsStreaming *bool
 }
 
 // GetQuotaItemsBySubID loads all quota items (with model and provider info) for a subscription
@@ -27,11 +37,13 @@ func (db *DB) GetQuotaItemsBySubID(subID int64) ([]QuotaItem, error) {
 		SELECT qi.quota_id, qi.sub_id, qi.llm_model_id,
 		       m.model_name, qi.percentage_weight,
 		       p.base_url, COALESCE(p.api_key, ''), p.provider_type, p.id,
-		       m.rpm, m.tpm, m.rpd
+		       m.rpm, m.tpm, m.rpd, COALESCE(m.aliases, '{}'),
+		       m.max_context, m.supports_tools, m.supports_vision,
+		       m.supports_thinking, m.supports_streaming
 		FROM quota_items qi
 		JOIN llm_models m ON m.llm_model_id = qi.llm_model_id
 		JOIN providers p ON p.id = m.provider_id
-		WHERE qi.sub_id = $1
+		WHERE qi.sub_id = $1 AND m.installed IS NOT FALSE
 	`
 
 	rows, err := db.conn.Query(query, subID)
@@ -43,15 +55,22 @@ func (db *DB) GetQuotaItemsBySubID(subID int64) ([]QuotaItem, error) {
 	var items []QuotaItem
 	for rows.Next() {
 		var qi QuotaItem
-		var rpm, tpm, rpd sql.NullInt64
+		var rpm, tpm, rpd, maxContext sql.NullInt64
+		var tools, vision, thinking, streaming sql.NullBool
 		if err := rows.Scan(
 			&qi.QuotaID, &qi.SubID, &qi.LLMModelID,
 			&qi.ModelName, &qi.PercentageWeight,
 			&qi.BaseURL, &qi.APIKey, &qi.ProviderType, &qi.ProviderID,
-			&rpm, &tpm, &rpd,
+			&rpm, &tpm, &rpd, pq.Array(&qi.Aliases),
+			&maxContext, &tools, &vision, &thinking, &streaming,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan quota item: %w", err)
 		}
+		apiKey, err := db.keyring.Decrypt(qi.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt api_key of provider %d: %w", qi.ProviderID, err)
+		}
+		qi.APIKey = apiKey
 		if rpm.Valid {
 			v := int(rpm.Int64)
 			qi.RPM = &v
@@ -64,7 +83,23 @@ func (db *DB) GetQuotaItemsBySubID(subID int64) ([]QuotaItem, error) {
 			v := int(rpd.Int64)
 			qi.RPD = &v
 		}
+		if maxContext.Valid {
+			v := int(maxContext.Int64)
+			qi.MaxContext = &v
+		}
+		qi.SupportsTools = nullBoolPtr(tools)
+		qi.SupportsVision = nullBoolPtr(vision)
+		qi.SupportsThinking = nullBoolPtr(thinking)
+		qi.SupportsStreaming = nullBoolPtr(streaming)
 		items = append(items, qi)
 	}
 	return items, rows.Err()
 }
+
+func nullBoolPtr(b sql.NullBool) *bool {
+	if !b.Valid {
+		return nil
+	}
+	v := b.Bool
+	return &v
+}
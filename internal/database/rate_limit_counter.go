@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AddRateLimitCounter atomically adds delta to a windowed rate-limit counter and
+// returns its new value.
+func (db *DB) AddRateLimitCounter(key string, window time.Time, delta int64) (int64, error) {
+	var count int64
+	err := db.conn.QueryRow(
+		`INSERT INTO rate_limit_counters (counter_key, window_start, count)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (counter_key, window_start)
+		 DO UPDATE SET count = rate_limit_counters.count + EXCLUDED.count
+		 RETURNING count`,
+		key, window, delta,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update rate limit counter: %w", err)
+	}
+	return count, nil
+}
+
+// GetRateLimitCounter returns the value of a windowed rate-limit counter (0 if unset).
+func (db *DB) GetRateLimitCounter(key string, window time.Time) (int64, error) {
+	var count int64
+	err := db.conn.QueryRow(
+		`SELECT count FROM rate_limit_counters WHERE counter_key = $1 AND window_start = $2`,
+		key, window,
+	).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rate limit counter: %w", err)
+	}
+	return count, nil
+}
+
+// PurgeRateLimitCounters deletes counters whose window started before the given time.
+func (db *DB) PurgeRateLimitCounters(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM rate_limit_counters WHERE window_start < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge rate limit counters: %w", err)
+	}
+	return res.RowsAffected()
+}
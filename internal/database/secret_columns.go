@@ -0,0 +1,76 @@
+package database
+
+import (
+	"fmt"
+)
+
+// secretColumns lists every column holding an upstream credential, with the
+// table's primary key.
+var secretColumns = []struct{ table, id, column string }{
+	{"providers", "id", "api_key"},
+	{"provider_accounts", "id", "api_key"},
+	{"provider_account_credentials", "account_id", "refresh_token"},
+	{"provider_account_credentials", "account_id", "client_secret"},
+}
+
+// ReencryptSecrets encrypts plaintext credentials and re-encrypts those sealed
+// with a retired master key under the keyring's primary key. With dryRun it only
+// counts. It returns the number of values (to be) rewritten per table.column;
+// tables that do not exist yet are skipped.
+func (db *DB) ReencryptSecrets(dryRun bool) (map[string]int, error) {
+	if db.keyring == nil {
+		return nil, fmt.Errorf("no master key configured")
+	}
+
+	counts := make(map[string]int)
+	for _, sc := range secretColumns {
+		var exists bool
+		if err := db.conn.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, sc.table).Scan(&exists); err != nil {
+			return counts, err
+		}
+		if !exists {
+			continue
+		}
+
+		rows, err := db.conn.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s <> ''`, sc.id, sc.column, sc.table, sc.column, sc.column))
+		if err != nil {
+			return counts, fmt.Errorf("failed to read %s.%s: %w", sc.table, sc.column, err)
+		}
+		type pending struct {
+			id    int64
+			value string
+		}
+		var todo []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.value); err != nil {
+				rows.Close()
+				return counts, err
+			}
+			if db.keyring.NeedsRekey(p.value) {
+				todo = append(todo, p)
+			}
+		}
+		rows.Close()
+
+		name := sc.table + "." + sc.column
+		for _, p := range todo {
+			plaintext, err := db.keyring.Decrypt(p.value)
+			if err != nil {
+				return counts, fmt.Errorf("%s of row %d: %w", name, p.id, err)
+			}
+			sealed, err := db.keyring.Encrypt(plaintext)
+			if err != nil {
+				return counts, err
+			}
+			if !dryRun {
+				// Only overwrite the value we read, in case it changed meanwhile.
+				if _, err := db.conn.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s = $3`, sc.table, sc.column, sc.id, sc.column), sealed, p.id, p.value); err != nil {
+					return counts, fmt.Errorf("failed to update %s of row %d: %w", name, p.id, err)
+				}
+			}
+			counts[name]++
+		}
+	}
+	return counts, nil
+}
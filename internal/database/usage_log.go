@@ -10,16 +10,31 @@ type UsageContext struct {
 	RoutedModel      string
 	UpstreamProvider string
 	StatusCode       int
+	Attempt          int     // 1-based failover attempt number
+	CostUSD          float64 // price reported by the provider (OpenRouter), 0 if unknown
+
+	// Anthropic prompt-cache tokens, reported apart from input tokens
+	CacheCreationTokens int
+	CacheReadTokens     int
 }
 
 // LogUsage inserts a usage log entry for a completed request
 func (db *DB) LogUsage(ctx UsageContext, inputTokens, outputTokens int) error {
 	totalTokens := inputTokens + outputTokens
+	attempt := ctx.Attempt
+	if attempt <= 0 {
+		attempt = 1
+	}
+	var cost *float64
+	if ctx.CostUSD > 0 {
+		cost = &ctx.CostUSD
+	}
 	_, err := db.conn.Exec(
-		`INSERT INTO usage_logs (quota_item_id, user_id, requested_model, routed_model, upstream_provider, status, token_count, input_tokens, output_tokens)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		`INSERT INTO usage_logs (quota_item_id, user_id, requested_model, routed_model, upstream_provider, status, token_count, input_tokens, output_tokens, attempt, cost_usd, cache_creation_input_tokens, cache_read_input_tokens)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
 		ctx.QuotaItemID, ctx.UserID, ctx.RequestedModel, ctx.RoutedModel, ctx.UpstreamProvider,
-		ctx.StatusCode, totalTokens, inputTokens, outputTokens,
+		ctx.StatusCode, totalTokens, inputTokens, outputTokens, attempt, cost,
+		ctx.CacheCreationTokens, ctx.CacheReadTokens,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to log usage: %w", err)
@@ -16,13 +16,20 @@ type User struct {
 	Active    bool
 	CreatedAt time.Time
 	ExpiresAt *time.Time
+
+	// Numeric ID the proxy keys rate limits and usage on, and per-user
+	// limits (0 = unlimited).
+	OrgID        uint
+	RateLimitRPM int
+	DailyQuota   int
 }
 
 // GetUserByAPIToken retrieves a user (with subscription name) by their API token
 func (db *DB) GetUserByAPIToken(apiToken string) (*User, error) {
 	query := `
-		SELECT u.id::text, u.name, u.apitoken, u.sub_id,
-		       COALESCE(s.sub_name, ''), u.active, u.created_at, u.expires_at
+		SELECT u.user_id, COALESCE(u.username, ''), u.apitoken, COALESCE(u.sub_id, 0),
+		       COALESCE(s.sub_name, ''), COALESCE(u.active, FALSE), u.created_at, u.expires_at,
+		       u.org_id, COALESCE(u.rate_limit_rpm, 0), COALESCE(u.daily_quota, 0)
 		FROM users u
 		LEFT JOIN subscriptions s ON s.sub_id = u.sub_id
 		WHERE u.apitoken = $1
@@ -40,6 +47,9 @@ func (db *DB) GetUserByAPIToken(apiToken string) (*User, error) {
 		&user.Active,
 		&user.CreatedAt,
 		&expiresAtSQL,
+		&user.OrgID,
+		&user.RateLimitRPM,
+		&user.DailyQuota,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -0,0 +1,311 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Breaker states.
+const (
+	StateClosed   = "closed"    // healthy, traffic flows normally
+	StateOpen     = "open"      // tripped, no traffic until OpenDuration elapses
+	StateHalfOpen = "half_open" // cooling off, limited trial requests decide the next state
+)
+
+// Config controls when a breaker trips and how it recovers.
+type Config struct {
+	ConsecutiveFailures int           // trip after this many failures in a row
+	FailureRate         float64       // trip when the failure ratio over the window reaches this (0 disables)
+	MinRequests         int           // outcomes required in the window before FailureRate applies
+	WindowSize          int           // number of recent outcomes kept per breaker
+	OpenDuration        time.Duration // how long a tripped breaker stays open
+	HalfOpenTrials      int           // concurrent trial requests allowed while half-open
+	TrialTimeout        time.Duration // a trial with no reported outcome frees its slot after this
+}
+
+// DefaultConfig returns conservative breaker settings.
+func DefaultConfig() Config {
+	return Config{
+		ConsecutiveFailures: 5,
+		FailureRate:         0.5,
+		MinRequests:         20,
+		WindowSize:          50,
+		OpenDuration:        30 * time.Second,
+		HalfOpenTrials:      1,
+		TrialTimeout:        time.Minute,
+	}
+}
+
+// breaker tracks outcomes and circuit state for one provider or model.
+type breaker struct {
+	state       string
+	openedAt    time.Time
+	consecutive int
+	outcomes    []bool // ring buffer, true = failure
+	next        int
+	filled      int
+
+	trials      int
+	trialsSince time.Time
+
+	successes   int64
+	failures    int64
+	latencyEWMA float64 // milliseconds
+	lastError   time.Time
+}
+
+// Tracker records upstream outcomes per ProviderID and LLMModelID and runs a
+// circuit breaker for each. A quota item is usable only while both its
+// provider's and its model's breakers allow traffic.
+type Tracker struct {
+	cfg Config
+	now func() time.Time
+
+	mu        sync.Mutex
+	providers map[int64]*breaker
+	models    map[int64]*breaker
+}
+
+// NewTracker creates a health tracker with the given breaker settings.
+func NewTracker(cfg Config) *Tracker {
+	if cfg.WindowSize <= 0 {
+		cfg.WindowSize = DefaultConfig().WindowSize
+	}
+	if cfg.HalfOpenTrials <= 0 {
+		cfg.HalfOpenTrials = 1
+	}
+	return &Tracker{
+		cfg:       cfg,
+		now:       time.Now,
+		providers: make(map[int64]*breaker),
+		models:    make(map[int64]*breaker),
+	}
+}
+
+// Available reports whether traffic may be sent to the provider/model pair,
+// i.e. neither breaker is open. Half-open breakers count as available; use
+// Acquire to claim one of their trial slots.
+func (t *Tracker) Available(providerID, modelID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	return t.stateOf(t.providers, providerID, now) != StateOpen &&
+		t.stateOf(t.models, modelID, now) != StateOpen
+}
+
+// Acquire claims a request slot for the pair. It always succeeds for closed
+// breakers and fails for open ones; a half-open breaker admits up to
+// HalfOpenTrials concurrent trial requests.
+func (t *Tracker) Acquire(providerID, modelID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	pb := t.get(t.providers, providerID)
+	mb := t.get(t.models, modelID)
+	if !t.canTrial(pb, now) || !t.canTrial(mb, now) {
+		return false
+	}
+	t.claimTrial(pb, now)
+	t.claimTrial(mb, now)
+	return true
+}
+
+// Release hands back a slot claimed by Acquire for a request that was never
+// sent, freeing the trial slot of a half-open breaker.
+func (t *Tracker) Release(providerID, modelID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.releaseTrial(t.get(t.providers, providerID), now)
+	t.releaseTrial(t.get(t.models, modelID), now)
+}
+
+// Record reports the outcome of one upstream request.
+func (t *Tracker) Record(providerID, modelID int64, success bool, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.record(t.get(t.providers, providerID), success, latency, now)
+	t.record(t.get(t.models, modelID), success, latency, now)
+}
+
+func (t *Tracker) get(m map[int64]*breaker, id int64) *breaker {
+	b, ok := m[id]
+	if !ok {
+		b = &breaker{state: StateClosed, outcomes: make([]bool, t.cfg.WindowSize)}
+		m[id] = b
+	}
+	return b
+}
+
+// stateOf returns the effective state, moving open breakers whose cool-off has
+// elapsed to half-open.
+func (t *Tracker) stateOf(m map[int64]*breaker, id int64, now time.Time) string {
+	b, ok := m[id]
+	if !ok {
+		return StateClosed
+	}
+	t.advance(b, now)
+	return b.state
+}
+
+func (t *Tracker) advance(b *breaker, now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= t.cfg.OpenDuration {
+		b.state = StateHalfOpen
+		b.trials = 0
+	}
+}
+
+func (t *Tracker) canTrial(b *breaker, now time.Time) bool {
+	t.advance(b, now)
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.trials > 0 && now.Sub(b.trialsSince) >= t.cfg.TrialTimeout {
+			b.trials = 0 // trials never reported back, free their slots
+		}
+		return b.trials < t.cfg.HalfOpenTrials
+	}
+	return true
+}
+
+func (t *Tracker) claimTrial(b *breaker, now time.Time) {
+	if b.state != StateHalfOpen {
+		return
+	}
+	if b.trials == 0 {
+		b.trialsSince = now
+	}
+	b.trials++
+}
+
+func (t *Tracker) releaseTrial(b *breaker, now time.Time) {
+	t.advance(b, now)
+	if b.state == StateHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (t *Tracker) record(b *breaker, success bool, latency time.Duration, now time.Time) {
+	t.advance(b, now)
+
+	ms := float64(latency.Milliseconds())
+	if b.latencyEWMA == 0 {
+		b.latencyEWMA = ms
+	} else {
+		b.latencyEWMA = 0.8*b.latencyEWMA + 0.2*ms
+	}
+
+	b.outcomes[b.next] = !success
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.filled < len(b.outcomes) {
+		b.filled++
+	}
+
+	if success {
+		b.successes++
+		b.consecutive = 0
+		if b.state == StateHalfOpen {
+			t.close(b)
+		}
+		return
+	}
+
+	b.failures++
+	b.consecutive++
+	b.lastError = now
+
+	switch {
+	case b.state == StateHalfOpen:
+		t.open(b, now)
+	case t.cfg.ConsecutiveFailures > 0 && b.consecutive >= t.cfg.ConsecutiveFailures:
+		t.open(b, now)
+	case t.cfg.FailureRate > 0 && b.filled >= t.cfg.MinRequests && t.failureRate(b) >= t.cfg.FailureRate:
+		t.open(b, now)
+	}
+}
+
+func (t *Tracker) failureRate(b *breaker) float64 {
+	failed := 0
+	for i := 0; i < b.filled; i++ {
+		if b.outcomes[i] {
+			failed++
+		}
+	}
+	return float64(failed) / float64(b.filled)
+}
+
+func (t *Tracker) open(b *breaker, now time.Time) {
+	b.state = StateOpen
+	b.openedAt = now
+	b.trials = 0
+}
+
+func (t *Tracker) close(b *breaker) {
+	b.state = StateClosed
+	b.consecutive = 0
+	b.trials = 0
+	b.filled = 0
+	b.next = 0
+}
+
+// BreakerStatus is the externally visible state of one breaker.
+type BreakerStatus struct {
+	ID            int64      `json:"id"`
+	State         string     `json:"state"`
+	Successes     int64      `json:"successes"`
+	Failures      int64      `json:"failures"`
+	AvgLatencyMs  int64      `json:"avg_latency_ms"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// Snapshot lists every known provider and model breaker.
+type Snapshot struct {
+	Providers []BreakerStatus `json:"providers"`
+	Models    []BreakerStatus `json:"models"`
+}
+
+// Snapshot returns the current state of all breakers.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	return Snapshot{
+		Providers: t.statuses(t.providers, now),
+		Models:    t.statuses(t.models, now),
+	}
+}
+
+func (t *Tracker) statuses(m map[int64]*breaker, now time.Time) []BreakerStatus {
+	out := make([]BreakerStatus, 0, len(m))
+	for id, b := range m {
+		t.advance(b, now)
+		status := BreakerStatus{
+			ID:           id,
+			State:        b.state,
+			Successes:    b.successes,
+			Failures:     b.failures,
+			AvgLatencyMs: int64(b.latencyEWMA),
+		}
+		if !b.lastError.IsZero() {
+			lastError := b.lastError
+			status.LastFailureAt = &lastError
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Handler returns an http.HandlerFunc that serves the breaker snapshot as JSON.
+func (t *Tracker) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Snapshot())
+	}
+}
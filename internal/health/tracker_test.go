@@ -0,0 +1,83 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTracker(cfg Config) (*Tracker, *time.Time) {
+	now := time.Unix(1_700_000_000, 0)
+	t := NewTracker(cfg)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestBreakerTripsOnConsecutiveFailures(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsecutiveFailures = 3
+	cfg.FailureRate = 0
+	tr, now := newTestTracker(cfg)
+
+	for i := 0; i < 2; i++ {
+		tr.Record(1, 10, false, time.Second)
+	}
+	if !tr.Available(1, 10) {
+		t.Fatal("breaker tripped before reaching the threshold")
+	}
+
+	tr.Record(1, 10, false, time.Second)
+	if tr.Available(1, 10) {
+		t.Fatal("breaker should be open after 3 consecutive failures")
+	}
+	if tr.Available(1, 11) {
+		t.Fatal("other models of an open provider should be unavailable")
+	}
+
+	// Cool-off elapses: half-open admits exactly one trial.
+	*now = now.Add(cfg.OpenDuration)
+	if !tr.Available(1, 10) {
+		t.Fatal("breaker should be half-open after OpenDuration")
+	}
+	if !tr.Acquire(1, 10) {
+		t.Fatal("first trial should be admitted")
+	}
+	if tr.Acquire(1, 10) {
+		t.Fatal("second concurrent trial should be rejected")
+	}
+
+	tr.Record(1, 10, true, 200*time.Millisecond)
+	if !tr.Acquire(1, 10) || !tr.Acquire(1, 10) {
+		t.Fatal("breaker should be closed after a successful trial")
+	}
+}
+
+func TestBreakerTripsOnFailureRate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsecutiveFailures = 0
+	cfg.FailureRate = 0.5
+	cfg.MinRequests = 10
+	tr, _ := newTestTracker(cfg)
+
+	for i := 0; i < 10; i++ {
+		tr.Record(2, 20, i%2 == 0, time.Second)
+	}
+	if tr.Available(2, 20) {
+		t.Fatal("breaker should be open at a 50% failure rate")
+	}
+}
+
+func TestHalfOpenFailureReopens(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsecutiveFailures = 1
+	tr, now := newTestTracker(cfg)
+
+	tr.Record(3, 30, false, time.Second)
+	*now = now.Add(cfg.OpenDuration)
+	if !tr.Acquire(3, 30) {
+		t.Fatal("trial should be admitted once half-open")
+	}
+	tr.Record(3, 30, false, time.Second)
+	if tr.Available(3, 30) {
+		t.Fatal("failed trial should reopen the breaker")
+	}
+}
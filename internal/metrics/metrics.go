@@ -17,6 +17,9 @@ type Metrics struct {
 	success   int64
 	cacheHits int64
 
+	pinned          int64 // requests routed via a session-affinity pin
+	pinnedCacheHits int64
+
 	mu        sync.Mutex
 	latencies []int64 // end-to-end ms, non-cache-hit requests only
 }
@@ -28,6 +31,11 @@ type Snapshot struct {
 	CacheHitRate  float64 `json:"cache_hit_rate"` // percentage 0–100
 	AvgLatencyMs  float64 `json:"avg_latency_ms"`
 	P95LatencyMs  int64   `json:"p95_latency_ms"`
+
+	// Session affinity: cache-hit rates of pinned vs. freshly routed requests.
+	PinnedRequests       int64   `json:"pinned_requests"`
+	PinnedCacheHitRate   float64 `json:"pinned_cache_hit_rate"`   // percentage 0–100
+	UnpinnedCacheHitRate float64 `json:"unpinned_cache_hit_rate"` // percentage 0–100
 }
 
 func New() *Metrics {
@@ -42,15 +50,23 @@ func New() *Metrics {
 //
 //	Anthropic; prompt_tokens_details.cached_tokens > 0 for OpenAI).
 //
+// pinned    – the route came from a session-affinity pin.
+//
 // Cache-hit latencies are excluded from avg/P95 because they are artificially
 // faster and would skew the numbers.
-func (m *Metrics) Record(latencyMs int64, success, cacheHit bool) {
+func (m *Metrics) Record(latencyMs int64, success, cacheHit, pinned bool) {
 	atomic.AddInt64(&m.total, 1)
 	if success {
 		atomic.AddInt64(&m.success, 1)
 	}
+	if pinned {
+		atomic.AddInt64(&m.pinned, 1)
+	}
 	if cacheHit {
 		atomic.AddInt64(&m.cacheHits, 1)
+		if pinned {
+			atomic.AddInt64(&m.pinnedCacheHits, 1)
+		}
 		return
 	}
 	m.mu.Lock()
@@ -69,12 +85,21 @@ func (m *Metrics) Snapshot() Snapshot {
 	total := atomic.LoadInt64(&m.total)
 	success := atomic.LoadInt64(&m.success)
 	cacheHits := atomic.LoadInt64(&m.cacheHits)
+	pinned := atomic.LoadInt64(&m.pinned)
+	pinnedHits := atomic.LoadInt64(&m.pinnedCacheHits)
 
 	var successRate, cacheHitRate float64
 	if total > 0 {
 		successRate = float64(success) / float64(total) * 100
 		cacheHitRate = float64(cacheHits) / float64(total) * 100
 	}
+	var pinnedHitRate, unpinnedHitRate float64
+	if pinned > 0 {
+		pinnedHitRate = float64(pinnedHits) / float64(pinned) * 100
+	}
+	if unpinned := total - pinned; unpinned > 0 {
+		unpinnedHitRate = float64(cacheHits-pinnedHits) / float64(unpinned) * 100
+	}
 
 	m.mu.Lock()
 	lats := make([]int64, len(m.latencies))
@@ -103,6 +128,10 @@ func (m *Metrics) Snapshot() Snapshot {
 		CacheHitRate:  math.Round(cacheHitRate*10) / 10,
 		AvgLatencyMs:  math.Round(avgMs),
 		P95LatencyMs:  p95Ms,
+
+		PinnedRequests:       pinned,
+		PinnedCacheHitRate:   math.Round(pinnedHitRate*10) / 10,
+		UnpinnedCacheHitRate: math.Round(unpinnedHitRate*10) / 10,
 	}
 }
 
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth protects operator endpoints with a shared secret, sent as
+// "X-Admin-Secret: <secret>" or "Authorization: Bearer <secret>". With an
+// empty secret the endpoints are disabled and answer 404.
+func AdminAuth(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		given := r.Header.Get("X-Admin-Secret")
+		if given == "" {
+			given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			http.Error(w, `{"error": "Invalid admin secret"}`, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InternalAuth protects endpoints the backend calls with INTERNAL_API_SECRET,
+// sent as "X-Internal-Secret: <secret>" like the proxy's own backend calls.
+// With an empty secret the endpoints are disabled and answer 404.
+func InternalAuth(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Internal-Secret")), []byte(secret)) != 1 {
+			http.Error(w, `{"error": "Invalid internal secret"}`, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -66,7 +66,7 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 
 			// Map reason to status code
 			statusCode := http.StatusForbidden
-			if strings.Contains(reason, "Invalid") || strings.Contains(reason, "revoked") {
+			if strings.Contains(reason, "Invalid") || strings.Contains(reason, "revoked") || strings.Contains(reason, "expired") {
 				statusCode = http.StatusUnauthorized
 			} else if strings.Contains(reason, "exceeded") || strings.Contains(reason, "limit") {
 				statusCode = http.StatusTooManyRequests
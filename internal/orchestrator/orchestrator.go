@@ -160,8 +160,8 @@ func (o *Orchestrator) BuildExecuteRequest(originalBody []byte, intent string, p
 
 	// Apply model-specific token limits to prevent bloated requests
 	limits := config.GetModelLimits(model)
-	if limits.MaxOutputTokens > 0 {
-		maxTokensJSON, _ := json.Marshal(limits.MaxOutputTokens)
+	if limits.OrchestratorMaxTokens > 0 {
+		maxTokensJSON, _ := json.Marshal(limits.OrchestratorMaxTokens)
 		req["max_tokens"] = maxTokensJSON
 	}
 
@@ -0,0 +1,151 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Admission queue orders.
+const (
+	OrderFIFO     = "fifo"     // strictly first come, first served
+	OrderPriority = "priority" // higher RuntimeConfig.Priority first, FIFO within a priority
+)
+
+// admissionPoll is how often the head of a queue re-checks the limiter.
+const admissionPoll = 100 * time.Millisecond
+
+// waiter is one request queued for a model.
+type waiter struct {
+	rank int
+	wake chan struct{} // signalled when the waiter becomes head of its queue
+}
+
+// AdmissionQueue holds requests for a saturated model until the ModelLimiter
+// has capacity again, instead of rejecting them straight away. Each
+// llm_model_id has its own queue, and only its head may take a freed slot, so
+// waiting requests are released in order.
+type AdmissionQueue struct {
+	limiter *ModelLimiter
+	order   string
+
+	mu      sync.Mutex
+	waiting map[int64][]*waiter
+}
+
+// NewAdmissionQueue creates an admission queue in front of limiter.
+// order is OrderFIFO or OrderPriority.
+func NewAdmissionQueue(limiter *ModelLimiter, order string) *AdmissionQueue {
+	return &AdmissionQueue{
+		limiter: limiter,
+		order:   order,
+		waiting: make(map[int64][]*waiter),
+	}
+}
+
+// TryAdmit takes a slot for modelID without waiting, reserving estimatedTokens
+// (see ModelLimiter.Reserve, whose reservation time it returns). It fails while
+// other requests are queued for the model, so newcomers cannot jump the queue.
+// The limiter is called without holding q.mu, so one model's slow counters do
+// not hold up queueing for the others.
+func (q *AdmissionQueue) TryAdmit(modelID int64, estimatedTokens int) (time.Time, bool) {
+	q.mu.Lock()
+	queued := len(q.waiting[modelID]) > 0
+	q.mu.Unlock()
+	if queued {
+		return time.Time{}, false
+	}
+	return q.limiter.Reserve(modelID, estimatedTokens)
+}
+
+// Wait queues for a slot on modelID until one frees up or ctx is done, and
+// returns the reservation time like TryAdmit. priority is the caller's
+// RuntimeConfig.Priority ("high", "normal", "low").
+func (q *AdmissionQueue) Wait(ctx context.Context, modelID int64, estimatedTokens int, priority string) (time.Time, bool) {
+	w := &waiter{rank: priorityRank(priority), wake: make(chan struct{}, 1)}
+
+	q.mu.Lock()
+	q.enqueue(modelID, w)
+	q.mu.Unlock()
+
+	ticker := time.NewTicker(admissionPoll)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		head := q.waiting[modelID][0] == w
+		q.mu.Unlock()
+		if head {
+			// Only w's own goroutine removes w, so it is still queued here.
+			if reservedAt, ok := q.limiter.Reserve(modelID, estimatedTokens); ok {
+				q.mu.Lock()
+				q.remove(modelID, w)
+				q.mu.Unlock()
+				return reservedAt, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.remove(modelID, w)
+			q.mu.Unlock()
+			return time.Time{}, false
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Len returns the number of requests queued for modelID.
+func (q *AdmissionQueue) Len(modelID int64) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting[modelID])
+}
+
+func (q *AdmissionQueue) enqueue(modelID int64, w *waiter) {
+	queue := q.waiting[modelID]
+	pos := len(queue)
+	if q.order == OrderPriority {
+		for pos > 0 && queue[pos-1].rank < w.rank {
+			pos--
+		}
+	}
+	queue = append(queue, nil)
+	copy(queue[pos+1:], queue[pos:])
+	queue[pos] = w
+	q.waiting[modelID] = queue
+}
+
+// remove drops w from its queue and wakes the new head, if it changed.
+func (q *AdmissionQueue) remove(modelID int64, w *waiter) {
+	queue := q.waiting[modelID]
+	for i, other := range queue {
+		if other != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(q.waiting, modelID)
+			return
+		}
+		q.waiting[modelID] = queue
+		if i == 0 {
+			select {
+			case queue[0].wake <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+}
+
+func priorityRank(priority string) int {
+	switch priority {
+	case "high":
+		return 2
+	case "low":
+		return 0
+	}
+	return 1
+}
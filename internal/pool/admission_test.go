@@ -0,0 +1,66 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueuePriorityOrder(t *testing.T) {
+	ml := NewModelLimiter(nil, nil)
+	q := NewAdmissionQueue(ml, OrderPriority)
+
+	limit := 1
+	ml.SetLimits(1, &limit, nil, nil)
+	if _, ok := q.TryAdmit(1, 0); !ok {
+		t.Fatal("first request should be admitted")
+	}
+	if _, ok := q.TryAdmit(1, 0); ok {
+		t.Fatal("second request should exceed the limit")
+	}
+
+	admitted := make(chan string, 3)
+	for i, p := range []string{"low", "normal", "high"} {
+		p := p
+		go func() {
+			if _, ok := q.Wait(context.Background(), 1, 0, p); ok {
+				admitted <- p
+			}
+		}()
+		for q.Len(1) < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, ok := q.TryAdmit(1, 0); ok {
+		t.Fatal("TryAdmit must not jump the queue")
+	}
+
+	for i, want := range []string{"high", "normal", "low"} {
+		l := limit + i + 1 // the limiter keeps the pointer, so never change one it holds
+		ml.SetLimits(1, &l, nil, nil)
+		select {
+		case got := <-admitted:
+			if got != want {
+				t.Fatalf("release %d: got %s, want %s", i, got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("release %d: nothing admitted", i)
+		}
+	}
+}
+
+func TestAdmissionQueueDeadline(t *testing.T) {
+	ml := NewModelLimiter(nil, nil)
+	q := NewAdmissionQueue(ml, OrderFIFO)
+	zero := 0
+	ml.SetLimits(1, &zero, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, ok := q.Wait(ctx, 1, 0, "normal"); ok {
+		t.Fatal("Wait should give up at the deadline")
+	}
+	if q.Len(1) != 0 {
+		t.Fatal("expired waiter left in the queue")
+	}
+}
@@ -0,0 +1,74 @@
+package pool
+
+import (
+	"math"
+	"time"
+)
+
+// LimitStatus describes one rate limit as of a check, for rate-limit headers.
+type LimitStatus struct {
+	Limit     int
+	Remaining int
+	// Reset is when the limit is fully replenished, or, once nothing remains,
+	// when the next request fits again.
+	Reset time.Time
+}
+
+// Tighter returns whichever of a and b runs out first. Nil means unlimited.
+func Tighter(a, b *LimitStatus) *LimitStatus {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.Remaining != b.Remaining:
+		if a.Remaining < b.Remaining {
+			return a
+		}
+		return b
+	case a.Reset.After(b.Reset):
+		return a
+	}
+	return b
+}
+
+// SlidingMinuteStatus reports limit, remaining and reset for key over the
+// rolling minute ending at now (see SlidingMinute). need is the size of the
+// next request, used for Reset once the limit is exhausted.
+func SlidingMinuteStatus(store CounterStore, key string, now time.Time, limit, need int) *LimitStatus {
+	bucket := now.Truncate(time.Minute)
+	prev := float64(max(store.Get(key, bucket.Add(-time.Minute)), 0))
+	cur := float64(max(store.Get(key, bucket), 0))
+	used := prev*(1-float64(now.Sub(bucket))/float64(time.Minute)) + cur
+
+	remaining := max(int(math.Floor(float64(limit)-used)), 0)
+	room := 0.0 // wait until usage drains completely
+	if remaining < need {
+		room = float64(max(limit-need, 0))
+	}
+	return &LimitStatus{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     slidingReset(prev, cur, room, bucket, now),
+	}
+}
+
+// slidingReset returns when the rolling-minute usage, made up of prev and cur
+// buckets with cur starting at bucket, drops to room.
+func slidingReset(prev, cur, room float64, bucket, now time.Time) time.Time {
+	if cur <= room {
+		if prev <= 0 {
+			return now
+		}
+		// prev*(1-f) + cur == room, f being the elapsed fraction of the bucket
+		f := 1 - (room-cur)/prev
+		t := bucket.Add(time.Duration(f * float64(time.Minute)))
+		if t.Before(now) {
+			return now
+		}
+		return t
+	}
+	// The current bucket has to start sliding out as well.
+	f := 1 - room/cur
+	return bucket.Add(time.Minute + time.Duration(f*float64(time.Minute)))
+}
@@ -1,57 +1,44 @@
 package pool
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
 
-// modelState tracks rate limit counters for a single LLM model.
+// modelState holds the configured rate limits for a single LLM model.
+// The counters themselves live in the limiter's CounterStore.
 type modelState struct {
 	rpm *int // nil = unlimited
 	tpm *int
 	rpd *int
-
-	minuteRequests int
-	minuteTokens   int
-	dayRequests    int
 }
 
-// ModelLimiter enforces per-model rate limits (RPM, TPM, RPD).
+// ModelLimiter enforces per-model rate limits (RPM, TPM, RPD). RPM and TPM use
+// rolling one-minute windows; RPD resets at midnight in the configured location.
 type ModelLimiter struct {
+	store CounterStore
+	loc   *time.Location
+	now   func() time.Time
+
 	mu     sync.Mutex
 	models map[int64]*modelState // keyed by llm_model_id
 }
 
-// NewModelLimiter creates a limiter with background reset goroutines.
-func NewModelLimiter() *ModelLimiter {
-	ml := &ModelLimiter{
-		models: make(map[int64]*modelState),
+// NewModelLimiter creates a limiter counting in store (in process if nil) whose
+// daily limits reset at midnight in loc (UTC if nil).
+func NewModelLimiter(store CounterStore, loc *time.Location) *ModelLimiter {
+	if store == nil {
+		store = NewMemoryStore()
 	}
-	go ml.resetMinute()
-	go ml.resetDay()
-	return ml
-}
-
-func (ml *ModelLimiter) resetMinute() {
-	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		ml.mu.Lock()
-		for _, s := range ml.models {
-			s.minuteRequests = 0
-			s.minuteTokens = 0
-		}
-		ml.mu.Unlock()
+	if loc == nil {
+		loc = time.UTC
 	}
-}
-
-func (ml *ModelLimiter) resetDay() {
-	ticker := time.NewTicker(24 * time.Hour)
-	for range ticker.C {
-		ml.mu.Lock()
-		for _, s := range ml.models {
-			s.dayRequests = 0
-		}
-		ml.mu.Unlock()
+	return &ModelLimiter{
+		store:  store,
+		loc:    loc,
+		now:    time.Now,
+		models: make(map[int64]*modelState),
 	}
 }
 
@@ -75,40 +62,95 @@ func (ml *ModelLimiter) SetLimits(modelID int64, rpm, tpm, rpd *int) {
 	s.rpd = rpd
 }
 
-// AllowRequest checks RPM and RPD limits. Returns true if the request is allowed.
-// Increments counters on success.
-func (ml *ModelLimiter) AllowRequest(modelID int64) bool {
+// AllowRequest checks the RPM, RPD and TPM limits for a request expected to use
+// estimatedTokens. On success the request is counted and estimatedTokens are
+// reserved against TPM until RecordTokens reconciles them with actual usage.
+func (ml *ModelLimiter) AllowRequest(modelID int64, estimatedTokens int) bool {
+	_, ok := ml.Reserve(modelID, estimatedTokens)
+	return ok
+}
+
+// Reserve is AllowRequest that also returns when the reservation was taken,
+// to be passed to RecordTokens.
+func (ml *ModelLimiter) Reserve(modelID int64, estimatedTokens int) (time.Time, bool) {
 	ml.mu.Lock()
 	defer ml.mu.Unlock()
 	s := ml.getOrCreate(modelID)
+	now := ml.now()
+	minute := now.Truncate(time.Minute)
+	day := ml.dayStart(now)
+	rpmKey, tpmKey, rpdKey := modelKeys(modelID)
 
-	if s.rpm != nil && s.minuteRequests >= *s.rpm {
-		return false
+	if s.rpm != nil && SlidingMinute(ml.store, rpmKey, now)+1 > float64(*s.rpm) {
+		return time.Time{}, false
+	}
+	if s.rpd != nil && ml.store.Get(rpdKey, day) >= int64(*s.rpd) {
+		return time.Time{}, false
 	}
-	if s.rpd != nil && s.dayRequests >= *s.rpd {
-		return false
+	if s.tpm != nil {
+		// A request larger than the whole budget is let through once the window
+		// is empty; otherwise it could never be admitted.
+		used := SlidingMinute(ml.store, tpmKey, now)
+		if used > 0 && used+float64(estimatedTokens) > float64(*s.tpm) {
+			return time.Time{}, false
+		}
 	}
 
-	s.minuteRequests++
-	s.dayRequests++
-	return true
+	ml.store.Add(rpmKey, minute, 1)
+	ml.store.Add(tpmKey, minute, int64(estimatedTokens))
+	ml.store.Add(rpdKey, day, 1)
+	return now, true
 }
 
-// CheckTPM returns true if the model's TPM limit has NOT been exceeded yet.
-func (ml *ModelLimiter) CheckTPM(modelID int64) bool {
-	ml.mu.Lock()
-	defer ml.mu.Unlock()
-	s := ml.getOrCreate(modelID)
-	if s.tpm != nil && s.minuteTokens >= *s.tpm {
-		return false
+// RecordTokens replaces a request's reserved token estimate with the tokens it
+// actually used. Failed requests pass actual=0 to release the reservation.
+// The difference goes to the minute the reservation was taken in (reservedAt,
+// as returned by Reserve), even if the request finished in a later one;
+// requests without a reservation count in the current minute.
+func (ml *ModelLimiter) RecordTokens(modelID int64, reservedAt time.Time, reserved, actual int) {
+	if actual == reserved {
+		return
 	}
-	return true
+	if reservedAt.IsZero() {
+		reservedAt = ml.now()
+	}
+	_, tpmKey, _ := modelKeys(modelID)
+	ml.store.Add(tpmKey, reservedAt.Truncate(time.Minute), int64(actual-reserved))
 }
 
-// RecordTokens adds token usage for TPM tracking.
-func (ml *ModelLimiter) RecordTokens(modelID int64, tokens int) {
+// Status reports the model's request limit (the tighter of RPM and RPD) and
+// TPM limit for a request of estimatedTokens. Either is nil when unlimited.
+func (ml *ModelLimiter) Status(modelID int64, estimatedTokens int) (requests, tokens *LimitStatus) {
 	ml.mu.Lock()
 	defer ml.mu.Unlock()
 	s := ml.getOrCreate(modelID)
-	s.minuteTokens += tokens
+	now := ml.now()
+	rpmKey, tpmKey, rpdKey := modelKeys(modelID)
+
+	if s.rpm != nil {
+		requests = SlidingMinuteStatus(ml.store, rpmKey, now, *s.rpm, 1)
+	}
+	if s.rpd != nil {
+		day := ml.dayStart(now)
+		requests = Tighter(requests, &LimitStatus{
+			Limit:     *s.rpd,
+			Remaining: max(*s.rpd-int(ml.store.Get(rpdKey, day)), 0),
+			Reset:     day.AddDate(0, 0, 1),
+		})
+	}
+	if s.tpm != nil {
+		tokens = SlidingMinuteStatus(ml.store, tpmKey, now, *s.tpm, estimatedTokens)
+	}
+	return requests, tokens
+}
+
+// dayStart returns midnight of now's day in ml.loc.
+func (ml *ModelLimiter) dayStart(now time.Time) time.Time {
+	local := now.In(ml.loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, ml.loc)
+}
+
+func modelKeys(modelID int64) (rpm, tpm, rpd string) {
+	prefix := fmt.Sprintf("model:%d:", modelID)
+	return prefix + "rpm", prefix + "tpm", prefix + "rpd"
 }
@@ -0,0 +1,144 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newTestLimiter(loc *time.Location, start time.Time) (*ModelLimiter, *fakeClock) {
+	clock := &fakeClock{t: start}
+	ml := NewModelLimiter(nil, loc)
+	ml.now = clock.now
+	return ml, clock
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestModelLimiterRPMSlidesAcrossMinuteBoundary(t *testing.T) {
+	ml, clock := newTestLimiter(nil, time.Date(2026, 1, 1, 12, 0, 50, 0, time.UTC))
+	ml.SetLimits(1, intPtr(10), nil, nil)
+
+	for i := 0; i < 10; i++ {
+		if !ml.AllowRequest(1, 0) {
+			t.Fatalf("request %d rejected under the limit", i)
+		}
+	}
+	if ml.AllowRequest(1, 0) {
+		t.Fatal("11th request allowed")
+	}
+
+	// 15s later the minute bucket has turned over, but the burst still counts
+	// for most of the rolling window; a fixed window would allow 10 more here.
+	clock.advance(15 * time.Second)
+	if ml.AllowRequest(1, 0) {
+		t.Fatal("request allowed right after the bucket boundary")
+	}
+
+	// Half of the previous bucket has slid out of the window at 12:01:30.
+	clock.advance(25 * time.Second)
+	allowed := 0
+	for ml.AllowRequest(1, 0) {
+		allowed++
+	}
+	if allowed != 5 {
+		t.Fatalf("allowed %d requests halfway through the window, want 5", allowed)
+	}
+}
+
+func TestModelLimiterTPMReservation(t *testing.T) {
+	ml, clock := newTestLimiter(nil, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	ml.SetLimits(1, nil, intPtr(1000), nil)
+
+	reservedAt, ok := ml.Reserve(1, 600)
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	if ml.AllowRequest(1, 600) {
+		t.Fatal("second reservation should exceed TPM")
+	}
+
+	// The first request turned out smaller than estimated.
+	ml.RecordTokens(1, reservedAt, 600, 200)
+	if !ml.AllowRequest(1, 600) {
+		t.Fatal("request rejected after reconciling the reservation")
+	}
+
+	// A request bigger than the whole budget gets through once the window is empty.
+	clock.advance(2 * time.Minute)
+	if !ml.AllowRequest(1, 5000) {
+		t.Fatal("oversized request rejected on an empty window")
+	}
+}
+
+func TestModelLimiterReconcilesInReservationMinute(t *testing.T) {
+	ml, clock := newTestLimiter(nil, time.Date(2026, 1, 1, 12, 0, 50, 0, time.UTC))
+	ml.SetLimits(1, nil, intPtr(10000), nil)
+	_, tpmKey, _ := modelKeys(1)
+	minuteN := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	minuteN1 := minuteN.Add(time.Minute)
+
+	reservedAt, ok := ml.Reserve(1, 600)
+	if !ok {
+		t.Fatal("long request rejected")
+	}
+	clock.advance(20 * time.Second) // 12:01:10
+	if !ml.AllowRequest(1, 300) {
+		t.Fatal("second request rejected")
+	}
+
+	// The long request finishes in the next minute having used 200 tokens.
+	ml.RecordTokens(1, reservedAt, 600, 200)
+	if got := ml.store.Get(tpmKey, minuteN); got != 200 {
+		t.Errorf("minute N = %d tokens, want 200", got)
+	}
+	if got := ml.store.Get(tpmKey, minuteN1); got != 300 {
+		t.Errorf("minute N+1 = %d tokens, want the other request's 300", got)
+	}
+}
+
+func TestModelLimiterRPDResetsAtLocalMidnight(t *testing.T) {
+	loc := time.FixedZone("UTC-8", -8*60*60)
+	ml, clock := newTestLimiter(loc, time.Date(2026, 1, 1, 23, 0, 0, 0, loc))
+	ml.SetLimits(1, nil, nil, intPtr(2))
+
+	ml.AllowRequest(1, 0)
+	ml.AllowRequest(1, 0)
+	if ml.AllowRequest(1, 0) {
+		t.Fatal("RPD exceeded")
+	}
+
+	clock.advance(59 * time.Minute) // 23:59 local, 07:59 UTC
+	if ml.AllowRequest(1, 0) {
+		t.Fatal("RPD reset before local midnight")
+	}
+	clock.advance(time.Minute)
+	if !ml.AllowRequest(1, 0) {
+		t.Fatal("RPD not reset at local midnight")
+	}
+}
+
+func TestModelLimiterStatus(t *testing.T) {
+	ml, clock := newTestLimiter(nil, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	ml.SetLimits(1, intPtr(2), intPtr(1000), intPtr(100))
+
+	ml.AllowRequest(1, 400)
+	ml.AllowRequest(1, 400)
+	clock.advance(30 * time.Second)
+
+	requests, tokens := ml.Status(1, 400)
+	if requests == nil || requests.Limit != 2 || requests.Remaining != 0 {
+		t.Fatalf("requests = %+v, want RPM limit 2 with 0 remaining", requests)
+	}
+	// Both requests sit in the current bucket, so the next one fits once half of
+	// it has slid out of the window: at 12:01:30.
+	if want := time.Date(2026, 1, 1, 12, 1, 30, 0, time.UTC); !requests.Reset.Equal(want) {
+		t.Fatalf("requests reset = %s, want %s", requests.Reset, want)
+	}
+	if tokens == nil || tokens.Remaining != 200 {
+		t.Fatalf("tokens = %+v, want 200 remaining", tokens)
+	}
+}
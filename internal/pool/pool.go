@@ -1,38 +1,257 @@
 package pool
 
-import "sync"
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultCooldown applies after a 429 that carries no usable reset header.
+const defaultCooldown = time.Minute
 
 type Account struct {
 	ID     uint
 	Email  string
 	APIKey string
+	OAuth  bool // authenticates with access tokens from the credentials manager instead of APIKey
+
+	// Readiness, guarded by the owning pool's mutex.
+	inFlight      int
+	lastUsed      time.Time
+	cooldownUntil time.Time
+	disabled      bool
+	lastError     string
+	lastErrorAt   time.Time
+}
+
+// AccountState is a snapshot of an account's readiness.
+type AccountState struct {
+	InFlight      int
+	CooldownUntil time.Time // zero unless cooling down after a 429
+	Disabled      bool      // after a 401/403 or Fail; the key is not used again until Reenable or a rotated key
 }
 
 type AccountPool struct {
 	Accounts []*Account
 	mu       sync.Mutex
-	index    int // round-robin index for spreading load
+	now      func() time.Time
 }
 
 func NewAccountPool() *AccountPool {
 	return &AccountPool{
 		Accounts: []*Account{},
+		now:      time.Now,
 	}
 }
 
-// GetReadyAccount picks the next account via round-robin.
+// GetReadyAccount picks the ready account (not disabled, not cooling down)
+// with the fewest requests in flight, preferring the least recently used on a
+// tie, and counts the request against it. Every account returned must be
+// handed back through Report. Returns nil if no account is ready.
 func (p *AccountPool) GetReadyAccount() *Account {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	now := p.now()
 
-	n := len(p.Accounts)
-	if n == 0 {
+	var best *Account
+	for _, acc := range p.Accounts {
+		if acc.disabled || now.Before(acc.cooldownUntil) {
+			continue
+		}
+		if best == nil || acc.inFlight < best.inFlight ||
+			(acc.inFlight == best.inFlight && acc.lastUsed.Before(best.lastUsed)) {
+			best = acc
+		}
+	}
+	if best == nil {
 		return nil
 	}
+	best.inFlight++
+	best.lastUsed = now
+	return best
+}
+
+// Report records the upstream outcome of a request made with acc and releases
+// its in-flight slot. A 429 puts the account on cooldown until the upstream's
+// reset time; 401/403 disable it. status 0 means the request never got a response
+// (transport error). Any error status is kept as the account's last error for
+// the admin view.
+func (p *AccountPool) Report(acc *Account, status int, header http.Header) AccountState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+
+	if acc.inFlight > 0 {
+		acc.inFlight--
+	}
+	if status == 0 || status >= 400 {
+		acc.lastErrorAt = now
+		acc.lastError = fmt.Sprintf("upstream status %d", status)
+		if status == 0 {
+			acc.lastError = "transport error"
+		}
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		cooldown, ok := RetryAfter(header, now)
+		if !ok {
+			cooldown = defaultCooldown
+		}
+		acc.cooldownUntil = now.Add(cooldown)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		acc.disabled = true
+	}
+	return p.state(acc, now)
+}
+
+// Release hands back an account whose request outcome is not tracked.
+func (p *AccountPool) Release(acc *Account) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if acc.inFlight > 0 {
+		acc.inFlight--
+	}
+}
+
+// Fail releases an account whose request could not be sent, e.g. because no
+// access token could be obtained, recording err as its last error. disable
+// takes the account out of rotation until Reenable.
+func (p *AccountPool) Fail(acc *Account, err error, disable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if acc.inFlight > 0 {
+		acc.inFlight--
+	}
+	acc.lastError = err.Error()
+	acc.lastErrorAt = p.now()
+	if disable {
+		acc.disabled = true
+	}
+}
+
+// State returns a snapshot of acc's readiness.
+func (p *AccountPool) State(acc *Account) AccountState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state(acc, p.now())
+}
+
+func (p *AccountPool) state(acc *Account, now time.Time) AccountState {
+	s := AccountState{InFlight: acc.inFlight, Disabled: acc.disabled}
+	if now.Before(acc.cooldownUntil) {
+		s.CooldownUntil = acc.cooldownUntil
+	}
+	return s
+}
+
+// Sync replaces the pool's accounts with accounts, e.g. freshly loaded from the
+// database. Accounts that are still present (same ID and key) keep their runtime
+// state such as cooldowns, disabled flags and in-flight counts; a rotated key
+// starts afresh, so replacing a revoked key brings the account back. It returns how many accounts were added and removed.
+func (p *AccountPool) Sync(accounts []*Account) (added, removed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	acc := p.Accounts[p.index%n]
-	p.index = (p.index + 1) % n
-	return acc
+	existing := make(map[uint]*Account, len(p.Accounts))
+	for _, acc := range p.Accounts {
+		existing[acc.ID] = acc
+	}
+	next := make([]*Account, 0, len(accounts))
+	for _, acc := range accounts {
+		if old, ok := existing[acc.ID]; ok && old.APIKey == acc.APIKey && old.OAuth == acc.OAuth {
+			old.Email = acc.Email
+			next = append(next, old)
+			delete(existing, acc.ID)
+			continue
+		}
+		if _, ok := existing[acc.ID]; ok {
+			delete(existing, acc.ID)
+			removed++
+		}
+		next = append(next, acc)
+		added++
+	}
+	removed += len(existing)
+	p.Accounts = next
+	return added, removed
+}
+
+// Reenable puts disabled accounts back into rotation, e.g. after an admin
+// fixed their credentials upstream. 429 cooldowns stay. It returns how many
+// accounts came back.
+func (p *AccountPool) Reenable() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, acc := range p.Accounts {
+		if acc.disabled {
+			acc.disabled = false
+			n++
+		}
+	}
+	return n
+}
+
+// AccountStatus is the externally visible state of one pooled account.
+type AccountStatus struct {
+	ID            uint       `json:"id"`
+	Email         string     `json:"email"`
+	Key           string     `json:"key"`   // masked
+	State         string     `json:"state"` // "ready", "cooldown" or "disabled"
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	InFlight      int        `json:"in_flight"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Snapshot returns the state of every account in the pool, ordered by ID.
+func (p *AccountPool) Snapshot() []AccountStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+
+	out := make([]AccountStatus, 0, len(p.Accounts))
+	for _, acc := range p.Accounts {
+		status := AccountStatus{
+			ID:          acc.ID,
+			Email:       acc.Email,
+			Key:         MaskKey(acc.APIKey),
+			State:       "ready",
+			InFlight:    acc.inFlight,
+			LastUsedAt:  timePtr(acc.lastUsed),
+			LastError:   acc.lastError,
+			LastErrorAt: timePtr(acc.lastErrorAt),
+		}
+		switch {
+		case acc.disabled:
+			status.State = "disabled"
+		case now.Before(acc.cooldownUntil):
+			status.State = "cooldown"
+			status.CooldownUntil = timePtr(acc.cooldownUntil)
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// MaskKey keeps only the first and last four characters of an API key.
+func MaskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
 
 // Size returns the number of accounts in the pool.
@@ -0,0 +1,198 @@
+package pool
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testPool(n int) (*AccountPool, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	p := NewAccountPool()
+	p.now = clock.now
+	for i := 1; i <= n; i++ {
+		p.Accounts = append(p.Accounts, &Account{ID: uint(i)})
+	}
+	return p, clock
+}
+
+func TestGetReadyAccountPrefersLeastInFlight(t *testing.T) {
+	p, clock := testPool(3)
+
+	a := p.GetReadyAccount()
+	clock.advance(time.Second)
+	b := p.GetReadyAccount()
+	clock.advance(time.Second)
+	c := p.GetReadyAccount()
+	if a == b || b == c || a == c {
+		t.Fatalf("concurrent requests should spread over accounts, got %d %d %d", a.ID, b.ID, c.ID)
+	}
+
+	// b finishes first, so it is the only one with nothing in flight.
+	p.Report(b, http.StatusOK, nil)
+	clock.advance(time.Second)
+	if got := p.GetReadyAccount(); got != b {
+		t.Fatalf("picked %d, want idle account %d", got.ID, b.ID)
+	}
+}
+
+func TestAccountCooldownAndDisable(t *testing.T) {
+	p, clock := testPool(2)
+
+	a := p.GetReadyAccount()
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+	state := p.Report(a, http.StatusTooManyRequests, header)
+	if want := clock.t.Add(30 * time.Second); !state.CooldownUntil.Equal(want) {
+		t.Fatalf("cooldown until %s, want %s", state.CooldownUntil, want)
+	}
+
+	b := p.GetReadyAccount()
+	if b == a {
+		t.Fatal("cooling account was picked")
+	}
+	if state := p.Report(b, http.StatusForbidden, nil); !state.Disabled {
+		t.Fatal("403 should disable the account")
+	}
+	if got := p.GetReadyAccount(); got != nil {
+		t.Fatalf("picked %d while every account is unavailable", got.ID)
+	}
+
+	clock.advance(31 * time.Second)
+	if got := p.GetReadyAccount(); got != a {
+		t.Fatalf("cooldown did not expire, got %v", got)
+	}
+}
+
+func TestDisabledAccountStaysOut(t *testing.T) {
+	p, clock := testPool(1)
+	acc := p.Accounts[0]
+	acc.APIKey = "revoked"
+
+	p.GetReadyAccount()
+	p.Report(acc, http.StatusUnauthorized, nil)
+	clock.advance(24 * time.Hour)
+	if got := p.GetReadyAccount(); got != nil {
+		t.Fatal("a disabled account came back on its own")
+	}
+
+	// Replacing the key in the database brings it back on the next sync.
+	p.Sync([]*Account{{ID: 1, APIKey: "rotated"}})
+	if got := p.GetReadyAccount(); got == nil || got.APIKey != "rotated" {
+		t.Fatalf("rotated key not in rotation, got %v", got)
+	}
+}
+
+func TestSyncKeepsRuntimeState(t *testing.T) {
+	p, _ := testPool(3)
+	for _, acc := range p.Accounts {
+		acc.APIKey = "key-" + string(rune('0'+acc.ID))
+	}
+	one, two := p.Accounts[0], p.Accounts[1]
+	p.GetReadyAccount() // one in flight
+	p.Report(two, http.StatusTooManyRequests, http.Header{"Retry-After": {"30"}})
+
+	added, removed := p.Sync([]*Account{
+		{ID: 1, APIKey: "key-1", Email: "renamed@example.com"},
+		{ID: 2, APIKey: "rotated"},
+		{ID: 4, APIKey: "key-4"},
+	})
+	if added != 2 || removed != 2 {
+		t.Fatalf("added=%d removed=%d, want 2 and 2 (key 2 rotated, 3 dropped, 4 new)", added, removed)
+	}
+	if p.Size() != 3 {
+		t.Fatalf("size %d, want 3", p.Size())
+	}
+	if p.Accounts[0] != one || one.Email != "renamed@example.com" || p.State(one).InFlight != 1 {
+		t.Fatal("unchanged account should keep its state and pick up new metadata")
+	}
+	if p.Accounts[1] == two || !p.State(p.Accounts[1]).CooldownUntil.IsZero() {
+		t.Fatal("rotated key should start without the old cooldown")
+	}
+
+	snap := p.Snapshot()
+	if snap[0].State != "ready" || snap[0].InFlight != 1 || snap[1].State != "ready" {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+}
+
+func TestReenable(t *testing.T) {
+	p, _ := testPool(3)
+	for _, acc := range p.Accounts {
+		acc.APIKey = "key"
+	}
+	locked, revoked, limited := p.Accounts[0], p.Accounts[1], p.Accounts[2]
+	p.Report(locked, http.StatusForbidden, nil)
+	p.Fail(revoked, errors.New("invalid_grant"), true)
+	p.Report(limited, http.StatusTooManyRequests, nil)
+
+	// The periodic reload keeps them all out of rotation.
+	p.Sync([]*Account{{ID: 1, APIKey: "key"}, {ID: 2, APIKey: "key"}, {ID: 3, APIKey: "key"}})
+	if got := p.GetReadyAccount(); got != nil {
+		t.Fatalf("sync brought back account %d", got.ID)
+	}
+
+	if n := p.Reenable(); n != 2 {
+		t.Fatalf("re-enabled %d accounts, want 2", n)
+	}
+	if p.State(locked).Disabled || p.State(revoked).Disabled {
+		t.Fatal("account still disabled")
+	}
+	if p.State(limited).CooldownUntil.IsZero() {
+		t.Fatal("a 429 cooldown must survive Reenable")
+	}
+}
+
+func TestSnapshotReportsErrorsAndMasksKeys(t *testing.T) {
+	p, _ := testPool(2)
+	p.Accounts[0].APIKey = "sk-abcdefghijkl"
+	a := p.GetReadyAccount()
+	p.Report(a, http.StatusUnauthorized, nil)
+
+	snap := p.Snapshot()
+	if snap[0].Key != "sk-a...ijkl" {
+		t.Fatalf("key %q not masked", snap[0].Key)
+	}
+	if snap[0].State != "disabled" || snap[0].LastError != "upstream status 401" || snap[0].LastErrorAt == nil {
+		t.Fatalf("unexpected status %+v", snap[0])
+	}
+	if snap[1].LastError != "" || snap[1].LastErrorAt != nil {
+		t.Fatalf("untouched account has an error: %+v", snap[1])
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+		ok     bool
+	}{
+		{"seconds", map[string]string{"Retry-After": "7"}, 7 * time.Second, true},
+		{"http date", map[string]string{"Retry-After": now.Add(time.Minute).Format(http.TimeFormat)}, time.Minute, true},
+		{"ms", map[string]string{"retry-after-ms": "1500", "Retry-After": "2"}, 1500 * time.Millisecond, true},
+		{"openai reset", map[string]string{
+			"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "6m0s",
+			"x-ratelimit-remaining-tokens": "100", "x-ratelimit-reset-tokens": "1s",
+		}, 6 * time.Minute, true},
+		{"anthropic reset", map[string]string{
+			"anthropic-ratelimit-tokens-remaining": "0",
+			"anthropic-ratelimit-tokens-reset":     now.Add(20 * time.Second).Format(time.RFC3339),
+		}, 20 * time.Second, true},
+		{"none", map[string]string{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			got, ok := RetryAfter(h, now)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("RetryAfter = %s, %v; want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package pool
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfter works out how long to back off from a rate-limited upstream
+// response. It understands, in order of preference:
+//
+//	retry-after-ms                      milliseconds (OpenAI)
+//	Retry-After                         seconds or an HTTP date (RFC 9110)
+//	anthropic-ratelimit-*-reset         RFC 3339 timestamp (Anthropic)
+//	x-ratelimit-reset-{requests,tokens} duration such as "6m0s" or "20ms" (OpenAI, Groq)
+//
+// For the reset families the longest exhausted limit wins. ok is false when
+// none of the headers is present or parseable.
+func RetryAfter(header http.Header, now time.Time) (d time.Duration, ok bool) {
+	if header == nil {
+		return 0, false
+	}
+	if v := header.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+
+	for _, kind := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		if header.Get("anthropic-ratelimit-"+kind+"-remaining") != "0" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, header.Get("anthropic-ratelimit-"+kind+"-reset")); err == nil {
+			d, ok = max(d, t.Sub(now)), true
+		}
+	}
+	for _, kind := range []string{"requests", "tokens"} {
+		if header.Get("x-ratelimit-remaining-"+kind) != "0" {
+			continue
+		}
+		if reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-" + kind)); err == nil {
+			d, ok = max(d, reset), true
+		}
+	}
+	return d, ok
+}
@@ -0,0 +1,156 @@
+package pool
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// CounterBackend persists counters shared by all replicas. *database.DB implements it.
+type CounterBackend interface {
+	AddRateLimitCounter(key string, window time.Time, delta int64) (int64, error)
+	GetRateLimitCounter(key string, window time.Time) (int64, error)
+	PurgeRateLimitCounters(before time.Time) (int64, error)
+}
+
+// SharedStore is a CounterStore backed by a CounterBackend, so every replica
+// enforces the same limits. To keep the database off the request path, adds are
+// batched locally and flushed every interval, and reads are served from the last
+// synced value plus local pending deltas. A read of a value older than interval
+// starts a background refresh and does not wait for it, so callers holding
+// locks (ModelLimiter, AdmissionQueue) are never stuck behind a query.
+// Limits can therefore overshoot by what other replicas admitted within one
+// interval. Backend errors are logged and the store keeps counting locally.
+type SharedStore struct {
+	backend  CounterBackend
+	interval time.Duration
+	logger   *log.Logger
+
+	mu        sync.Mutex
+	entries   map[counterKey]*sharedEntry
+	lastPurge time.Time
+}
+
+type sharedEntry struct {
+	global   int64 // value in the backend as of syncedAt
+	pending  int64 // local adds not flushed yet
+	flushing int64 // local adds currently being flushed
+	syncedAt time.Time
+	gen      uint64 // bumped by every flush, so a slow read cannot overwrite a newer value
+
+	refreshing bool // a background read is in flight
+}
+
+func (e *sharedEntry) value() int64 {
+	return e.global + e.pending + e.flushing
+}
+
+// NewSharedStore creates a shared store and starts its background flusher.
+func NewSharedStore(backend CounterBackend, interval time.Duration, logger *log.Logger) *SharedStore {
+	s := &SharedStore{
+		backend:  backend,
+		interval: interval,
+		logger:   logger,
+		entries:  make(map[counterKey]*sharedEntry),
+	}
+	go s.flushLoop()
+	return s
+}
+
+func (s *SharedStore) Get(key string, window time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(counterKey{key, window.Unix()})
+	if time.Since(e.syncedAt) >= s.interval && !e.refreshing {
+		e.refreshing = true
+		go s.refresh(key, window, e, e.gen)
+	}
+	return e.value()
+}
+
+// refresh reads e's global value from the backend. The result is dropped while
+// a flush of e is in flight: the backend may already include the flushing
+// delta, which value() would then count twice. The flush sets global itself.
+func (s *SharedStore) refresh(key string, window time.Time, e *sharedEntry, gen uint64) {
+	global, err := s.backend.GetRateLimitCounter(key, window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.logger.Printf("WARN [rate_limit] read %s failed, counting locally: %v", key, err)
+	} else if e.gen == gen && e.flushing == 0 {
+		e.global = global
+	}
+	e.syncedAt = time.Now() // on error too, so a down database isn't hit on every request
+	e.refreshing = false
+}
+
+func (s *SharedStore) Add(key string, window time.Time, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(counterKey{key, window.Unix()}).pending += delta
+}
+
+func (s *SharedStore) entry(k counterKey) *sharedEntry {
+	e, ok := s.entries[k]
+	if !ok {
+		e = &sharedEntry{}
+		s.entries[k] = e
+	}
+	return e
+}
+
+func (s *SharedStore) flushLoop() {
+	ticker := time.NewTicker(s.interval)
+	for range ticker.C {
+		s.flush()
+	}
+}
+
+// flush writes pending deltas to the backend and drops expired counters.
+func (s *SharedStore) flush() {
+	now := time.Now()
+	cutoff := now.Add(-counterRetention)
+
+	s.mu.Lock()
+	var dirty []counterKey
+	for k, e := range s.entries {
+		if k.window < cutoff.Unix() {
+			delete(s.entries, k)
+			continue
+		}
+		if e.pending != 0 && e.flushing == 0 {
+			e.flushing, e.pending = e.pending, 0
+			dirty = append(dirty, k)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, k := range dirty {
+		s.mu.Lock()
+		e := s.entries[k]
+		delta := e.flushing
+		s.mu.Unlock()
+
+		global, err := s.backend.AddRateLimitCounter(k.key, time.Unix(k.window, 0), delta)
+
+		s.mu.Lock()
+		if err != nil {
+			s.logger.Printf("WARN [rate_limit] flush %s failed, will retry: %v", k.key, err)
+			e.pending += e.flushing
+		} else {
+			e.global = global
+			e.syncedAt = time.Now()
+			e.gen++
+		}
+		e.flushing = 0
+		s.mu.Unlock()
+	}
+
+	if now.Sub(s.lastPurge) > time.Hour {
+		s.lastPurge = now
+		if _, err := s.backend.PurgeRateLimitCounters(cutoff); err != nil {
+			s.logger.Printf("WARN [rate_limit] purge failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package pool
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	mu       sync.Mutex
+	counters map[counterKey]int64
+	adds     int
+	reads    int
+	err      error
+}
+
+func (b *fakeBackend) AddRateLimitCounter(key string, window time.Time, delta int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.adds++
+	b.counters[counterKey{key, window.Unix()}] += delta
+	return b.counters[counterKey{key, window.Unix()}], nil
+}
+
+func (b *fakeBackend) GetRateLimitCounter(key string, window time.Time) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.counters[counterKey{key, window.Unix()}], nil
+}
+
+func (b *fakeBackend) PurgeRateLimitCounters(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestSharedStoreBatchesAndSharesCounts(t *testing.T) {
+	backend := &fakeBackend{counters: make(map[counterKey]int64)}
+	logger := log.New(io.Discard, "", 0)
+	a := NewSharedStore(backend, time.Hour, logger)
+	b := NewSharedStore(backend, time.Hour, logger)
+	window := time.Now().Truncate(time.Minute)
+
+	for i := 0; i < 5; i++ {
+		a.Add("org:1:rpm", window, 1)
+	}
+	if backend.adds != 0 {
+		t.Fatalf("adds hit the backend before a flush (%d writes)", backend.adds)
+	}
+	if got := a.Get("org:1:rpm", window); got != 5 {
+		t.Fatalf("local view = %d, want 5", got)
+	}
+
+	a.flush()
+	if backend.adds != 1 {
+		t.Fatalf("flush made %d writes, want 1 batched write", backend.adds)
+	}
+
+	b.Add("org:1:rpm", window, 2)
+	b.Get("org:1:rpm", window) // starts the background read
+	waitFor(t, func() bool { return b.Get("org:1:rpm", window) == 7 }, "second replica to see 7")
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// slowBackend blocks reads until release is closed.
+type slowBackend struct {
+	fakeBackend
+	release chan struct{}
+}
+
+func (b *slowBackend) GetRateLimitCounter(key string, window time.Time) (int64, error) {
+	<-b.release
+	return b.fakeBackend.GetRateLimitCounter(key, window)
+}
+
+func TestSharedStoreSlowBackendDoesNotBlockAdmission(t *testing.T) {
+	backend := &slowBackend{fakeBackend: fakeBackend{counters: make(map[counterKey]int64)}, release: make(chan struct{})}
+	defer close(backend.release)
+	ml := NewModelLimiter(NewSharedStore(backend, time.Hour, log.New(io.Discard, "", 0)), nil)
+	limit := 10
+	ml.SetLimits(1, &limit, &limit, &limit)
+	ml.SetLimits(2, &limit, nil, nil)
+
+	done := make(chan bool)
+	go func() {
+		ml.AllowRequest(1, 1) // every counter of model 1 is stale
+		done <- ml.AllowRequest(2, 1)
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("model 2 was refused")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("admission waited for the database")
+	}
+}
+
+func TestSharedStoreKeepsCountingWhenBackendFails(t *testing.T) {
+	backend := &fakeBackend{counters: make(map[counterKey]int64), err: errors.New("connection refused")}
+	s := NewSharedStore(backend, time.Hour, log.New(io.Discard, "", 0))
+	window := time.Now().Truncate(time.Minute)
+
+	s.Add("k", window, 3)
+	s.flush()
+	if got := s.Get("k", window); got != 3 {
+		t.Fatalf("Get = %d during outage, want 3", got)
+	}
+
+	backend.err = nil
+	s.flush()
+	if got := backend.counters[counterKey{"k", window.Unix()}]; got != 3 {
+		t.Fatalf("backend = %d after recovery, want 3", got)
+	}
+}
+
+// blockingAddBackend applies adds straight away but holds the reply until
+// release is closed, like a commit whose response is still on the wire.
+type blockingAddBackend struct {
+	fakeBackend
+	added   chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAddBackend) AddRateLimitCounter(key string, window time.Time, delta int64) (int64, error) {
+	global, err := b.fakeBackend.AddRateLimitCounter(key, window, delta)
+	close(b.added)
+	<-b.release
+	return global, err
+}
+
+func TestSharedStoreRefreshDuringFlushDoesNotDoubleCount(t *testing.T) {
+	backend := &blockingAddBackend{
+		fakeBackend: fakeBackend{counters: make(map[counterKey]int64)},
+		added:       make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	s := NewSharedStore(backend, time.Hour, log.New(io.Discard, "", 0))
+	window := time.Now().Truncate(time.Minute)
+	k := counterKey{"k", window.Unix()}
+
+	s.Add("k", window, 5)
+	flushed := make(chan struct{})
+	go func() {
+		s.flush()
+		close(flushed)
+	}()
+	<-backend.added // the backend holds 5 while the store still counts them as flushing
+
+	s.mu.Lock()
+	s.entries[k].syncedAt = time.Time{}
+	s.mu.Unlock()
+	s.Get("k", window) // a refresh now reads 5 from the backend
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return !s.entries[k].refreshing
+	}, "the refresh to land")
+	if got := s.Get("k", window); got != 5 {
+		t.Fatalf("Get = %d with a refresh during the flush, want 5", got)
+	}
+
+	close(backend.release)
+	<-flushed
+	if got := s.Get("k", window); got != 5 {
+		t.Fatalf("Get = %d after the flush, want 5", got)
+	}
+}
@@ -0,0 +1,71 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// counterRetention is how long counters are kept after their window started.
+// The longest window in use is a day.
+const counterRetention = 48 * time.Hour
+
+// CounterStore holds the windowed counters behind RateLimiter and ModelLimiter.
+// Counters are identified by a key and the start of their window.
+type CounterStore interface {
+	// Get returns the counter for key in the window starting at window.
+	Get(key string, window time.Time) int64
+	// Add adds delta (which may be negative) to the counter for key in the window starting at window.
+	Add(key string, window time.Time, delta int64)
+}
+
+type counterKey struct {
+	key    string
+	window int64 // unix seconds
+}
+
+// MemoryStore keeps counters in process. Each replica counts on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[counterKey]int64
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an in-process counter store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[counterKey]int64)}
+}
+
+func (s *MemoryStore) Get(key string, window time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[counterKey{key, window.Unix()}]
+}
+
+func (s *MemoryStore) Add(key string, window time.Time, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[counterKey{key, window.Unix()}] += delta
+
+	// Windows only move forward, so the newest one doubles as the clock for
+	// dropping counters nobody reads any more.
+	if window.Sub(s.lastSweep) > time.Hour {
+		s.lastSweep = window
+		cutoff := window.Add(-counterRetention).Unix()
+		for k := range s.counters {
+			if k.window < cutoff {
+				delete(s.counters, k)
+			}
+		}
+	}
+}
+
+// SlidingMinute estimates usage of key over the rolling minute ending at now.
+// It combines the current minute's bucket with the previous one, weighted by
+// how much of it still overlaps the rolling window. This tracks providers'
+// rolling limits closely without keeping a timestamp per request or token.
+func SlidingMinute(store CounterStore, key string, now time.Time) float64 {
+	cur := now.Truncate(time.Minute)
+	overlap := 1 - float64(now.Sub(cur))/float64(time.Minute)
+	prev := max(store.Get(key, cur.Add(-time.Minute)), 0)
+	return float64(prev)*overlap + float64(max(store.Get(key, cur), 0))
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
+)
+
+// PoolStatus is one provider's account pool as shown by the admin endpoint.
+type PoolStatus struct {
+	ProviderID int64                `json:"provider_id"`
+	Accounts   []pool.AccountStatus `json:"accounts"`
+}
+
+func (h *Handler) loadAccounts(providerID int64) ([]*pool.Account, error) {
+	rows, err := h.source.GetAccountsForProvider(uint(providerID))
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]*pool.Account, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, &pool.Account{
+			ID:     row.ID,
+			Email:  row.Email,
+			APIKey: row.APIKey,
+			OAuth:  row.OAuth,
+		})
+	}
+	return accounts, nil
+}
+
+// RefreshPools reloads the accounts of every cached pool from the database.
+// Accounts that are unchanged keep their cooldowns and in-flight counts; a
+// provider left with no accounts drops its pool and falls back to its own key.
+// Providers that fail to load keep their current pool.
+func (h *Handler) RefreshPools() {
+	h.poolMu.Lock()
+	ids := make([]int64, 0, len(h.pools))
+	for id := range h.pools {
+		ids = append(ids, id)
+	}
+	h.poolMu.Unlock()
+
+	for _, id := range ids {
+		accounts, err := h.loadAccounts(id)
+		if err != nil {
+			h.logger.Printf("Failed to refresh accounts for provider %d: %v", id, err)
+			continue
+		}
+
+		h.poolMu.Lock()
+		p, ok := h.pools[id]
+		if ok && len(accounts) == 0 {
+			delete(h.pools, id)
+		}
+		h.poolMu.Unlock()
+		if !ok {
+			continue
+		}
+
+		if len(accounts) == 0 {
+			h.runnerLogger.Printf("POOL [removed] provider=%d accounts=0", id)
+			continue
+		}
+		if added, removed := p.Sync(accounts); added > 0 || removed > 0 {
+			h.runnerLogger.Printf("POOL [reloaded] provider=%d accounts=%d added=%d removed=%d", id, len(accounts), added, removed)
+		}
+	}
+}
+
+// StartPoolRefresh calls RefreshPools every interval until stop is closed.
+func (h *Handler) StartPoolRefresh(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.RefreshPools()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// PoolSnapshot returns the state of every loaded pool, ordered by provider.
+func (h *Handler) PoolSnapshot() []PoolStatus {
+	h.poolMu.Lock()
+	out := make([]PoolStatus, 0, len(h.pools))
+	pools := make([]*pool.AccountPool, 0, len(h.pools))
+	for id, p := range h.pools {
+		out = append(out, PoolStatus{ProviderID: id})
+		pools = append(pools, p)
+	}
+	h.poolMu.Unlock()
+
+	for i, p := range pools {
+		out[i].Accounts = p.Snapshot()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ProviderID < out[j].ProviderID })
+	return out
+}
+
+// ReenablePools puts disabled accounts back into rotation in every loaded pool.
+func (h *Handler) ReenablePools() {
+	h.poolMu.Lock()
+	pools := make(map[int64]*pool.AccountPool, len(h.pools))
+	for id, p := range h.pools {
+		pools[id] = p
+	}
+	h.poolMu.Unlock()
+
+	for id, p := range pools {
+		if p == nil {
+			continue
+		}
+		if n := p.Reenable(); n > 0 {
+			h.runnerLogger.Printf("POOL [re-enabled] provider=%d accounts=%d", id, n)
+		}
+	}
+}
+
+// HandleAdminPools serves GET /admin/pools (pool state) and
+// POST /admin/pools/refresh (reload from the database and re-enable accounts
+// taken out by auth failures, then pool state).
+func (h *Handler) HandleAdminPools(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/admin/pools":
+	case r.Method == http.MethodPost && r.URL.Path == "/admin/pools/refresh":
+		h.RefreshPools()
+		h.ReenablePools()
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"pools": h.PoolSnapshot()})
+}
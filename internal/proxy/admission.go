@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+)
+
+// maxWaitHeader lets a client shorten how long it is willing to queue for a
+// rate-limited model, in seconds. It cannot extend the server's QUEUE_TIMEOUT.
+const maxWaitHeader = "X-Apipod-Max-Wait"
+
+// admit reserves a rate-limit slot for routing's model. When the model is
+// saturated it first switches to another quota item with headroom, then queues
+// until capacity frees up or the wait deadline passes. It reports whether the
+// request may proceed; routing is updated if an alternate item was chosen. The
+// breaker slot of an item that is switched away from or rejected is released.
+func (h *Handler) admit(r *http.Request, cfg *config.RuntimeConfig, route RouteRequest, routing *RoutingResult) bool {
+	if routing.LLMModelID <= 0 {
+		return true
+	}
+	h.modelLimiter.SetLimits(routing.LLMModelID, routing.RPM, routing.TPM, routing.RPD)
+	tokens := route.Profile.EstimatedTokens
+	if reservedAt, ok := h.admission.TryAdmit(routing.LLMModelID, tokens); ok {
+		routing.ReservedTokens, routing.ReservedAt = tokens, reservedAt
+		return true
+	}
+
+	route.Exclude = map[int64]bool{routing.QuotaItemID: true}
+	if alt, err := h.nextRoute(route); err == nil {
+		h.runnerLogger.Printf("ADMIT [alternate] from=%s/%s to=%s/%s org=%d", routing.ProviderType, routing.Model, alt.ProviderType, alt.Model, cfg.OrgID)
+		h.router.Release(*routing)
+		*routing = alt
+		return true
+	}
+
+	maxWait := h.maxWait(r)
+	if maxWait <= 0 {
+		h.router.Release(*routing)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), maxWait)
+	defer cancel()
+
+	start := time.Now()
+	reservedAt, ok := h.admission.Wait(ctx, routing.LLMModelID, tokens, cfg.Priority)
+	if ok {
+		routing.ReservedTokens, routing.ReservedAt = tokens, reservedAt
+	} else {
+		h.router.Release(*routing)
+	}
+	h.runnerLogger.Printf("ADMIT [queued] model=%s org=%d priority=%s waited=%s admitted=%v", routing.Model, cfg.OrgID, cfg.Priority, time.Since(start).Round(time.Millisecond), ok)
+	return ok
+}
+
+// maxWait returns the queueing deadline for r: QUEUE_TIMEOUT, shortened by
+// the X-Apipod-Max-Wait header when present.
+func (h *Handler) maxWait(r *http.Request) time.Duration {
+	maxWait := h.queueTimeout
+	if v := r.Header.Get(maxWaitHeader); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			if d := time.Duration(secs * float64(time.Second)); d < maxWait {
+				maxWait = d
+			}
+		}
+	}
+	return maxWait
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/health"
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
+)
+
+func TestAdmitAlternateReleasesBreakerTrial(t *testing.T) {
+	cfg := health.DefaultConfig()
+	cfg.ConsecutiveFailures = 1
+	cfg.OpenDuration = 0 // half-open as soon as it trips
+	tracker := health.NewTracker(cfg)
+	tracker.Record(1, 10, false, time.Second)
+
+	rpm := 1
+	src := &fakeQuotaSource{items: []database.QuotaItem{
+		{QuotaID: 1, ProviderID: 1, LLMModelID: 10, ModelName: "gpt-4o", PercentageWeight: 50, RPM: &rpm},
+		{QuotaID: 2, ProviderID: 2, LLMModelID: 20, ModelName: "gpt-4o", PercentageWeight: 50},
+	}}
+	limiter := pool.NewModelLimiter(nil, nil)
+	h := NewHandler(NewRouter(src, tracker, 0, FallbackWeighted, ""), nil, nil, log.New(io.Discard, "", 0),
+		limiter, nil, nil, tracker, 1, pool.NewAdmissionQueue(limiter, ""), 0, nil, nil)
+
+	// Saturate model 10.
+	limiter.SetLimits(10, &rpm, nil, nil)
+	if !limiter.AllowRequest(10, 0) {
+		t.Fatal("first request for model 10 rejected")
+	}
+
+	route := RouteRequest{SubID: 7, Model: "gpt-4o"}
+	routing, err := h.router.Route(RouteRequest{SubID: 7, Model: "gpt-4o", Exclude: map[int64]bool{2: true}})
+	if err != nil || routing.QuotaItemID != 1 {
+		t.Fatalf("route = %d, %v; want quota item 1", routing.QuotaItemID, err)
+	}
+	if tracker.Acquire(1, 10) {
+		t.Fatal("half-open breaker admitted a second trial")
+	}
+
+	if !h.admit(httptest.NewRequest("POST", "/v1/chat/completions", nil), &config.RuntimeConfig{SubID: 7}, route, &routing) {
+		t.Fatal("request not admitted")
+	}
+	if routing.QuotaItemID != 2 {
+		t.Fatalf("admitted to quota item %d, want the alternate 2", routing.QuotaItemID)
+	}
+	if !tracker.Acquire(1, 10) {
+		t.Error("trial slot of the abandoned item was not released")
+	}
+}
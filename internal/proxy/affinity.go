@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionHeader lets clients name a conversation explicitly.
+const sessionHeader = "X-Session-Id"
+
+// affinityTable pins conversations to the quota item that served them, so
+// follow-up turns land on the same provider and can hit its prompt cache.
+// Pins slide forward on every use and expire after ttl of inactivity.
+type affinityTable struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	pins      map[string]affinityPin
+	lastSweep time.Time
+}
+
+type affinityPin struct {
+	quotaID   int64
+	expiresAt time.Time
+}
+
+func newAffinityTable(ttl time.Duration) *affinityTable {
+	return &affinityTable{
+		ttl:  ttl,
+		pins: make(map[string]affinityPin),
+	}
+}
+
+// lookup returns the pinned quota item for key, if any.
+func (t *affinityTable) lookup(key string) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pin, ok := t.pins[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(pin.expiresAt) {
+		delete(t.pins, key)
+		return 0, false
+	}
+	return pin.quotaID, true
+}
+
+// pin (re)binds key to quotaID and extends its expiry.
+func (t *affinityTable) pin(key string, quotaID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.pins[key] = affinityPin{quotaID: quotaID, expiresAt: now.Add(t.ttl)}
+
+	if now.Sub(t.lastSweep) > time.Minute {
+		t.lastSweep = now
+		for k, p := range t.pins {
+			if now.After(p.expiresAt) {
+				delete(t.pins, k)
+			}
+		}
+	}
+}
+
+// release drops the pin for key.
+func (t *affinityTable) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pins, key)
+}
+
+// conversationKey identifies the conversation a request belongs to. An explicit
+// X-Session-Id header wins, then Anthropic metadata.user_id, and otherwise a hash
+// of the system prompt plus the first user message, which stay constant across
+// the turns of a conversation. Returns "" when nothing usable is present.
+func conversationKey(r *http.Request, body []byte) string {
+	if id := r.Header.Get(sessionHeader); id != "" {
+		return "session:" + id
+	}
+
+	var req struct {
+		System   json.RawMessage `json:"system"`
+		Metadata struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if req.Metadata.UserID != "" {
+		return "user:" + req.Metadata.UserID
+	}
+
+	h := sha256.New()
+	h.Write(req.System)
+	firstUser := false
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			h.Write(m.Content) // OpenAI carries the system prompt as a message
+		case "user":
+			h.Write(m.Content)
+			firstUser = true
+		}
+		if firstUser {
+			break
+		}
+	}
+	if !firstUser {
+		return ""
+	}
+	return "conv:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// ErrNoCapableModel is returned when none of the plan's models matching the
+// request can serve it (context too large, or a feature it does not support).
+var ErrNoCapableModel = errors.New("no model on the plan can serve this request")
+
+// CapabilityError carries the reasons behind an ErrNoCapableModel.
+type CapabilityError struct {
+	Reason string
+}
+
+func (e *CapabilityError) Error() string { return ErrNoCapableModel.Error() + ": " + e.Reason }
+func (e *CapabilityError) Unwrap() error { return ErrNoCapableModel }
+
+// charsPerToken is the rough ratio used to estimate prompt size without a tokenizer.
+const charsPerToken = 4
+
+// RequestProfile summarises what a request needs from a model.
+type RequestProfile struct {
+	EstimatedTokens int // prompt size estimate (system, messages and tool definitions)
+	Tools           bool
+	Vision          bool
+	Thinking        bool
+	Stream          bool
+}
+
+// profileRequest estimates a request's prompt size and the features it uses.
+// It understands both Anthropic Messages and OpenAI Chat Completions bodies.
+func profileRequest(body []byte) RequestProfile {
+	var req struct {
+		System   json.RawMessage   `json:"system"`
+		Messages []json.RawMessage `json:"messages"`
+		Tools    []json.RawMessage `json:"tools"`
+		Stream   bool              `json:"stream"`
+		Thinking *struct {
+			Type string `json:"type"`
+		} `json:"thinking"`
+		ReasoningEffort string `json:"reasoning_effort"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return RequestProfile{}
+	}
+
+	chars := len(req.System)
+	for _, m := range req.Messages {
+		chars += len(m)
+	}
+	for _, t := range req.Tools {
+		chars += len(t)
+	}
+
+	return RequestProfile{
+		EstimatedTokens: chars / charsPerToken,
+		Tools:           len(req.Tools) > 0,
+		Vision:          hasImageContent(req.Messages),
+		Thinking:        (req.Thinking != nil && req.Thinking.Type != "disabled") || req.ReasoningEffort != "",
+		Stream:          req.Stream,
+	}
+}
+
+// hasImageContent reports whether any message carries an image block
+// (Anthropic "image", OpenAI "image_url").
+func hasImageContent(messages []json.RawMessage) bool {
+	for _, raw := range messages {
+		var m struct {
+			Content json.RawMessage `json:"content"`
+		}
+		if json.Unmarshal(raw, &m) != nil || len(m.Content) == 0 || m.Content[0] != '[' {
+			continue
+		}
+		var blocks []struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(m.Content, &blocks) != nil {
+			continue
+		}
+		for _, b := range blocks {
+			if b.Type == "image" || b.Type == "image_url" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withCatalogCapabilities fills the capabilities llm_models leaves NULL from
+// the model catalog.
+func withCatalogCapabilities(item database.QuotaItem) database.QuotaItem {
+	spec := config.LookupModel(item.ModelName)
+	if item.MaxContext == nil && spec.ContextWindow > 0 {
+		item.MaxContext = &spec.ContextWindow
+	}
+	if item.SupportsTools == nil {
+		item.SupportsTools = spec.SupportsTools
+	}
+	if item.SupportsVision == nil {
+		item.SupportsVision = spec.SupportsVision
+	}
+	if item.SupportsThinking == nil {
+		item.SupportsThinking = spec.SupportsThinking
+	}
+	if item.SupportsStreaming == nil {
+		item.SupportsStreaming = spec.SupportsStreaming
+	}
+	return item
+}
+
+// unsupported returns why item cannot serve p, or "" if it can. Unknown
+// capabilities (NULL in llm_models and not in the catalog) never exclude a model.
+func unsupported(item database.QuotaItem, p RequestProfile) string {
+	switch {
+	case item.MaxContext != nil && p.EstimatedTokens > *item.MaxContext:
+		return fmt.Sprintf("request needs ~%d tokens of context", p.EstimatedTokens)
+	case p.Tools && item.SupportsTools != nil && !*item.SupportsTools:
+		return "request uses tools"
+	case p.Vision && item.SupportsVision != nil && !*item.SupportsVision:
+		return "request contains images"
+	case p.Thinking && item.SupportsThinking != nil && !*item.SupportsThinking:
+		return "request enables extended thinking"
+	case p.Stream && item.SupportsStreaming != nil && !*item.SupportsStreaming:
+		return "request is streaming"
+	}
+	return ""
+}
+
+// filterCapable keeps the candidates able to serve p. When none qualify, the
+// error lists why they were rejected.
+func filterCapable(candidates []database.QuotaItem, p RequestProfile) ([]database.QuotaItem, error) {
+	var capable []database.QuotaItem
+	var reasons []string
+	for _, item := range candidates {
+		reason := unsupported(withCatalogCapabilities(item), p)
+		if reason == "" {
+			capable = append(capable, item)
+			continue
+		}
+		reasons = appendUnique(reasons, reason)
+	}
+	if len(capable) == 0 {
+		return nil, &CapabilityError{Reason: strings.Join(reasons, ", ")}
+	}
+	return capable, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+func TestProfileRequest(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4",
+		"stream": true,
+		"system": "` + strings.Repeat("x", 4000) + `",
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"tools": [{"name": "bash", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "what is this?"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+		]}]
+	}`
+	p := profileRequest([]byte(body))
+	if !p.Tools || !p.Vision || !p.Thinking || !p.Stream {
+		t.Fatalf("features not detected: %+v", p)
+	}
+	if p.EstimatedTokens < 1000 {
+		t.Fatalf("EstimatedTokens = %d, want >= 1000", p.EstimatedTokens)
+	}
+
+	plain := profileRequest([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	if plain.Tools || plain.Vision || plain.Thinking || plain.Stream {
+		t.Fatalf("unexpected features: %+v", plain)
+	}
+}
+
+func TestFilterCapable(t *testing.T) {
+	small, large := 8000, 200000
+	no := false
+	items := []database.QuotaItem{
+		{QuotaID: 1, MaxContext: &small},
+		{QuotaID: 2, MaxContext: &large, SupportsVision: &no},
+		{QuotaID: 3}, // nothing known: never filtered
+	}
+
+	got, err := filterCapable(items, RequestProfile{EstimatedTokens: 90000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := quotaIDs(got); len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("large request kept %v, want [2 3]", ids)
+	}
+
+	_, err = filterCapable(items[:2], RequestProfile{EstimatedTokens: 90000, Vision: true})
+	var capErr *CapabilityError
+	if !errors.Is(err, ErrNoCapableModel) || !errors.As(err, &capErr) {
+		t.Fatalf("err = %v, want ErrNoCapableModel", err)
+	}
+	if !strings.Contains(capErr.Reason, "context") || !strings.Contains(capErr.Reason, "images") {
+		t.Fatalf("reason %q should mention context and images", capErr.Reason)
+	}
+}
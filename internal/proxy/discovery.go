@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/ollama"
+)
+
+// DiscoverySource is where discovered models are published: the database's
+// llm_models table.
+type DiscoverySource interface {
+	GetProvidersByType(providerType string) ([]database.DiscoveryProvider, error)
+	SyncDiscoveredModels(providerID int64, names []string) (added, removed []string, err error)
+}
+
+// ModelDiscovery polls every local_ollama provider's /api/tags and keeps its
+// llm_models rows in line with the installed models, so they can be added to
+// plans like any other model. Changes reach the routing cache through the
+// llm_models NOTIFY trigger.
+type ModelDiscovery struct {
+	source DiscoverySource
+	logger *log.Logger
+}
+
+// NewModelDiscovery creates a discovery job publishing to source.
+func NewModelDiscovery(source DiscoverySource, logger *log.Logger) *ModelDiscovery {
+	return &ModelDiscovery{source: source, logger: logger}
+}
+
+// Discover polls every local_ollama provider once. A provider that cannot be
+// reached keeps its models as they are, so a rebooting box is not dropped from
+// routing (failover covers it meanwhile).
+func (d *ModelDiscovery) Discover() {
+	providers, err := d.source.GetProvidersByType("local_ollama")
+	if err != nil {
+		d.logger.Printf("DISCOVERY [failed] err=%v", err)
+		return
+	}
+	for _, p := range providers {
+		models, err := ollama.ListModels(p.BaseURL, p.APIKey)
+		if err != nil {
+			d.logger.Printf("DISCOVERY [unreachable] provider=%d url=%s err=%v", p.ID, p.BaseURL, err)
+			continue
+		}
+		names := make([]string, 0, len(models))
+		for _, m := range models {
+			names = append(names, m.Name)
+		}
+		added, removed, err := d.source.SyncDiscoveredModels(p.ID, names)
+		if err != nil {
+			d.logger.Printf("DISCOVERY [failed] provider=%d err=%v", p.ID, err)
+			continue
+		}
+		if len(added) > 0 || len(removed) > 0 {
+			d.logger.Printf("DISCOVERY [updated] provider=%d models=%d added=%v removed=%v", p.ID, len(names), added, removed)
+		}
+	}
+}
+
+// Start runs Discover now and then every interval until stop is closed.
+func (d *ModelDiscovery) Start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		d.Discover()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Discover()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
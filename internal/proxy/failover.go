@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// attemptsHeader reports how many upstream attempts were needed to serve the request.
+const attemptsHeader = "X-Apipod-Attempts"
+
+// upstreamFunc is the signature shared by handleNativeUpstream and handleNativeUpstreamAnthropic.
+type upstreamFunc func(w http.ResponseWriter, r *http.Request, routing RoutingResult, user *database.User, originalModel string, bodyBytes []byte, attempt int) (int, int, bool)
+
+// isRetryableStatus reports whether a failed attempt may be retried on another quota item.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// attemptWriter sits between an upstream handler and the client. When retry is
+// allowed and the handler answers with a retryable status before anything has
+// been sent, the response is held back instead of written, so the caller can
+// fail over to another quota item. Everything else passes straight through.
+type attemptWriter struct {
+	w        http.ResponseWriter
+	header   http.Header
+	attempt  int
+	retry    bool
+	ownFault bool // the proxy failed the attempt itself (see proxyFault)
+
+	status    int
+	headerAt  time.Time // when the handler produced a status, for time-to-first-byte
+	committed bool
+	held      bool
+	heldBody  bytes.Buffer
+}
+
+func newAttemptWriter(w http.ResponseWriter, attempt int, retry bool) *attemptWriter {
+	return &attemptWriter{
+		w:       w,
+		header:  make(http.Header),
+		attempt: attempt,
+		retry:   retry,
+	}
+}
+
+func (a *attemptWriter) Header() http.Header {
+	return a.header
+}
+
+func (a *attemptWriter) WriteHeader(code int) {
+	if a.committed || a.held {
+		return
+	}
+	a.status = code
+	a.headerAt = time.Now()
+	if a.retry && isRetryableStatus(code) {
+		a.held = true
+		return
+	}
+	a.commit(code)
+}
+
+func (a *attemptWriter) Write(b []byte) (int, error) {
+	if a.held {
+		return a.heldBody.Write(b)
+	}
+	if !a.committed {
+		a.WriteHeader(http.StatusOK)
+	}
+	return a.w.Write(b)
+}
+
+func (a *attemptWriter) Flush() {
+	if !a.committed {
+		return
+	}
+	if f, ok := a.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// proxyFault marks the attempt as failed by the proxy itself, such as a
+// misconfigured provider type: it is neither retried on another quota item nor
+// counted against the upstream's health.
+func (a *attemptWriter) proxyFault() {
+	a.retry = false
+	a.ownFault = true
+}
+
+func (a *attemptWriter) commit(code int) {
+	dst := a.w.Header()
+	for k, v := range a.header {
+		dst[k] = v
+	}
+	dst.Set(attemptsHeader, strconv.Itoa(a.attempt))
+	a.committed = true
+	a.w.WriteHeader(code)
+}
+
+// flushHeld sends a held-back failure to the client once no further attempt will be made.
+func (a *attemptWriter) flushHeld() {
+	if !a.held {
+		return
+	}
+	a.held = false
+	a.commit(a.status)
+	a.w.Write(a.heldBody.Bytes())
+}
+
+// proxyWithFailover sends the request to routing's upstream. While nothing has
+// reached the client, a 5xx/429/transport failure re-rolls among the plan's
+// remaining quota items (excluding every item already tried) and retries, with
+// send re-converting the body for the new provider type. It returns the routing
+// that produced the final response along with its token usage.
+func (h *Handler) proxyWithFailover(w http.ResponseWriter, r *http.Request, route RouteRequest, routing RoutingResult, user *database.User, bodyBytes []byte, usagePrefix string, send upstreamFunc) (RoutingResult, int, int, bool) {
+	requestedModel := route.Model
+	maxAttempts := h.maxAttempts
+	if routing.QuotaItemID == 0 || maxAttempts < 1 {
+		maxAttempts = 1 // BYOK routes have nothing to fail over to
+	}
+
+	tried := make(map[int64]bool)
+	route.Exclude = tried
+	for attempt := 1; ; attempt++ {
+		tried[routing.QuotaItemID] = true
+
+		aw := newAttemptWriter(w, attempt, attempt < maxAttempts)
+		start := time.Now()
+		in, out, cacheHit := send(aw, r, routing, user, requestedModel, bodyBytes, attempt)
+		h.recordHealth(routing, aw, start)
+
+		if aw.status >= 400 {
+			h.logFailedAttempt(routing, user, requestedModel, usagePrefix, aw.status, attempt)
+		}
+		if !aw.held {
+			return routing, in, out, cacheHit
+		}
+
+		next, err := h.nextRoute(route)
+		if err != nil {
+			h.runnerLogger.Printf("FAILOVER [exhausted] attempt=%d status=%d model=%s user=%s err=%v", attempt, aw.status, routing.Model, user.Username, err)
+			aw.flushHeld()
+			return routing, 0, 0, false
+		}
+
+		h.runnerLogger.Printf("FAILOVER attempt=%d status=%d from=%s/%s to=%s/%s user=%s",
+			attempt, aw.status, routing.ProviderType, routing.Model, next.ProviderType, next.Model, user.Username)
+		routing = next
+	}
+}
+
+// nextRoute re-rolls among the plan's quota items not in route.Exclude, skipping
+// (and excluding) items whose model is currently rate-limited. The conversation's
+// session pin moves to whichever item is picked. Skipped items hand back their
+// breaker slots.
+func (h *Handler) nextRoute(route RouteRequest) (RoutingResult, error) {
+	for {
+		routing, err := h.router.Route(route)
+		if err != nil {
+			return RoutingResult{}, err
+		}
+		if routing.LLMModelID > 0 {
+			h.modelLimiter.SetLimits(routing.LLMModelID, routing.RPM, routing.TPM, routing.RPD)
+			reservedAt, ok := h.admission.TryAdmit(routing.LLMModelID, route.Profile.EstimatedTokens)
+			if !ok {
+				h.router.Release(routing)
+				route.Exclude[routing.QuotaItemID] = true
+				continue
+			}
+			routing.ReservedTokens, routing.ReservedAt = route.Profile.EstimatedTokens, reservedAt
+		}
+		return routing, nil
+	}
+}
+
+// recordHealth feeds the attempt's outcome and time-to-first-byte into the
+// health tracker. BYOK routes use the customer's own key and are not tracked.
+func (h *Handler) recordHealth(routing RoutingResult, aw *attemptWriter, start time.Time) {
+	if h.health == nil || routing.QuotaItemID == 0 || aw.ownFault {
+		return
+	}
+	latency := time.Since(start)
+	if !aw.headerAt.IsZero() {
+		latency = aw.headerAt.Sub(start)
+	}
+	success := aw.status == 0 || !isRetryableStatus(aw.status)
+	h.health.Record(routing.ProviderID, routing.LLMModelID, success, latency)
+}
+
+// logFailedAttempt records a failed upstream attempt in usage_logs.
+func (h *Handler) logFailedAttempt(routing RoutingResult, user *database.User, requestedModel, usagePrefix string, status, attempt int) {
+	if routing.QuotaItemID <= 0 {
+		return
+	}
+	h.source.LogUsage(database.UsageContext{
+		QuotaItemID:      routing.QuotaItemID,
+		UserID:           user.ID,
+		RequestedModel:   requestedModel,
+		RoutedModel:      routing.Model,
+		UpstreamProvider: usagePrefix + ":" + routing.ProviderType,
+		StatusCode:       status,
+		Attempt:          attempt,
+	}, 0, 0)
+}
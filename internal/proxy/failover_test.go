@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAttemptWriterHoldsRetryableFailure(t *testing.T) {
+	rec := httptest.NewRecorder()
+	aw := newAttemptWriter(rec, 1, true)
+
+	aw.Header().Set("Content-Type", "application/json")
+	aw.WriteHeader(http.StatusServiceUnavailable)
+	aw.Write([]byte(`{"error": "overloaded"}`))
+
+	if !aw.held {
+		t.Fatal("expected 503 to be held back")
+	}
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Fatal("held response leaked to the client")
+	}
+
+	aw.flushHeld()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if rec.Body.String() != `{"error": "overloaded"}` {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	if rec.Header().Get(attemptsHeader) != "1" {
+		t.Errorf("%s = %q, want 1", attemptsHeader, rec.Header().Get(attemptsHeader))
+	}
+}
+
+func TestAttemptWriterPassesThrough(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		retry  bool
+	}{
+		{"success", http.StatusOK, true},
+		{"client error", http.StatusBadRequest, true},
+		{"last attempt", http.StatusBadGateway, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			aw := newAttemptWriter(rec, 2, tt.retry)
+			aw.WriteHeader(tt.status)
+			aw.Write([]byte("body"))
+
+			if aw.held {
+				t.Fatal("response should not be held")
+			}
+			if rec.Code != tt.status || rec.Body.String() != "body" {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.status, "body")
+			}
+			if rec.Header().Get(attemptsHeader) != "2" {
+				t.Errorf("%s = %q, want 2", attemptsHeader, rec.Header().Get(attemptsHeader))
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,7 +12,9 @@ import (
 	"time"
 
 	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/credentials"
 	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/health"
 	"github.com/rpay/apipod-smart-proxy/internal/metrics"
 	"github.com/rpay/apipod-smart-proxy/internal/middleware"
 	"github.com/rpay/apipod-smart-proxy/internal/orchestrator"
@@ -20,7 +23,7 @@ import (
 )
 
 type Handler struct {
-	db             *database.DB
+	source         RoutingSource
 	logger         *log.Logger
 	runnerLogger   *log.Logger
 	router         *Router
@@ -32,6 +35,17 @@ type Handler struct {
 	rateLimiter    *RateLimiter
 	usageCommitter *UsageCommitter
 	metrics        *metrics.Metrics
+	health         *health.Tracker
+	maxAttempts    int // upstream attempts per request, including failovers
+	admission      *pool.AdmissionQueue
+	queueTimeout   time.Duration // longest a request waits for a rate-limited model
+	credentials    *credentials.Manager
+
+	providers        map[string]Provider // by providers.provider_type
+	unknownProviders sync.Map            // provider types already logged as unsupported
+
+	// Where classify/plan calls go when set; otherwise the routed provider.
+	orchestratorUpstream orchestrator.PhaseRequest
 }
 
 // statusRecorder wraps http.ResponseWriter to capture the response status code.
@@ -52,9 +66,15 @@ func (r *statusRecorder) Write(b []byte) (int, error) {
 	return r.ResponseWriter.Write(b)
 }
 
-func NewHandler(router *Router, db *database.DB, logger *log.Logger, runnerLogger *log.Logger, modelLimiter *pool.ModelLimiter, usageCommitter *UsageCommitter, m *metrics.Metrics) *Handler {
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func NewHandler(router *Router, source RoutingSource, logger *log.Logger, runnerLogger *log.Logger, modelLimiter *pool.ModelLimiter, usageCommitter *UsageCommitter, m *metrics.Metrics, tracker *health.Tracker, maxAttempts int, admission *pool.AdmissionQueue, queueTimeout time.Duration, rateLimiter *RateLimiter, creds *credentials.Manager) *Handler {
 	return &Handler{
-		db:             db,
+		source:         source,
 		logger:         logger,
 		runnerLogger:   runnerLogger,
 		router:         router,
@@ -62,13 +82,28 @@ func NewHandler(router *Router, db *database.DB, logger *log.Logger, runnerLogge
 		modelLimiter:   modelLimiter,
 		orchestrator:   orchestrator.New(runnerLogger),
 		toolExecutor:   tools.NewExecutor(runnerLogger),
-		rateLimiter:    NewRateLimiter(),
+		rateLimiter:    rateLimiter,
 		usageCommitter: usageCommitter,
 		metrics:        m,
+		health:         tracker,
+		maxAttempts:    maxAttempts,
+		admission:      admission,
+		queueTimeout:   queueTimeout,
+		credentials:    creds,
+		providers:      defaultProviders(),
 	}
 }
 
+// SetOrchestratorUpstream sends the orchestrator's classify and plan calls to
+// a fixed Anthropic Messages endpoint, such as an on-prem Ollama box, instead
+// of the provider the request was routed to. It must be called before the
+// handler serves requests.
+func (h *Handler) SetOrchestratorUpstream(baseURL, apiKey, model string) {
+	h.orchestratorUpstream = orchestrator.PhaseRequest{BaseURL: baseURL, APIKey: apiKey, Model: model}
+}
+
 // getPool returns the account pool for a provider, loading from DB if not cached.
+// Cached pools are kept current by RefreshPools.
 func (h *Handler) getPool(providerID int64) *pool.AccountPool {
 	h.poolMu.Lock()
 	defer h.poolMu.Unlock()
@@ -77,7 +112,7 @@ func (h *Handler) getPool(providerID int64) *pool.AccountPool {
 		return p
 	}
 
-	accounts, err := h.db.GetAccountsForProvider(uint(providerID))
+	accounts, err := h.loadAccounts(providerID)
 	if err != nil {
 		h.logger.Printf("Failed to load accounts for provider %d: %v", providerID, err)
 		return nil
@@ -88,13 +123,7 @@ func (h *Handler) getPool(providerID int64) *pool.AccountPool {
 	}
 
 	p := pool.NewAccountPool()
-	for _, acc := range accounts {
-		p.Accounts = append(p.Accounts, &pool.Account{
-			ID:     acc.ID,
-			Email:  acc.Email,
-			APIKey: acc.APIKey,
-		})
-	}
+	p.Sync(accounts)
 	h.pools[providerID] = p
 	h.logger.Printf("Loaded %d accounts into pool for provider %d", len(accounts), providerID)
 	return p
@@ -107,7 +136,8 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 
 // enforceRuntimeConfig checks rate limits, daily quota, and model access.
 // Returns the RuntimeConfig if allowed, or writes error response and returns nil.
-func (h *Handler) enforceRuntimeConfig(w http.ResponseWriter, r *http.Request, model string) *config.RuntimeConfig {
+// dialect selects the rate-limit header family sent with a 429.
+func (h *Handler) enforceRuntimeConfig(w http.ResponseWriter, r *http.Request, model, dialect string) *config.RuntimeConfig {
 	cfg := middleware.GetConfigFromContext(r.Context())
 	if cfg == nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
@@ -117,14 +147,14 @@ func (h *Handler) enforceRuntimeConfig(w http.ResponseWriter, r *http.Request, m
 	// Rate limit check
 	if !h.rateLimiter.AllowRequest(cfg.OrgID, cfg.RateLimitRPM) {
 		h.runnerLogger.Printf("RATE_LIMITED [rpm] org=%d rpm=%d", cfg.OrgID, cfg.RateLimitRPM)
-		http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
+		rejectRateLimited(w, dialect, h.rateLimiter.Status(cfg.OrgID, cfg.RateLimitRPM, cfg.DailyUsed, cfg.DailyQuota), nil, `{"error": "Rate limit exceeded"}`)
 		return nil
 	}
 
 	// Daily quota check
 	if !h.rateLimiter.CheckDailyQuota(cfg.OrgID, cfg.DailyUsed, cfg.DailyQuota) {
 		h.runnerLogger.Printf("RATE_LIMITED [daily] org=%d used=%d cap=%d", cfg.OrgID, cfg.DailyUsed, cfg.DailyQuota)
-		http.Error(w, `{"error": "Daily request limit reached"}`, http.StatusTooManyRequests)
+		rejectRateLimited(w, dialect, h.rateLimiter.Status(cfg.OrgID, cfg.RateLimitRPM, cfg.DailyUsed, cfg.DailyQuota), nil, `{"error": "Daily request limit reached"}`)
 		return nil
 	}
 
@@ -193,9 +223,10 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
 	cacheHit := false
+	var routing RoutingResult
 	defer func() {
 		if h.metrics != nil {
-			h.metrics.Record(time.Since(start).Milliseconds(), rec.status < 400, cacheHit)
+			h.metrics.Record(time.Since(start).Milliseconds(), rec.status < 400, cacheHit, routing.Pinned)
 		}
 	}()
 	w = rec
@@ -216,13 +247,18 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Enforce rate limits + model access
-	cfg := h.enforceRuntimeConfig(w, r, req.Model)
+	cfg := h.enforceRuntimeConfig(w, r, req.Model, dialectAnthropic)
 	if cfg == nil {
 		return
 	}
 
 	// Route: BYOK uses active model directly, platform uses weighted router
-	var routing RoutingResult
+	route := RouteRequest{
+		SubID:      cfg.SubID,
+		Model:      req.Model,
+		SessionKey: conversationKey(r, bodyBytes),
+		Profile:    profileRequest(bodyBytes),
+	}
 	if cfg.Mode == "byok" {
 		var ok bool
 		routing, ok = h.routeBYOK(w, cfg)
@@ -231,7 +267,18 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		var err error
-		routing, err = h.router.RouteModel(cfg.SubID, req.Model)
+		routing, err = h.router.Route(route)
+		if errors.Is(err, ErrModelNotAvailable) {
+			h.runnerLogger.Printf("DENIED [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
+			http.Error(w, `{"error": {"type": "not_found_error", "message": "Model not available on your plan"}}`, http.StatusNotFound)
+			return
+		}
+		var capErr *CapabilityError
+		if errors.As(err, &capErr) {
+			h.runnerLogger.Printf("DENIED [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
+			http.Error(w, fmt.Sprintf(`{"error": {"type": "invalid_request_error", "message": "No model on your plan can serve this request (%s)"}}`, capErr.Reason), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			h.runnerLogger.Printf("ERROR [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
 			http.Error(w, `{"error": {"type": "not_found_error", "message": "Routing failed"}}`, http.StatusInternalServerError)
@@ -239,22 +286,22 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Model rate limiting
-	if routing.LLMModelID > 0 {
-		h.modelLimiter.SetLimits(routing.LLMModelID, routing.RPM, routing.TPM, routing.RPD)
-		if !h.modelLimiter.AllowRequest(routing.LLMModelID) {
-			http.Error(w, `{"error": {"type": "rate_limit_error", "message": "Model rate limit exceeded"}}`, http.StatusTooManyRequests)
-			return
-		}
+	// Model rate limiting: switch to a quota item with headroom or queue for capacity
+	if !h.admit(r, cfg, route, &routing) {
+		h.router.Unpin(cfg.SubID, route.SessionKey)
+		requests, tokens := h.limitStatus(cfg, routing, route.Profile.EstimatedTokens)
+		rejectRateLimited(w, dialectAnthropic, requests, tokens, `{"error": {"type": "rate_limit_error", "message": "Model rate limit exceeded"}}`)
+		return
 	}
+	requests, tokens := h.limitStatus(cfg, routing, route.Profile.EstimatedTokens)
+	setRateLimitHeaders(w, dialectAnthropic, requests, tokens)
 
-	// Build legacy User object for native_handler compatibility
-	user := &database.User{
-		ID:       fmt.Sprintf("%d", cfg.OrgID),
-		Username: fmt.Sprintf("org_%d", cfg.OrgID),
-	}
+	user := requestUser(cfg)
+
+	var inTokens, outTokens int
+	routing, inTokens, outTokens, cacheHit = h.proxyWithFailover(w, r, route, routing, user, bodyBytes, "anthropic", h.handleNativeUpstreamAnthropic)
 
-	inTokens, outTokens, cacheHit := h.handleNativeUpstreamAnthropic(w, r, routing, user, req.Model, bodyBytes)
+	h.logCost(cfg, routing.Model, inTokens, outTokens)
 
 	// Async usage commit (non-blocking)
 	if h.usageCommitter != nil {
@@ -266,9 +313,10 @@ func (h *Handler) HandleChatCompletion(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
 	cacheHit := false
+	var routing RoutingResult
 	defer func() {
 		if h.metrics != nil {
-			h.metrics.Record(time.Since(start).Milliseconds(), rec.status < 400, cacheHit)
+			h.metrics.Record(time.Since(start).Milliseconds(), rec.status < 400, cacheHit, routing.Pinned)
 		}
 	}()
 	w = rec
@@ -289,13 +337,18 @@ func (h *Handler) HandleChatCompletion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Enforce rate limits + model access
-	cfg := h.enforceRuntimeConfig(w, r, req.Model)
+	cfg := h.enforceRuntimeConfig(w, r, req.Model, dialectOpenAI)
 	if cfg == nil {
 		return
 	}
 
 	// Route: BYOK uses active model directly, platform uses weighted router
-	var routing RoutingResult
+	route := RouteRequest{
+		SubID:      cfg.SubID,
+		Model:      req.Model,
+		SessionKey: conversationKey(r, bodyBytes),
+		Profile:    profileRequest(bodyBytes),
+	}
 	if cfg.Mode == "byok" {
 		var ok bool
 		routing, ok = h.routeBYOK(w, cfg)
@@ -304,7 +357,18 @@ func (h *Handler) HandleChatCompletion(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		var err error
-		routing, err = h.router.RouteModel(cfg.SubID, req.Model)
+		routing, err = h.router.Route(route)
+		if errors.Is(err, ErrModelNotAvailable) {
+			h.runnerLogger.Printf("DENIED [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
+			http.Error(w, `{"error": "Model not available on your plan"}`, http.StatusNotFound)
+			return
+		}
+		var capErr *CapabilityError
+		if errors.As(err, &capErr) {
+			h.runnerLogger.Printf("DENIED [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
+			http.Error(w, fmt.Sprintf(`{"error": "No model on your plan can serve this request (%s)"}`, capErr.Reason), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			h.runnerLogger.Printf("ERROR [routing] model=%s org=%d err=%v", req.Model, cfg.OrgID, err)
 			http.Error(w, `{"error": "Routing failed"}`, http.StatusInternalServerError)
@@ -312,25 +376,45 @@ func (h *Handler) HandleChatCompletion(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Model rate limiting
-	if routing.LLMModelID > 0 {
-		h.modelLimiter.SetLimits(routing.LLMModelID, routing.RPM, routing.TPM, routing.RPD)
-		if !h.modelLimiter.AllowRequest(routing.LLMModelID) {
-			http.Error(w, `{"error": "Model rate limit exceeded"}`, http.StatusTooManyRequests)
-			return
-		}
+	// Model rate limiting: switch to a quota item with headroom or queue for capacity
+	if !h.admit(r, cfg, route, &routing) {
+		h.router.Unpin(cfg.SubID, route.SessionKey)
+		requests, tokens := h.limitStatus(cfg, routing, route.Profile.EstimatedTokens)
+		rejectRateLimited(w, dialectOpenAI, requests, tokens, `{"error": "Model rate limit exceeded"}`)
+		return
 	}
+	requests, tokens := h.limitStatus(cfg, routing, route.Profile.EstimatedTokens)
+	setRateLimitHeaders(w, dialectOpenAI, requests, tokens)
 
-	// Build legacy User object for native_handler compatibility
-	user := &database.User{
-		ID:       fmt.Sprintf("%d", cfg.OrgID),
-		Username: fmt.Sprintf("org_%d", cfg.OrgID),
-	}
+	user := requestUser(cfg)
+
+	var inTokens, outTokens int
+	routing, inTokens, outTokens, cacheHit = h.proxyWithFailover(w, r, route, routing, user, bodyBytes, "native", h.handleNativeUpstream)
 
-	inTokens, outTokens, cacheHit := h.handleNativeUpstream(w, r, routing, user, req.Model, bodyBytes)
+	h.logCost(cfg, routing.Model, inTokens, outTokens)
 
 	// Async usage commit (non-blocking)
 	if h.usageCommitter != nil {
 		h.usageCommitter.CommitAsync(cfg.OrgID, cfg.APIKeyID, routing.Model, cfg.Mode, inTokens, outTokens, rec.status, time.Since(start).Milliseconds(), cacheHit)
 	}
 }
+
+// requestUser builds the legacy User object for native_handler compatibility.
+// Keys with a label (static config) are logged under it.
+func requestUser(cfg *config.RuntimeConfig) *database.User {
+	username := cfg.Label
+	if username == "" {
+		username = fmt.Sprintf("org_%d", cfg.OrgID)
+	}
+	return &database.User{
+		ID:       fmt.Sprintf("%d", cfg.OrgID),
+		Username: username,
+	}
+}
+
+// logCost records the request's price when the model catalog prices the model.
+func (h *Handler) logCost(cfg *config.RuntimeConfig, model string, inTokens, outTokens int) {
+	if cost := config.LookupModel(model).Cost(inTokens, outTokens); cost > 0 {
+		h.runnerLogger.Printf("COST model=%s tokens=%d/%d usd=%.6f org=%d", model, inTokens, outTokens, cost, cfg.OrgID)
+	}
+}
@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+)
+
+// Provider adapts one kind of upstream (a providers.provider_type) to the two
+// client dialects. Handler.forward does everything else: API keys and the
+// account pool, error responses, logging, usage logs and token accounting.
+type Provider interface {
+	// BuildRequest converts c.ClientBody, which is in c.Dialect, into the
+	// upstream request in c.Body and decides c.UpstreamStream. An error is
+	// answered with 400.
+	BuildRequest(c *ProviderCall) error
+
+	// Send issues c.Body with c.APIKey. It returns the URL for logging, also
+	// on error.
+	Send(c *ProviderCall) (*http.Response, string, error)
+
+	// TransformStream copies a successful streaming response to w in c.Dialect.
+	TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage
+
+	// TransformResponse converts a successful buffered response to c.Dialect.
+	// On error the upstream body is passed through unchanged.
+	TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error)
+
+	// ExtractUsage reads the token usage of a buffered upstream response.
+	ExtractUsage(body []byte) Usage
+}
+
+// errorTransformer is implemented by providers that rewrite some upstream
+// error bodies for the client dialect. When ok, forward answers with an error
+// of errType and message instead of the upstream body.
+type errorTransformer interface {
+	TransformError(c *ProviderCall, body []byte) (errType, message string, ok bool)
+}
+
+// Usage is what one upstream call consumed.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	ToolCall     bool
+	CacheHit     bool    // served (partly) from the provider's prompt cache
+	Cost         float64 // USD, when the provider reports it (OpenRouter)
+
+	// Anthropic prompt-cache tokens, not included in InputTokens.
+	CacheCreationTokens int
+	CacheReadTokens     int
+}
+
+// ProviderCall is one attempt against a provider. Fields above the blank line
+// are set by forward; BuildRequest fills in the rest.
+type ProviderCall struct {
+	Routing    RoutingResult
+	Dialect    string // dialectOpenAI or dialectAnthropic: the client's format
+	Username   string
+	ClientBody []byte
+	Header     http.Header // the client's request headers
+	Stream     bool        // the client asked for a stream
+	APIKey     string
+
+	Body           []byte
+	UpstreamStream bool // the upstream answers with a stream (else forward writes c.Stream as SSE)
+	PassHeaders    bool // forward the upstream response headers verbatim
+	Relay          bool // copy the upstream status and body as-is, without headers or usage accounting
+
+	h        *Handler
+	toolExec bool // run proxy-injected tools on the buffered response
+}
+
+// defaultProviders returns the adapters for each providers.provider_type with
+// their default settings; the Handler.Configure* methods replace some of them.
+func defaultProviders() map[string]Provider {
+	return map[string]Provider{
+		"antigravity_proxy": antigravityProvider{},
+		"anthropic":         anthropicProvider{},
+		"cliproxy":          copilotProvider{},
+		"openai":            openAICompatProvider{path: "/v1/chat/completions"},
+		"groq":              openAICompatProvider{path: "/openai/v1/chat/completions"},
+		"deepseek":          openAICompatProvider{path: "/chat/completions"},
+		"openrouter":        newOpenRouterProvider("", "", nil),
+		"nvidia_nim":        openAICompatProvider{path: "/v1/chat/completions", model: nimModelName},
+		"llamacpp":          openAICompatProvider{path: "/v1/chat/completions", prepare: includeStreamUsage},
+		"azure_openai":      newAzureOpenAIProvider("", nil),
+		"local_ollama":      ollamaProvider{},
+		"google_ai_studio":  googleAIStudioProvider{},
+	}
+}
+
+func (h *Handler) handleNativeUpstream(w http.ResponseWriter, r *http.Request, routing RoutingResult, user *database.User, originalModel string, bodyBytes []byte, attempt int) (int, int, bool) {
+	return h.forward(w, r.Header, dialectOpenAI, routing, user, originalModel, bodyBytes, attempt)
+}
+
+func (h *Handler) handleNativeUpstreamAnthropic(w http.ResponseWriter, r *http.Request, routing RoutingResult, user *database.User, originalModel string, bodyBytes []byte, attempt int) (int, int, bool) {
+	return h.forward(w, r.Header, dialectAnthropic, routing, user, originalModel, bodyBytes, attempt)
+}
+
+// forward runs one attempt of a request in dialect against routing's provider
+// and returns the tokens it used and whether the prompt cache was hit.
+func (h *Handler) forward(w http.ResponseWriter, header http.Header, dialect string, routing RoutingResult, user *database.User, originalModel string, bodyBytes []byte, attempt int) (int, int, bool) {
+	startTime := time.Now()
+	label, usagePrefix := routing.ProviderType, "native"
+	if dialect == dialectAnthropic {
+		label, usagePrefix = routing.ProviderType+"/anthropic", "anthropic"
+	}
+	usageCtx := database.UsageContext{
+		QuotaItemID:      routing.QuotaItemID,
+		UserID:           user.ID,
+		RequestedModel:   originalModel,
+		RoutedModel:      routing.Model,
+		UpstreamProvider: usagePrefix + ":" + routing.ProviderType,
+		Attempt:          attempt,
+	}
+
+	var usage Usage
+	defer func() {
+		if routing.LLMModelID > 0 {
+			h.modelLimiter.RecordTokens(routing.LLMModelID, routing.ReservedAt, routing.ReservedTokens, usage.InputTokens+usage.OutputTokens)
+		}
+	}()
+
+	p, ok := h.providers[routing.ProviderType]
+	if !ok {
+		// A configuration error: another quota item will not fix it, and the
+		// upstream is not at fault.
+		if _, logged := h.unknownProviders.LoadOrStore(routing.ProviderType, true); !logged {
+			h.runnerLogger.Printf("ERROR [config] unsupported provider_type=%q provider=%d model=%s: requests routed to it fail", routing.ProviderType, routing.ProviderID, routing.Model)
+		}
+		if aw, ok := w.(*attemptWriter); ok {
+			aw.proxyFault()
+		}
+		writeProxyError(w, dialect, http.StatusInternalServerError, "api_error", "Unsupported provider type")
+		return 0, 0, false
+	}
+
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(bodyBytes, &req)
+	c := &ProviderCall{
+		Routing:    routing,
+		Dialect:    dialect,
+		Username:   user.Username,
+		ClientBody: bodyBytes,
+		Header:     header,
+		Stream:     req.Stream,
+		h:          h,
+	}
+	if err := p.BuildRequest(c); err != nil {
+		h.logger.Printf("[%s] conversion error: %v (body length=%d)", label, err, len(bodyBytes))
+		writeProxyError(w, dialect, http.StatusBadRequest, "invalid_request_error", "Invalid request body")
+		return 0, 0, false
+	}
+
+	apiKey, report := h.acquireAPIKey(routing)
+	c.APIKey = apiKey
+	resp, upstreamURL, err := p.Send(c)
+	if err != nil {
+		report(0, nil)
+		h.runnerLogger.Printf("ERROR [%s] model=%s url=%s key=%s user=%s latency=%s err=%v", label, routing.Model, upstreamURL, keyHint(apiKey), c.Username, time.Since(startTime).Round(time.Millisecond), err)
+		writeProxyError(w, dialect, http.StatusBadGateway, "api_error", "Upstream request failed")
+		return 0, 0, false
+	}
+	defer report(resp.StatusCode, resp.Header) // after the body is streamed, so in-flight counts stay accurate
+	defer resp.Body.Close()
+
+	usageCtx.StatusCode = resp.StatusCode
+
+	// Error responses are always buffered so they can be logged.
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		h.runnerLogger.Printf("ERROR [%s] status=%d model=%s url=%s key=%s user=%s latency=%s body=%s", label, resp.StatusCode, routing.Model, upstreamURL, keyHint(apiKey), c.Username, time.Since(startTime).Round(time.Millisecond), string(respBody))
+		if et, ok := p.(errorTransformer); ok {
+			if errType, message, ok := et.TransformError(c, respBody); ok {
+				writeProxyError(w, dialect, resp.StatusCode, errType, message)
+				return 0, 0, false
+			}
+		}
+		c.writeHeader(w, resp, "application/json")
+		w.Write(respBody)
+		return 0, 0, false
+	}
+
+	if c.Relay {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		h.runnerLogger.Printf("OK [%s] model=%s stream=%v latency=%s user=%s req_size=%d",
+			label, routing.Model, c.Stream, time.Since(startTime).Round(time.Millisecond), c.Username, len(bodyBytes))
+		return 0, 0, false
+	}
+
+	if c.UpstreamStream {
+		c.writeHeader(w, resp, "text/event-stream")
+		usage = p.TransformStream(c, resp.Body, w)
+	} else {
+		respBody, _ := io.ReadAll(resp.Body)
+		out, u, err := p.TransformResponse(c, respBody)
+		if err != nil {
+			h.runnerLogger.Printf("ERROR [%s] model=%s user=%s err=%v (passing the upstream response through)", label, routing.Model, c.Username, err)
+			out, u = respBody, p.ExtractUsage(respBody)
+			c.writeHeader(w, resp, "application/json")
+			w.Write(out)
+		} else if c.Stream && dialect == dialectAnthropic {
+			// The upstream was asked not to stream (tool execution, double
+			// conversion): replay the result as Anthropic SSE events.
+			w.Header().Set("Cache-Control", "no-cache")
+			c.writeHeader(w, resp, "text/event-stream")
+			anthropiccompat.WriteAnthropicResponseAsSSE(out, w, routing.Model)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		} else {
+			c.writeHeader(w, resp, "application/json")
+			w.Write(out)
+		}
+		usage = u
+	}
+
+	h.runnerLogger.Printf("OK [%s] model=%s stream=%v tool_call=%v tokens=%d/%d latency=%s user=%s req_size=%d",
+		label, routing.Model, c.Stream, usage.ToolCall,
+		usage.InputTokens, usage.OutputTokens, time.Since(startTime).Round(time.Millisecond), c.Username, len(bodyBytes))
+
+	if usageCtx.QuotaItemID > 0 {
+		usageCtx.CostUSD = usage.Cost
+		usageCtx.CacheCreationTokens, usageCtx.CacheReadTokens = usage.CacheCreationTokens, usage.CacheReadTokens
+		h.source.LogUsage(usageCtx, usage.InputTokens, usage.OutputTokens)
+	}
+	return usage.InputTokens, usage.OutputTokens, usage.CacheHit
+}
+
+// writeHeader sends the response status with either the upstream's headers
+// (PassHeaders), none (Relay) or just contentType. The upstream's rate-limit headers describe
+// the provider account, not the client's plan, so they never replace the
+// proxy's own (see setRateLimitHeaders).
+func (c *ProviderCall) writeHeader(w http.ResponseWriter, resp *http.Response, contentType string) {
+	if c.PassHeaders {
+		for k, v := range resp.Header {
+			if isRateLimitHeader(k) {
+				continue
+			}
+			for _, vv := range v {
+				w.Header().Add(k, vv)
+			}
+		}
+	} else if !c.Relay {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+}
+
+// writeProxyError answers with an error generated by the proxy itself, in the
+// client's dialect. errType is only used for Anthropic clients.
+func writeProxyError(w http.ResponseWriter, dialect string, status int, errType, message string) {
+	if dialect == dialectAnthropic {
+		http.Error(w, fmt.Sprintf(`{"error": {"type": %q, "message": %q}}`, errType, message), status)
+		return
+	}
+	http.Error(w, fmt.Sprintf(`{"error": %q}`, message), status)
+}
+
+// keyHint masks an API key for logs.
+func keyHint(key string) string {
+	if len(key) <= 8 {
+		return ""
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+// anthropicUsage reads the usage of an Anthropic Messages response.
+func anthropicUsage(body []byte) Usage {
+	return anthropicUsageOf(anthropiccompat.ExtractUsage(body), detectAnthropicToolCall(body))
+}
+
+// anthropicUsageOf converts Messages usage, keeping prompt-cache writes and
+// reads apart from the uncached input tokens.
+func anthropicUsageOf(u anthropiccompat.Usage, toolCall bool) Usage {
+	return Usage{
+		InputTokens:         u.InputTokens,
+		OutputTokens:        u.OutputTokens,
+		ToolCall:            toolCall,
+		CacheHit:            u.CacheReadInputTokens > 0,
+		CacheCreationTokens: u.CacheCreationInputTokens,
+		CacheReadTokens:     u.CacheReadInputTokens,
+	}
+}
+
+// setModel replaces the model of a JSON request body. The other fields are
+// kept byte for byte.
+func setModel(body []byte, model string) ([]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	m["model"], _ = json.Marshal(model)
+	return json.Marshal(m)
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/antigravity"
+)
+
+// anthropicProvider sends Messages requests to the Anthropic API. Anthropic
+// requests are forwarded as sent apart from the model, so cache_control
+// breakpoints and thinking reach the API unchanged; OpenAI requests are
+// converted. The client's anthropic-version and anthropic-beta headers are
+// passed on, the beta flags merged with the configured ones.
+type anthropicProvider struct {
+	version string   // anthropic-version when the client sends none
+	beta    []string // anthropic-beta flags sent with every request
+}
+
+func (anthropicProvider) BuildRequest(c *ProviderCall) error {
+	c.UpstreamStream = c.Stream
+	var err error
+	if c.Dialect == dialectOpenAI {
+		c.Body, err = antigravity.OpenAIToAnthropic(c.ClientBody, c.Routing.Model, c.Stream)
+		return err
+	}
+	c.Body, err = setModel(c.ClientBody, c.Routing.Model)
+	return err
+}
+
+func (p anthropicProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	timeouts := config.GetModelTimeouts(c.Routing.Model)
+	resp, err := anthropiccompat.ProxyDirectWithHeaders(c.Routing.BaseURL, c.APIKey, c.Body, p.headers(c.Header), timeouts.RequestTimeout)
+	return resp, strings.TrimRight(c.Routing.BaseURL, "/") + "/v1/messages", err
+}
+
+// headers returns the anthropic-version and anthropic-beta headers for a
+// request whose client sent client.
+func (p anthropicProvider) headers(client http.Header) http.Header {
+	header := http.Header{}
+	version := client.Get("anthropic-version")
+	if version == "" {
+		version = p.version
+	}
+	if version != "" {
+		header.Set("anthropic-version", version)
+	}
+
+	var beta []string
+	seen := make(map[string]bool)
+	values := append([]string(nil), client.Values("anthropic-beta")...)
+	for _, value := range append(values, p.beta...) {
+		for _, flag := range strings.Split(value, ",") {
+			if flag = strings.TrimSpace(flag); flag != "" && !seen[flag] {
+				seen[flag] = true
+				beta = append(beta, flag)
+			}
+		}
+	}
+	if len(beta) > 0 {
+		header.Set("anthropic-beta", strings.Join(beta, ","))
+	}
+	return header
+}
+
+func (anthropicProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	usage := anthropiccompat.NewUsageReader(body)
+	toolCall := false
+	if c.Dialect == dialectOpenAI {
+		_, _, toolCall, _ = antigravity.StreamTransformToOpenAI(usage, w, c.Routing.Model)
+	} else {
+		antigravity.StreamTransform(usage, w)
+	}
+	return anthropicUsageOf(usage.Usage(), toolCall)
+}
+
+func (p anthropicProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	usage := p.ExtractUsage(body)
+	if c.Dialect == dialectAnthropic {
+		return body, usage, nil
+	}
+	out, _, _, _, _, err := antigravity.TransformResponseToOpenAI(body, c.Routing.Model)
+	return out, usage, err
+}
+
+func (anthropicProvider) ExtractUsage(body []byte) Usage {
+	return anthropicUsage(body)
+}
+
+// ConfigureAnthropic sets the anthropic-version sent when the client gives
+// none and comma-separated anthropic-beta flags added to every request for
+// the anthropic provider type. Empty values keep the defaults. It must be
+// called before the handler serves requests.
+func (h *Handler) ConfigureAnthropic(version, beta string) {
+	p := anthropicProvider{version: version}
+	for _, flag := range strings.Split(beta, ",") {
+		if flag = strings.TrimSpace(flag); flag != "" {
+			p.beta = append(p.beta, flag)
+		}
+	}
+	h.providers["anthropic"] = p
+}
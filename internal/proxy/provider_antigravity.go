@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/antigravity"
+)
+
+// antigravityProvider talks Anthropic Messages to an Antigravity proxy.
+// OpenAI requests are converted by the antigravity package; Anthropic requests
+// are forwarded with the proxy's system prompt, and proxy-injected tools are
+// executed on non-streaming responses.
+type antigravityProvider struct{}
+
+func (antigravityProvider) BuildRequest(c *ProviderCall) error {
+	c.UpstreamStream = c.Stream
+	if c.Dialect == dialectOpenAI {
+		c.Body = c.ClientBody
+		return nil
+	}
+	body := c.h.orchestrateOrFallback(c.ClientBody, c.Routing, c.Username)
+	c.Body = anthropiccompat.SanitizeEmptyToolNames(body)
+	c.toolExec = !c.Stream
+	return nil
+}
+
+func (antigravityProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	if c.Dialect == dialectOpenAI {
+		resp, err := antigravity.ProxyToAntigravity(c.Routing.BaseURL, c.APIKey, c.Routing.Model, c.Body, c.UpstreamStream)
+		return resp, c.Routing.BaseURL, err
+	}
+	timeouts := config.GetModelTimeouts(c.Routing.Model)
+	resp, err := anthropiccompat.ProxyDirectWithTimeout(c.Routing.BaseURL, c.APIKey, c.Body, timeouts.RequestTimeout)
+	return resp, c.Routing.BaseURL, err
+}
+
+func (antigravityProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	if c.Dialect == dialectOpenAI {
+		in, out, toolCall, cacheHit := antigravity.StreamTransformToOpenAI(body, w, c.Routing.Model)
+		return Usage{InputTokens: in, OutputTokens: out, ToolCall: toolCall, CacheHit: cacheHit}
+	}
+	in, out, cacheHit := antigravity.StreamTransform(body, w)
+	return Usage{InputTokens: in, OutputTokens: out, CacheHit: cacheHit}
+}
+
+func (p antigravityProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	if c.Dialect == dialectOpenAI {
+		transformed, in, out, toolCall, cacheHit, err := antigravity.TransformResponseToOpenAI(body, c.Routing.Model)
+		return transformed, Usage{InputTokens: in, OutputTokens: out, ToolCall: toolCall, CacheHit: cacheHit}, err
+	}
+
+	// Tool calls and cache hits come from the original response, before tool
+	// execution rewrites it.
+	usage := p.ExtractUsage(body)
+	final, in, out, err := c.h.handleToolExecution(body, c.Routing, c.Body)
+	if err != nil {
+		c.h.runnerLogger.Printf("ERROR [tool_execution] model=%s err=%v", c.Routing.Model, err)
+		return body, usage, nil
+	}
+	usage.InputTokens, usage.OutputTokens = in, out
+	return final, usage, nil
+}
+
+func (antigravityProvider) ExtractUsage(body []byte) Usage {
+	return anthropicUsage(body)
+}
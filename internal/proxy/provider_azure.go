@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/openaicompat"
+)
+
+// newAzureOpenAIProvider returns the OpenAI-compatible adapter for Azure
+// OpenAI. Requests go to the deployment that deployments maps the
+// llm_models.model_name to (the model name itself when unmapped), with the
+// api-key header. An api-version on the provider's base URL overrides
+// apiVersion.
+func newAzureOpenAIProvider(apiVersion string, deployments map[string]string) openAICompatProvider {
+	if apiVersion == "" {
+		apiVersion = openaicompat.DefaultAzureAPIVersion
+	}
+	return openAICompatProvider{
+		prepare: includeStreamUsage,
+		send: func(c *ProviderCall, apiKey string, body []byte) (*http.Response, string, error) {
+			deployment := c.Routing.Model
+			if d, ok := deployments[deployment]; ok {
+				deployment = d
+			}
+			apiURL := openaicompat.AzureURL(c.Routing.BaseURL, deployment, apiVersion)
+			resp, err := openaicompat.AzureProxy(apiURL, apiKey, body)
+			return resp, apiURL, err
+		},
+	}
+}
+
+// ConfigureAzureOpenAI sets the api-version and the deployment names (a JSON
+// object mapping llm_models.model_name to a deployment, such as {"gpt-4o":
+// "prod-gpt4o"}) for the azure_openai provider type. Empty values keep the
+// defaults. It must be called before the handler serves requests.
+func (h *Handler) ConfigureAzureOpenAI(apiVersion, deployments string) error {
+	var names map[string]string
+	if deployments != "" {
+		if err := json.Unmarshal([]byte(deployments), &names); err != nil {
+			return fmt.Errorf("invalid Azure OpenAI deployments: %w", err)
+		}
+	}
+	h.providers["azure_openai"] = newAzureOpenAIProvider(apiVersion, names)
+	return nil
+}
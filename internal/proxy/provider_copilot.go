@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/antigravity"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/copilot"
+)
+
+// copilotProvider sends requests to a cliproxy (GHCP) upstream, which speaks
+// Anthropic Messages. OpenAI requests are relayed untouched, response and all,
+// without usage accounting; Anthropic responses are passed through with their
+// usage read.
+type copilotProvider struct{}
+
+func (copilotProvider) BuildRequest(c *ProviderCall) error {
+	c.UpstreamStream = c.Stream
+	if c.Dialect == dialectOpenAI {
+		c.Body = c.ClientBody
+		c.Relay = true
+		return nil
+	}
+
+	// Replace model with routed model and inject system message
+	body := c.h.orchestrateOrFallback(c.ClientBody, c.Routing, c.Username)
+
+	// The upstream OpenAI-compatible endpoint rejects duplicate tool_call_id values
+	body = anthropiccompat.DeduplicateToolResults(body)
+	body = anthropiccompat.SanitizeEmptyToolNames(body)
+
+	// Strip extended thinking params/blocks — GHCP does not support them
+	c.Body = anthropiccompat.StripThinking(body)
+	return nil
+}
+
+func (copilotProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	return copilot.ProxyToCopilot(c.Routing.BaseURL, c.APIKey, c.Routing.Model, c.Body, c.UpstreamStream)
+}
+
+func (copilotProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	in, out, cacheHit := antigravity.StreamTransform(body, w)
+	return Usage{InputTokens: in, OutputTokens: out, CacheHit: cacheHit}
+}
+
+func (p copilotProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	return body, p.ExtractUsage(body), nil
+}
+
+func (copilotProvider) ExtractUsage(body []byte) Usage {
+	return anthropicUsage(body)
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/googleaistudio"
+)
+
+// googleAIStudioProvider converts requests to Gemini generateContent.
+// Anthropic requests go through OpenAI format both ways and are never streamed
+// from the upstream.
+type googleAIStudioProvider struct{}
+
+func (googleAIStudioProvider) BuildRequest(c *ProviderCall) error {
+	openaiBody := c.ClientBody
+	if c.Dialect == dialectAnthropic {
+		var err error
+		if openaiBody, _, err = anthropiccompat.AnthropicToOpenAI(c.ClientBody, false); err != nil {
+			return err
+		}
+	}
+	openaiBody, err := setModel(openaiBody, c.Routing.Model)
+	if err != nil {
+		return err
+	}
+	geminiBody, _, isStream, err := googleaistudio.OpenAIToGemini(openaiBody)
+	if err != nil {
+		return err
+	}
+	c.Body = geminiBody
+	c.UpstreamStream = isStream && c.Dialect == dialectOpenAI
+	return nil
+}
+
+func (googleAIStudioProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	resp, err := googleaistudio.Proxy(c.Routing.BaseURL, c.APIKey, c.Routing.Model, c.Body, c.UpstreamStream)
+	return resp, c.Routing.BaseURL, err
+}
+
+func (googleAIStudioProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	var u Usage
+	u.InputTokens, u.OutputTokens, u.ToolCall, u.CacheHit = googleaistudio.StreamTransformToOpenAI(body, w, c.Routing.Model)
+	return u
+}
+
+func (googleAIStudioProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	var u Usage
+	openaiResp, in, out, toolCall, cacheHit, err := googleaistudio.GeminiToOpenAI(body, c.Routing.Model)
+	if err != nil || c.Dialect == dialectOpenAI {
+		u = Usage{InputTokens: in, OutputTokens: out, ToolCall: toolCall, CacheHit: cacheHit}
+		return openaiResp, u, err
+	}
+	// Google AI Studio does not expose prompt cache info to Anthropic clients
+	anthropicResp, in, out, toolCall, _, err := anthropiccompat.OpenAIResponseToAnthropic(openaiResp, c.Routing.Model)
+	u = Usage{InputTokens: in, OutputTokens: out, ToolCall: toolCall}
+	return anthropicResp, u, err
+}
+
+func (googleAIStudioProvider) ExtractUsage(body []byte) Usage {
+	var u Usage
+	u.InputTokens, u.OutputTokens = googleaistudio.ExtractTokens(body)
+	return u
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/ollama"
+)
+
+// ollamaProvider converts requests to Ollama's native /api/chat. Both dialects
+// go through OpenAI format; Anthropic streams are converted twice on the fly
+// (NDJSON to OpenAI SSE to Anthropic SSE). Tokens come from prompt_eval_count
+// and eval_count.
+type ollamaProvider struct{}
+
+func (ollamaProvider) BuildRequest(c *ProviderCall) error {
+	openaiBody := c.ClientBody
+	if c.Dialect == dialectAnthropic {
+		var err error
+		if openaiBody, _, err = anthropiccompat.AnthropicToOpenAI(c.ClientBody, false); err != nil {
+			return err
+		}
+	}
+	openaiBody, err := setModel(openaiBody, c.Routing.Model)
+	if err != nil {
+		return err
+	}
+	body, isStream, err := ollama.OpenAIToOllama(openaiBody)
+	if err != nil {
+		return err
+	}
+	c.Body = body
+	c.UpstreamStream = isStream
+	return nil
+}
+
+func (ollamaProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	resp, err := ollama.Proxy(c.Routing.BaseURL, c.APIKey, c.Body)
+	return resp, strings.TrimRight(c.Routing.BaseURL, "/") + "/api/chat", err
+}
+
+func (ollamaProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	var u Usage
+	if c.Dialect == dialectOpenAI {
+		u.InputTokens, u.OutputTokens, u.ToolCall = ollama.StreamTransformToOpenAI(body, w, c.Routing.Model)
+		return u
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.InputTokens, u.OutputTokens, u.ToolCall = ollama.StreamTransformToOpenAI(body, pw, c.Routing.Model)
+		pw.Close()
+	}()
+	anthropiccompat.OpenAIStreamToAnthropicStream(pr, w, c.Routing.Model)
+	pr.Close() // unblocks the converter if the Anthropic side stopped early
+	<-done
+	return u
+}
+
+func (ollamaProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	var u Usage
+	openaiResp, in, out, toolCall, err := ollama.OllamaToOpenAI(body, c.Routing.Model)
+	u = Usage{InputTokens: in, OutputTokens: out, ToolCall: toolCall}
+	if err != nil || c.Dialect == dialectOpenAI {
+		return openaiResp, u, err
+	}
+	anthropicResp, _, _, _, _, err := anthropiccompat.OpenAIResponseToAnthropic(openaiResp, c.Routing.Model)
+	return anthropicResp, u, err
+}
+
+func (ollamaProvider) ExtractUsage(body []byte) Usage {
+	var u Usage
+	u.InputTokens, u.OutputTokens = ollama.ExtractTokens(body)
+	return u
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/openaicompat"
+)
+
+// openAICompatProvider sends Chat Completions requests to path on the
+// provider's base URL. Anthropic requests are converted; unless they come from
+// Claude Code, which runs its own tools, the upstream is asked not to stream so
+// proxy-injected tools can be executed.
+type openAICompatProvider struct {
+	path string
+
+	header       http.Header                       // extra request headers
+	model        func(string) string               // maps llm_models.model_name to the upstream model id
+	prepare      func(body map[string]interface{}) // provider-specific request fields
+	cacheControl bool                              // upstream honours Anthropic cache_control breakpoints
+
+	// send replaces the POST to base URL + path, for upstreams with their own
+	// URL scheme or auth (Azure OpenAI). It returns the URL for logging.
+	send func(c *ProviderCall, apiKey string, body []byte) (*http.Response, string, error)
+}
+
+func (p openAICompatProvider) BuildRequest(c *ProviderCall) error {
+	var body map[string]interface{}
+	if c.Dialect == dialectOpenAI {
+		if err := json.Unmarshal(c.ClientBody, &body); err != nil {
+			return err
+		}
+		c.UpstreamStream = c.Stream
+		c.PassHeaders = true
+	} else {
+		// Preserve cache_control for OpenRouter
+		cacheControl := p.cacheControl || strings.Contains(c.Routing.BaseURL, "openrouter.ai")
+		openaiBody, _, err := anthropiccompat.AnthropicToOpenAI(c.ClientBody, cacheControl)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(openaiBody, &body); err != nil {
+			return err
+		}
+
+		c.toolExec = !anthropiccompat.IsClaudeCodeRequest(c.ClientBody)
+		c.UpstreamStream = c.Stream && !c.toolExec
+		if c.Stream && c.toolExec {
+			body["stream"] = false
+			delete(body, "stream_options")
+		}
+
+		// Re-cap max_tokens using the routed model name, since the original request used
+		// the client-facing model name (e.g. claude-sonnet-4-6) not the actual upstream model.
+		if mt, ok := body["max_tokens"].(float64); ok {
+			body["max_tokens"] = anthropiccompat.CapMaxTokens(c.Routing.Model, int(mt))
+		}
+
+		// DeepSeek Reasoner requires reasoning_content on every assistant message in history.
+		// Inject empty string for any assistant message that lacks it.
+		if strings.Contains(c.Routing.BaseURL, "deepseek.com") {
+			if msgs, ok := body["messages"].([]interface{}); ok {
+				for _, m := range msgs {
+					if msg, ok := m.(map[string]interface{}); ok && msg["role"] == "assistant" {
+						if _, has := msg["reasoning_content"]; !has {
+							msg["reasoning_content"] = ""
+						}
+					}
+				}
+			}
+		}
+	}
+
+	body["model"] = c.Routing.Model
+	if p.model != nil {
+		body["model"] = p.model(c.Routing.Model)
+	}
+	if p.prepare != nil {
+		p.prepare(body)
+	}
+
+	var err error
+	c.Body, err = json.Marshal(body)
+	return err
+}
+
+func (p openAICompatProvider) Send(c *ProviderCall) (*http.Response, string, error) {
+	return p.post(c, c.APIKey, c.Body)
+}
+
+// post sends an OpenAI-format body for c; tool continuations use it too.
+func (p openAICompatProvider) post(c *ProviderCall, apiKey string, body []byte) (*http.Response, string, error) {
+	if p.send != nil {
+		return p.send(c, apiKey, body)
+	}
+	resp, err := openaicompat.ProxyWithHeaders(c.Routing.BaseURL, apiKey, p.path, body, p.header)
+	return resp, c.Routing.BaseURL + p.path, err
+}
+
+func (openAICompatProvider) TransformStream(c *ProviderCall, body io.Reader, w io.Writer) Usage {
+	var u Usage
+	costs := openaicompat.NewCostReader(body)
+	if c.Dialect == dialectOpenAI {
+		u.InputTokens, u.OutputTokens, u.ToolCall, u.CacheHit = openaicompat.StreamTransform(costs, w)
+	} else {
+		u.InputTokens, u.OutputTokens, u.ToolCall, u.CacheHit = anthropiccompat.OpenAIStreamToAnthropicStream(costs, w, c.Routing.Model)
+	}
+	u.Cost = costs.Cost()
+	return u
+}
+
+func (p openAICompatProvider) TransformResponse(c *ProviderCall, body []byte) ([]byte, Usage, error) {
+	if c.Dialect == dialectOpenAI {
+		return body, p.ExtractUsage(body), nil
+	}
+
+	// Tool continuations are not priced: only the first call's cost is known.
+	u := Usage{Cost: openaicompat.ExtractCost(body)}
+	if c.toolExec {
+		send := func(apiKey string, body []byte) (*http.Response, error) {
+			resp, _, err := p.post(c, apiKey, body)
+			return resp, err
+		}
+		anthropicResp, in, out, toolCall, err := c.h.handleToolExecutionOpenAI(body, c.Routing, c.Body, c.Routing.Model, send)
+		if err == nil {
+			u.InputTokens, u.OutputTokens, u.ToolCall = in, out, toolCall
+			return anthropicResp, u, nil
+		}
+		c.h.runnerLogger.Printf("ERROR [tool_execution] model=%s err=%v", c.Routing.Model, err)
+	}
+	anthropicResp, in, out, toolCall, cacheHit, err := anthropiccompat.OpenAIResponseToAnthropic(body, c.Routing.Model)
+	u.InputTokens, u.OutputTokens, u.ToolCall, u.CacheHit = in, out, toolCall, cacheHit
+	return anthropicResp, u, err
+}
+
+// TransformError turns Azure OpenAI content-filter errors into proxy errors in
+// the client's dialect, naming the filtered categories.
+func (openAICompatProvider) TransformError(c *ProviderCall, body []byte) (string, string, bool) {
+	message, ok := openaicompat.ContentFilterError(body)
+	return "invalid_request_error", message, ok
+}
+
+func (openAICompatProvider) ExtractUsage(body []byte) Usage {
+	var u Usage
+	u.InputTokens, u.OutputTokens, u.CacheHit, _ = openaicompat.ExtractTokens(body)
+	u.ToolCall = openaicompat.DetectToolCall(body)
+	u.Cost = openaicompat.ExtractCost(body)
+	return u
+}
+
+// nimModelName maps an llm_models.model_name to an NVIDIA NIM model id. NIM
+// ids are "publisher/model" in lower case (meta/llama-3.3-70b-instruct); a
+// "nvidia_nim/" or "nim/" prefix used to tell NIM rows apart from the same
+// model on other upstreams is dropped.
+func nimModelName(model string) string {
+	for _, prefix := range []string{"nvidia_nim/", "nim/"} {
+		if len(model) > len(prefix) && strings.EqualFold(model[:len(prefix)], prefix) {
+			model = model[len(prefix):]
+			break
+		}
+	}
+	return strings.ToLower(model)
+}
+
+// includeStreamUsage asks an OpenAI-compatible server for a final usage chunk
+// on streams (llama.cpp only sends one when asked).
+func includeStreamUsage(body map[string]interface{}) {
+	if stream, _ := body["stream"].(bool); stream {
+		if _, ok := body["stream_options"]; !ok {
+			body["stream_options"] = map[string]interface{}{"include_usage": true}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Defaults for OpenRouter's app attribution headers.
+const (
+	defaultOpenRouterReferer = "https://github.com/rpay/apipod-smart-proxy"
+	defaultOpenRouterTitle   = "APIPod Smart Proxy"
+)
+
+// newOpenRouterProvider returns the OpenAI-compatible adapter for OpenRouter.
+// It identifies the proxy with HTTP-Referer/X-Title, keeps cache_control
+// breakpoints, asks for usage accounting so usage.cost can be logged, and sends
+// preferences as the "provider" routing object unless the request has its own.
+func newOpenRouterProvider(referer, title string, preferences map[string]interface{}) openAICompatProvider {
+	if referer == "" {
+		referer = defaultOpenRouterReferer
+	}
+	if title == "" {
+		title = defaultOpenRouterTitle
+	}
+	header := http.Header{}
+	header.Set("HTTP-Referer", referer)
+	header.Set("X-Title", title)
+
+	return openAICompatProvider{
+		path:         "/api/v1/chat/completions",
+		header:       header,
+		cacheControl: true,
+		prepare: func(body map[string]interface{}) {
+			if _, ok := body["usage"]; !ok {
+				body["usage"] = map[string]interface{}{"include": true}
+			}
+			if _, ok := body["provider"]; !ok && preferences != nil {
+				body["provider"] = preferences
+			}
+		},
+	}
+}
+
+// ConfigureOpenRouter sets the attribution headers and default provider
+// routing preferences (a JSON object such as {"order": ["DeepInfra"],
+// "allow_fallbacks": true}) for the openrouter provider type. Empty values keep
+// the defaults. It must be called before the handler serves requests.
+func (h *Handler) ConfigureOpenRouter(referer, title, preferences string) error {
+	var prefs map[string]interface{}
+	if preferences != "" {
+		if err := json.Unmarshal([]byte(preferences), &prefs); err != nil {
+			return fmt.Errorf("invalid OpenRouter provider preferences: %w", err)
+		}
+	}
+	h.providers["openrouter"] = newOpenRouterProvider(referer, title, prefs)
+	return nil
+}
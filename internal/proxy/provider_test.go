@@ -0,0 +1,453 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
+	"github.com/rpay/apipod-smart-proxy/internal/upstream/openaicompat"
+)
+
+func newTestHandler() *Handler {
+	return &Handler{
+		logger:       log.New(io.Discard, "", 0),
+		runnerLogger: log.New(io.Discard, "", 0),
+		pools:        map[int64]*pool.AccountPool{0: nil}, // no pool: use the provider key
+		providers:    defaultProviders(),
+	}
+}
+
+func TestForwardOpenAICompat(t *testing.T) {
+	var gotPath, gotAuth, gotModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	routing := RoutingResult{ProviderType: "groq", BaseURL: upstream.URL, APIKey: "gsk-test-key", Model: "llama-3.3-70b"}
+	rec := httptest.NewRecorder()
+	in, out, _ := newTestHandler().forward(rec, nil, dialectOpenAI, routing, &database.User{Username: "u"}, "gpt-4o", []byte(`{"model":"gpt-4o","messages":[]}`), 1)
+
+	if gotPath != "/openai/v1/chat/completions" {
+		t.Errorf("path = %q", gotPath)
+	}
+	if gotAuth != "Bearer gsk-test-key" || gotModel != "llama-3.3-70b" {
+		t.Errorf("auth = %q, model = %q", gotAuth, gotModel)
+	}
+	if in != 12 || out != 3 {
+		t.Errorf("tokens = %d/%d, want 12/3", in, out)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestForwardUnsupportedProvider(t *testing.T) {
+	routing := RoutingResult{ProviderType: "carrier_pigeon"}
+	for dialect, want := range map[string]string{
+		dialectOpenAI:    `{"error": "Unsupported provider type"}`,
+		dialectAnthropic: `{"error": {"type": "api_error", "message": "Unsupported provider type"}}`,
+	} {
+		rec := httptest.NewRecorder()
+		aw := newAttemptWriter(rec, 1, true)
+		newTestHandler().forward(aw, nil, dialect, routing, &database.User{}, "m", []byte(`{}`), 1)
+		if aw.held || !aw.ownFault {
+			t.Errorf("%s: a misconfigured provider type must not fail over or count against health", dialect)
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status = %d, want 500", dialect, rec.Code)
+		}
+		if got := rec.Body.String(); got != want+"\n" {
+			t.Errorf("%s: body = %q, want %q", dialect, got, want)
+		}
+	}
+}
+
+func TestConfigureProvidersPerHandler(t *testing.T) {
+	h := newTestHandler()
+	if err := h.ConfigureOpenRouter("https://example.com", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.providers["openrouter"].(openAICompatProvider).header.Get("HTTP-Referer"); got != "https://example.com" {
+		t.Fatalf("HTTP-Referer = %q, configuration not applied", got)
+	}
+	if got := newTestHandler().providers["openrouter"].(openAICompatProvider).header.Get("HTTP-Referer"); got != defaultOpenRouterReferer {
+		t.Errorf("HTTP-Referer = %q: configuration leaked into another handler", got)
+	}
+}
+
+func TestCopilotRelaysOpenAIRequests(t *testing.T) {
+	const body = "data: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":5}}}\n\n"
+	var gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key") + r.Header.Get("Authorization")
+		w.Header().Set("X-Upstream", "1")
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	routing := RoutingResult{ProviderType: "cliproxy", BaseURL: upstream.URL, APIKey: "ghcp-test-key", Model: "claude-sonnet-4"}
+	rec := httptest.NewRecorder()
+	in, out, _ := newTestHandler().forward(rec, nil, dialectOpenAI, routing, &database.User{}, "m", []byte(`{"model":"m","stream":true,"messages":[]}`), 1)
+
+	if !strings.Contains(gotKey, "ghcp-test-key") {
+		t.Errorf("upstream auth = %q", gotKey)
+	}
+	if rec.Body.String() != body || rec.Header().Get("X-Upstream") != "" {
+		t.Errorf("got headers %v body %q, want the body relayed without headers", rec.Header(), rec.Body.String())
+	}
+	if in != 0 || out != 0 {
+		t.Errorf("tokens = %d/%d, want none counted", in, out)
+	}
+}
+
+func TestOpenRouterProvider(t *testing.T) {
+	var gotPath, gotReferer string
+	var got map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotReferer = r.URL.Path, r.Header.Get("HTTP-Referer")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":20,\"completion_tokens\":5,\"cost\":0.00042}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	p := newOpenRouterProvider("https://example.com", "", map[string]interface{}{"sort": "price"})
+	c := &ProviderCall{
+		Routing:    RoutingResult{BaseURL: upstream.URL, Model: "meta-llama/llama-3.3-70b-instruct"},
+		Dialect:    dialectOpenAI,
+		ClientBody: []byte(`{"model":"llama","stream":true,"messages":[]}`),
+		Stream:     true,
+	}
+	if err := p.BuildRequest(c); err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := p.Send(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	u := p.TransformStream(c, resp.Body, io.Discard)
+
+	if gotPath != "/api/v1/chat/completions" || gotReferer != "https://example.com" {
+		t.Errorf("path = %q, referer = %q", gotPath, gotReferer)
+	}
+	if prefs, _ := got["provider"].(map[string]interface{}); prefs["sort"] != "price" {
+		t.Errorf("provider = %v", got["provider"])
+	}
+	if got["model"] != "meta-llama/llama-3.3-70b-instruct" || got["usage"] == nil {
+		t.Errorf("model = %v, usage = %v", got["model"], got["usage"])
+	}
+	if u.InputTokens != 20 || u.OutputTokens != 5 || u.Cost != 0.00042 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestNIMModelName(t *testing.T) {
+	for in, want := range map[string]string{
+		"meta/llama-3.3-70b-instruct":            "meta/llama-3.3-70b-instruct",
+		"nvidia_nim/meta/llama-3.3-70b-instruct": "meta/llama-3.3-70b-instruct",
+		"NIM/DeepSeek-AI/DeepSeek-R1":            "deepseek-ai/deepseek-r1",
+	} {
+		if got := nimModelName(in); got != want {
+			t.Errorf("nimModelName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAzureOpenAIProvider(t *testing.T) {
+	var gotURL, gotKey, gotAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL, gotKey, gotAuth = r.URL.String(), r.Header.Get("api-key"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"\",\"choices\":[],\"prompt_filter_results\":[{\"prompt_index\":0,\"content_filter_results\":{}}]}\n\n"))
+		w.Write([]byte("data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"content_filter\"}]}\n\n"))
+		w.Write([]byte("data: {\"id\":\"chatcmpl-1\",\"choices\":[],\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":1}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	p := newAzureOpenAIProvider("2024-06-01", map[string]string{"gpt-4o": "prod-gpt4o"})
+	tests := []struct {
+		dialect string
+		body    string
+		want    string // in the client stream
+	}{
+		{dialectOpenAI, `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`, `"content":"hi"`},
+		// A Claude Code request (system blocks and tools) is streamed upstream.
+		{dialectAnthropic, `{"model":"gpt-4o","stream":true,"max_tokens":10,"system":[{"type":"text","text":"s"}],"tools":[{"name":"t","input_schema":{"type":"object"}}],"messages":[{"role":"user","content":"hi"}]}`, `"stop_reason":"refusal"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			c := &ProviderCall{
+				Routing:    RoutingResult{BaseURL: upstream.URL + "/", Model: "gpt-4o"},
+				Dialect:    tt.dialect,
+				ClientBody: []byte(tt.body),
+				Stream:     true,
+				APIKey:     "azure-key",
+			}
+			if err := p.BuildRequest(c); err != nil {
+				t.Fatal(err)
+			}
+			resp, _, err := p.Send(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var out strings.Builder
+			u := p.TransformStream(c, resp.Body, &out)
+
+			if gotURL != "/openai/deployments/prod-gpt4o/chat/completions?api-version=2024-06-01" {
+				t.Errorf("url = %q", gotURL)
+			}
+			if gotKey != "azure-key" || gotAuth != "" {
+				t.Errorf("api-key = %q, authorization = %q", gotKey, gotAuth)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("client stream:\n%s", out.String())
+			}
+			// OpenAI clients get the annotations on the first chunk with
+			// choices, never as a chunk of their own.
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.Contains(line, "prompt_filter_results") && (tt.dialect != dialectOpenAI || !strings.Contains(line, `"content":"hi"`)) {
+					t.Errorf("prompt_filter_results in %q", line)
+				}
+			}
+			if tt.dialect == dialectOpenAI && !strings.Contains(out.String(), `"prompt_filter_results":[{"prompt_index":0`) {
+				t.Errorf("prompt_filter_results dropped:\n%s", out.String())
+			}
+			if u.InputTokens != 9 || u.OutputTokens != 1 {
+				t.Errorf("usage = %+v", u)
+			}
+		})
+	}
+
+	if got := openaicompat.AzureURL("https://r.openai.azure.com?api-version=2025-01-01-preview", "d", "2024-06-01"); got != "https://r.openai.azure.com/openai/deployments/d/chat/completions?api-version=2025-01-01-preview" {
+		t.Errorf("url with api-version = %q", got)
+	}
+}
+
+func TestAzurePromptFilterResultsWithoutChoices(t *testing.T) {
+	in := "data: {\"id\":\"\",\"choices\":[],\"prompt_filter_results\":[{\"prompt_index\":0}]}\n\ndata: [DONE]\n\n"
+	var out strings.Builder
+	openaicompat.StreamTransform(strings.NewReader(in), &out)
+	if !strings.Contains(out.String(), `"prompt_filter_results":[{"prompt_index":0}]}`) || !strings.HasSuffix(out.String(), "data: [DONE]\n\n") {
+		t.Errorf("client stream:\n%s", out.String())
+	}
+}
+
+func TestAzureContentFilterError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"The response was filtered","code":"content_filter","status":400,"innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":false},"violence":{"filtered":true,"severity":"high"}}}}}`))
+	}))
+	defer upstream.Close()
+
+	routing := RoutingResult{ProviderType: "azure_openai", BaseURL: upstream.URL, APIKey: "k", Model: "gpt-4o"}
+	rec := httptest.NewRecorder()
+	newTestHandler().forward(rec, nil, dialectAnthropic, routing, &database.User{}, "m", []byte(`{"model":"m","max_tokens":10,"messages":[]}`), 1)
+
+	want := `{"error": {"type": "invalid_request_error", "message": "The response was filtered (filtered: violence)"}}` + "\n"
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != want {
+		t.Errorf("got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	newTestHandler().forward(rec, nil, dialectOpenAI, routing, &database.User{}, "m", []byte(`{"model":"m","messages":[]}`), 1)
+	want = `{"error": "The response was filtered (filtered: violence)"}` + "\n"
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != want {
+		t.Errorf("OpenAI: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	var gotPath, gotKey, gotVersion, gotBeta string
+	var got map[string]json.RawMessage
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotKey = r.URL.Path, r.Header.Get("x-api-key")
+		gotVersion, gotBeta = r.Header.Get("anthropic-version"), r.Header.Get("anthropic-beta")
+		json.NewDecoder(r.Body).Decode(&got)
+		if string(got["stream"]) != "true" {
+			w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":4,"output_tokens":2,"cache_creation_input_tokens":0,"cache_read_input_tokens":900}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":5,\"output_tokens\":1,\"cache_creation_input_tokens\":1200,\"cache_read_input_tokens\":0}}}\n\n"))
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"))
+		w.Write([]byte("event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":7}}\n\n"))
+		w.Write([]byte("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+	}))
+	defer upstream.Close()
+
+	p := anthropicProvider{version: "2023-06-01", beta: []string{"prompt-caching-2024-07-31"}}
+	routing := RoutingResult{BaseURL: upstream.URL, Model: "claude-sonnet-4-5"}
+
+	// Anthropic client: the body and client headers reach the API as sent.
+	system := `[{"type":"text","text":"big prompt","cache_control":{"type":"ephemeral"}}]`
+	thinking := `{"type":"enabled","budget_tokens":2048}`
+	header := http.Header{}
+	header.Set("anthropic-version", "2024-01-01")
+	header.Add("anthropic-beta", "interleaved-thinking-2025-05-14, prompt-caching-2024-07-31")
+	c := &ProviderCall{
+		Routing:    routing,
+		Dialect:    dialectAnthropic,
+		ClientBody: []byte(`{"model":"claude-sonnet-4-6","stream":true,"max_tokens":4096,"system":` + system + `,"thinking":` + thinking + `,"messages":[{"role":"user","content":"hi"}]}`),
+		Header:     header,
+		Stream:     true,
+		APIKey:     "sk-ant-test",
+	}
+	if err := p.BuildRequest(c); err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := p.Send(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	u := p.TransformStream(c, resp.Body, &out)
+	resp.Body.Close()
+
+	if gotPath != "/v1/messages" || gotKey != "sk-ant-test" {
+		t.Errorf("path = %q, x-api-key = %q", gotPath, gotKey)
+	}
+	if gotVersion != "2024-01-01" || gotBeta != "interleaved-thinking-2025-05-14,prompt-caching-2024-07-31" {
+		t.Errorf("anthropic-version = %q, anthropic-beta = %q", gotVersion, gotBeta)
+	}
+	if string(got["system"]) != system || string(got["thinking"]) != thinking || string(got["model"]) != `"claude-sonnet-4-5"` {
+		t.Errorf("upstream request = %s / %s / %s", got["system"], got["thinking"], got["model"])
+	}
+	if !strings.Contains(out.String(), "event: message_stop") {
+		t.Errorf("client stream:\n%s", out.String())
+	}
+	if u.InputTokens != 5 || u.OutputTokens != 7 || u.CacheCreationTokens != 1200 || u.CacheReadTokens != 0 || u.CacheHit {
+		t.Errorf("stream usage = %+v", u)
+	}
+
+	// OpenAI client: converted to Messages and back, with the configured headers.
+	c = &ProviderCall{
+		Routing:    routing,
+		Dialect:    dialectOpenAI,
+		ClientBody: []byte(`{"model":"gpt-4o","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`),
+	}
+	if err := p.BuildRequest(c); err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err = p.Send(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	converted, u, err := p.TransformResponse(c, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotVersion != "2023-06-01" || gotBeta != "prompt-caching-2024-07-31" || string(got["system"]) != `"be brief"` {
+		t.Errorf("anthropic-version = %q, anthropic-beta = %q, system = %s", gotVersion, gotBeta, got["system"])
+	}
+	if !strings.Contains(string(converted), `"chat.completion"`) {
+		t.Errorf("response = %s", converted)
+	}
+	if u.InputTokens != 4 || u.OutputTokens != 2 || u.CacheReadTokens != 900 || !u.CacheHit {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestOllamaProviderStream(t *testing.T) {
+	var got map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n"))
+		w.Write([]byte(`{"message":{"role":"assistant","content":"lo"},"done":false}` + "\n"))
+		w.Write([]byte(`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":11,"eval_count":2}` + "\n"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		dialect string
+		body    string
+		want    string // in the client stream
+	}{
+		{dialectOpenAI, `{"model":"m","stream":true,"max_tokens":50,"messages":[{"role":"user","content":"hi"}]}`, `"content":"lo"`},
+		{dialectAnthropic, `{"model":"m","stream":true,"max_tokens":50,"messages":[{"role":"user","content":"hi"}]}`, `event: message_stop`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			routing := RoutingResult{ProviderType: "local_ollama", BaseURL: upstream.URL, Model: "llama3.2:3b"}
+			rec := httptest.NewRecorder()
+			in, out, _ := newTestHandler().forward(rec, nil, tt.dialect, routing, &database.User{}, "m", []byte(tt.body), 1)
+
+			if got["model"] != "llama3.2:3b" || got["stream"] != true {
+				t.Errorf("upstream request = %v", got)
+			}
+			if opts, _ := got["options"].(map[string]interface{}); opts["num_predict"] != float64(50) {
+				t.Errorf("options = %v", got["options"])
+			}
+			if in != 11 || out != 2 {
+				t.Errorf("tokens = %d/%d, want 11/2", in, out)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("client stream lacks %s:\n%s", tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+type fakeDiscoverySource struct {
+	providers []database.DiscoveryProvider
+	synced    map[int64][]string
+}
+
+func (f *fakeDiscoverySource) GetProvidersByType(providerType string) ([]database.DiscoveryProvider, error) {
+	if providerType != "local_ollama" {
+		return nil, nil
+	}
+	return f.providers, nil
+}
+
+func (f *fakeDiscoverySource) SyncDiscoveredModels(providerID int64, names []string) ([]string, []string, error) {
+	f.synced[providerID] = names
+	return names, nil, nil
+}
+
+func TestModelDiscovery(t *testing.T) {
+	box := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"llama3.2:3b"},{"name":"qwen2.5:7b"}]}`))
+	}))
+	defer box.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	src := &fakeDiscoverySource{
+		providers: []database.DiscoveryProvider{{ID: 7, BaseURL: box.URL}, {ID: 8, BaseURL: down.URL}},
+		synced:    make(map[int64][]string),
+	}
+	NewModelDiscovery(src, log.New(io.Discard, "", 0)).Discover()
+
+	if got := strings.Join(src.synced[7], ","); got != "llama3.2:3b,qwen2.5:7b" {
+		t.Errorf("provider 7 models = %q", got)
+	}
+	if _, ok := src.synced[8]; ok {
+		t.Error("an unreachable provider must keep its models")
+	}
+}
@@ -1,34 +1,46 @@
 package proxy
 
 import (
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
 )
 
 // RateLimiter enforces per-org RPM and daily request limits.
-// In-memory only — no external dependencies.
+// Counters live in a pool.CounterStore, so replicas sharing a store share limits.
 type RateLimiter struct {
-	buckets   map[uint]*orgBucket
-	mu        sync.Mutex
-}
+	store pool.CounterStore
+	loc   *time.Location
+	now   func() time.Time
+	mu    sync.Mutex // makes check-and-count atomic within this replica
 
-type orgBucket struct {
-	// RPM tracking: sliding window (1 minute)
-	requestTimestamps []time.Time
+	daily map[uint]dailyBase // guarded by mu
+}
 
-	// Daily tracking
-	dailyCount int
-	dailyDate  string // "2006-01-02"
+// dailyBase ties the backend's daily_used to the local counter: requests
+// counted locally after the backend reported dailyUsed are added on top of it.
+type dailyBase struct {
+	day       time.Time
+	dailyUsed int
+	mark      int64 // local count when dailyUsed was first seen
 }
 
-// NewRateLimiter creates a new in-memory rate limiter.
-func NewRateLimiter() *RateLimiter {
-	return &RateLimiter{
-		buckets: make(map[uint]*orgBucket),
+// NewRateLimiter creates a rate limiter counting in store (in process if nil)
+// whose daily quotas reset at midnight in loc (UTC if nil), like the
+// ModelLimiter's.
+func NewRateLimiter(store pool.CounterStore, loc *time.Location) *RateLimiter {
+	if store == nil {
+		store = pool.NewMemoryStore()
 	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &RateLimiter{store: store, loc: loc, now: time.Now, daily: make(map[uint]dailyBase)}
 }
 
-// AllowRequest checks if the org is within its RPM limit.
+// AllowRequest checks if the org is within its RPM limit over a rolling minute.
 // Returns false if limit exceeded.
 func (rl *RateLimiter) AllowRequest(orgID uint, rpm int) bool {
 	if rpm <= 0 {
@@ -38,32 +50,20 @@ func (rl *RateLimiter) AllowRequest(orgID uint, rpm int) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	bucket := rl.getOrCreateBucket(orgID)
-	now := time.Now()
-	windowStart := now.Add(-1 * time.Minute)
-
-	// Prune old timestamps outside the 1-minute window
-	valid := bucket.requestTimestamps[:0]
-	for _, ts := range bucket.requestTimestamps {
-		if ts.After(windowStart) {
-			valid = append(valid, ts)
-		}
-	}
-	bucket.requestTimestamps = valid
-
-	// Check limit
-	if len(bucket.requestTimestamps) >= rpm {
+	key := fmt.Sprintf("org:%d:rpm", orgID)
+	now := rl.now()
+	if pool.SlidingMinute(rl.store, key, now)+1 > float64(rpm) {
 		return false
 	}
 
 	// Record this request
-	bucket.requestTimestamps = append(bucket.requestTimestamps, now)
+	rl.store.Add(key, now.Truncate(time.Minute), 1)
 	return true
 }
 
 // CheckDailyQuota checks if the org is within its daily request quota.
-// dailyUsed comes from the backend config, but we also track locally
-// for requests not yet committed.
+// dailyUsed comes from the backend config, and requests this replica counted
+// since then are added on top of it.
 func (rl *RateLimiter) CheckDailyQuota(orgID uint, dailyUsed, dailyQuota int) bool {
 	if dailyQuota <= 0 {
 		return true // no cap
@@ -72,30 +72,52 @@ func (rl *RateLimiter) CheckDailyQuota(orgID uint, dailyUsed, dailyQuota int) bo
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	bucket := rl.getOrCreateBucket(orgID)
-	today := time.Now().Format("2006-01-02")
-
-	// Reset if new day
-	if bucket.dailyDate != today {
-		bucket.dailyCount = dailyUsed // sync from backend
-		bucket.dailyDate = today
-	}
-
-	if bucket.dailyCount >= dailyQuota {
+	key, today := rl.dailyKey(orgID)
+	if rl.dailyCount(orgID, key, today, dailyUsed) >= int64(dailyQuota) {
 		return false
 	}
 
-	bucket.dailyCount++
+	rl.store.Add(key, today, 1)
 	return true
 }
 
-func (rl *RateLimiter) getOrCreateBucket(orgID uint) *orgBucket {
-	if b, ok := rl.buckets[orgID]; ok {
-		return b
+// Status reports the org's request limit, the tighter of its RPM and daily
+// quota, or nil when it has neither.
+func (rl *RateLimiter) Status(orgID uint, rpm, dailyUsed, dailyQuota int) *pool.LimitStatus {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var status *pool.LimitStatus
+	if rpm > 0 {
+		status = pool.SlidingMinuteStatus(rl.store, fmt.Sprintf("org:%d:rpm", orgID), rl.now(), rpm, 1)
 	}
-	b := &orgBucket{
-		dailyDate: time.Now().Format("2006-01-02"),
+	if dailyQuota > 0 {
+		key, today := rl.dailyKey(orgID)
+		status = pool.Tighter(status, &pool.LimitStatus{
+			Limit:     dailyQuota,
+			Remaining: max(dailyQuota-int(rl.dailyCount(orgID, key, today, dailyUsed)), 0),
+			Reset:     today.AddDate(0, 0, 1),
+		})
 	}
-	rl.buckets[orgID] = b
-	return b
+	return status
+}
+
+// dailyCount returns today's request count for the org: the backend's
+// dailyUsed plus the requests counted here since that value was first seen.
+// A cached or lagging dailyUsed thus never hides this replica's own requests;
+// when the backend reports a new value, it is taken to include them.
+func (rl *RateLimiter) dailyCount(orgID uint, key string, today time.Time, dailyUsed int) int64 {
+	local := rl.store.Get(key, today)
+	base, ok := rl.daily[orgID]
+	if !ok || !base.day.Equal(today) || base.dailyUsed != dailyUsed {
+		base = dailyBase{day: today, dailyUsed: dailyUsed, mark: local}
+		rl.daily[orgID] = base
+	}
+	return int64(dailyUsed) + max(local-base.mark, 0)
+}
+
+// dailyKey returns the org's daily counter and midnight of today in rl.loc.
+func (rl *RateLimiter) dailyKey(orgID uint) (string, time.Time) {
+	y, m, d := rl.now().In(rl.loc).Date()
+	return fmt.Sprintf("org:%d:day", orgID), time.Date(y, m, d, 0, 0, 0, 0, rl.loc)
 }
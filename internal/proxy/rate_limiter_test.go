@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDailyQuotaAddsLocalRequestsToBackendCount(t *testing.T) {
+	rl := NewRateLimiter(nil, nil)
+
+	// The backend reports 5 used; its cached config then lags behind.
+	for i := 0; i < 3; i++ {
+		if !rl.CheckDailyQuota(1, 5, 10) {
+			t.Fatalf("request %d rejected", i+1)
+		}
+	}
+	if got := rl.Status(1, 0, 5, 10).Remaining; got != 2 {
+		t.Errorf("remaining = %d, want 2 (5 reported + 3 local)", got)
+	}
+
+	// A fresh config includes the requests counted so far.
+	if got := rl.Status(1, 0, 8, 10).Remaining; got != 2 {
+		t.Errorf("remaining after refresh = %d, want 2", got)
+	}
+	rl.CheckDailyQuota(1, 8, 10)
+	rl.CheckDailyQuota(1, 8, 10)
+	if rl.CheckDailyQuota(1, 8, 10) {
+		t.Error("request over the quota admitted")
+	}
+}
+
+func TestDailyQuotaResetsAtMidnightInLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+7", 7*3600)
+	rl := NewRateLimiter(nil, loc)
+	now := time.Date(2026, 1, 1, 16, 30, 0, 0, time.UTC) // 23:30 in loc
+	rl.now = func() time.Time { return now }
+
+	if !rl.CheckDailyQuota(1, 0, 1) {
+		t.Fatal("first request rejected")
+	}
+	if rl.CheckDailyQuota(1, 0, 1) {
+		t.Fatal("second request admitted")
+	}
+	status := rl.Status(1, 0, 0, 1)
+	if want := time.Date(2026, 1, 2, 0, 0, 0, 0, loc); !status.Reset.Equal(want) {
+		t.Errorf("reset = %s, want %s", status.Reset, want)
+	}
+
+	now = now.Add(time.Hour) // 00:30 the next day in loc
+	if !rl.CheckDailyQuota(1, 0, 1) {
+		t.Error("request after midnight rejected")
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/config"
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
+)
+
+// Client API dialects; they decide which rate-limit header family is sent.
+const (
+	dialectOpenAI    = "openai"
+	dialectAnthropic = "anthropic"
+)
+
+// limitStatus combines the org's limits with those of the routed model. The
+// request limit is whichever runs out first; token limits are per model only.
+func (h *Handler) limitStatus(cfg *config.RuntimeConfig, routing RoutingResult, estimatedTokens int) (requests, tokens *pool.LimitStatus) {
+	requests = h.rateLimiter.Status(cfg.OrgID, cfg.RateLimitRPM, cfg.DailyUsed, cfg.DailyQuota)
+	if routing.LLMModelID > 0 {
+		modelRequests, modelTokens := h.modelLimiter.Status(routing.LLMModelID, estimatedTokens)
+		requests = pool.Tighter(requests, modelRequests)
+		tokens = modelTokens
+	}
+	return requests, tokens
+}
+
+// setRateLimitHeaders reports the limits the way the official SDKs expect:
+// x-ratelimit-{limit,remaining,reset}-{requests,tokens} for OpenAI, and
+// anthropic-ratelimit-{requests,tokens}-{limit,remaining,reset} for Anthropic.
+// Nil statuses (unlimited) are left out.
+func setRateLimitHeaders(w http.ResponseWriter, dialect string, requests, tokens *pool.LimitStatus) {
+	now := time.Now()
+	header := w.Header()
+	set := func(kind string, s *pool.LimitStatus) {
+		if s == nil {
+			return
+		}
+		if dialect == dialectAnthropic {
+			header.Set("anthropic-ratelimit-"+kind+"-limit", strconv.Itoa(s.Limit))
+			header.Set("anthropic-ratelimit-"+kind+"-remaining", strconv.Itoa(s.Remaining))
+			header.Set("anthropic-ratelimit-"+kind+"-reset", s.Reset.UTC().Format(time.RFC3339))
+			return
+		}
+		header.Set("x-ratelimit-limit-"+kind, strconv.Itoa(s.Limit))
+		header.Set("x-ratelimit-remaining-"+kind, strconv.Itoa(s.Remaining))
+		header.Set("x-ratelimit-reset-"+kind, formatReset(s.Reset.Sub(now)))
+	}
+	set("requests", requests)
+	set("tokens", tokens)
+}
+
+// isRateLimitHeader reports whether an upstream response header is one of the
+// rate-limit headers the proxy sets itself.
+func isRateLimitHeader(key string) bool {
+	key = strings.ToLower(key)
+	return key == "retry-after" || strings.HasPrefix(key, "x-ratelimit-") || strings.HasPrefix(key, "anthropic-ratelimit-")
+}
+
+// rejectRateLimited answers 429 with the rate-limit headers and a Retry-After
+// pointing at when the exhausted limit has room again.
+func rejectRateLimited(w http.ResponseWriter, dialect string, requests, tokens *pool.LimitStatus, body string) {
+	setRateLimitHeaders(w, dialect, requests, tokens)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(time.Now(), requests, tokens)))
+	http.Error(w, body, http.StatusTooManyRequests)
+}
+
+// retryAfterSeconds picks the latest reset among exhausted limits, falling back
+// to the latest reset overall (e.g. a large request that exceeds the remaining
+// tokens), and never less than one second.
+func retryAfterSeconds(now time.Time, statuses ...*pool.LimitStatus) int {
+	var exhausted, any time.Time
+	for _, s := range statuses {
+		if s == nil {
+			continue
+		}
+		if s.Reset.After(any) {
+			any = s.Reset
+		}
+		if s.Remaining == 0 && s.Reset.After(exhausted) {
+			exhausted = s.Reset
+		}
+	}
+	reset := exhausted
+	if reset.IsZero() {
+		reset = any
+	}
+	return max(int(math.Ceil(reset.Sub(now).Seconds())), 1)
+}
+
+// formatReset renders a reset delay like OpenAI does ("20ms", "1s", "6m0s").
+func formatReset(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "0s"
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/pool"
+)
+
+func TestRejectRateLimitedHeaders(t *testing.T) {
+	reset := time.Now().Add(90 * time.Second)
+	requests := &pool.LimitStatus{Limit: 60, Remaining: 0, Reset: reset}
+	tokens := &pool.LimitStatus{Limit: 10000, Remaining: 9000, Reset: time.Now().Add(5 * time.Second)}
+
+	openai := httptest.NewRecorder()
+	rejectRateLimited(openai, dialectOpenAI, requests, tokens, `{"error": "Rate limit exceeded"}`)
+	if openai.Code != 429 {
+		t.Fatalf("status = %d", openai.Code)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":                    "90",
+		"x-ratelimit-limit-requests":     "60",
+		"x-ratelimit-remaining-requests": "0",
+		"x-ratelimit-reset-requests":     "1m30s",
+		"x-ratelimit-remaining-tokens":   "9000",
+	} {
+		if got := openai.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	anthropic := httptest.NewRecorder()
+	rejectRateLimited(anthropic, dialectAnthropic, requests, nil, `{}`)
+	if got := anthropic.Header().Get("anthropic-ratelimit-requests-reset"); got != reset.UTC().Format(time.RFC3339) {
+		t.Errorf("anthropic-ratelimit-requests-reset = %q", got)
+	}
+	if anthropic.Header().Get("anthropic-ratelimit-tokens-limit") != "" {
+		t.Error("unlimited tokens should not produce headers")
+	}
+}
+
+func TestUpstreamRateLimitHeadersDoNotReplaceProxys(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-remaining-requests", "9999")
+		w.Header().Set("x-ratelimit-limit-tokens", "2000000")
+		w.Header().Set("Retry-After", "1")
+		w.Header().Set("x-request-id", "req-1")
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	rec := httptest.NewRecorder()
+	setRateLimitHeaders(rec, dialectOpenAI, &pool.LimitStatus{Limit: 60, Remaining: 59, Reset: time.Now().Add(time.Second)}, nil)
+	aw := newAttemptWriter(rec, 1, false)
+	routing := RoutingResult{ProviderType: "groq", BaseURL: upstream.URL, APIKey: "gsk-test-key", Model: "llama-3.3-70b"}
+	newTestHandler().forward(aw, nil, dialectOpenAI, routing, &database.User{}, "gpt-4o", []byte(`{"model":"gpt-4o","messages":[]}`), 1)
+
+	if got := rec.Header().Get("x-ratelimit-remaining-requests"); got != "59" {
+		t.Errorf("x-ratelimit-remaining-requests = %q, want the proxy's 59", got)
+	}
+	for _, header := range []string{"x-ratelimit-limit-tokens", "Retry-After"} {
+		if got := rec.Header().Get(header); got != "" {
+			t.Errorf("upstream %s = %q passed through", header, got)
+		}
+	}
+	if rec.Header().Get("x-request-id") != "req-1" {
+		t.Error("other upstream headers should still pass through")
+	}
+}
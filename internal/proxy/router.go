@@ -1,13 +1,31 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rpay/apipod-smart-proxy/internal/database"
+	"github.com/rpay/apipod-smart-proxy/internal/health"
 )
 
+// Fallback policies applied when no quota item matches the requested model.
+const (
+	FallbackWeighted = "weighted" // weighted roll across every item in the plan
+	FallbackReject   = "reject"   // fail with ErrModelNotAvailable
+	FallbackDefault  = "default"  // route to the configured default model
+)
+
+// ErrModelNotAvailable is returned when the requested model is not part of the
+// subscription and the fallback policy does not allow routing elsewhere.
+var ErrModelNotAvailable = errors.New("requested model is not available on this plan")
+
+// ErrNoRouteLeft is returned when every quota item of the plan has been excluded.
+var ErrNoRouteLeft = errors.New("no quota items left to route to")
+
 // RoutingResult contains the routed model, target upstream details, and quota item ID for logging
 type RoutingResult struct {
 	Model        string
@@ -20,77 +38,311 @@ type RoutingResult struct {
 	RPM          *int
 	TPM          *int
 	RPD          *int
+
+	// Pinned is true when the request followed an existing session-affinity pin.
+	Pinned bool
+
+	// acquired is true when the router claimed a breaker slot for the item;
+	// Release hands it back if the request is not sent after all.
+	acquired bool
+
+	// ReservedTokens is the TPM estimate reserved when the request was admitted;
+	// it is reconciled with actual usage once the upstream call finishes, in
+	// the minute it was reserved at (ReservedAt).
+	ReservedTokens int
+	ReservedAt     time.Time
 }
 
 // Router handles DB-driven weighted model routing
 type Router struct {
-	db   *database.DB
-	rand *rand.Rand
+	source       QuotaItemSource
+	health       *health.Tracker // optional; nil routes without breaker checks
+	affinity     *affinityTable  // optional; nil disables session affinity
+	fallback     string
+	defaultModel string
+
+	randMu sync.Mutex
+	rand   *rand.Rand
 }
 
-// NewRouter creates a new smart router backed by the database
-func NewRouter(db *database.DB) *Router {
+// NewRouter creates a new smart router reading quota items from source
+// (the database, usually through a RoutingCache).
+// fallback is one of the Fallback* policies; defaultModel is only used by FallbackDefault.
+// When tracker is non-nil, quota items with an open circuit breaker are left out of the draw.
+// A positive affinityTTL pins conversations to the quota item that first served them.
+func NewRouter(source QuotaItemSource, tracker *health.Tracker, affinityTTL time.Duration, fallback, defaultModel string) *Router {
+	if fallback == "" {
+		fallback = FallbackWeighted
+	}
 	src := rand.NewSource(time.Now().UnixNano())
-	return &Router{
-		db:   db,
-		rand: rand.New(src),
+	r := &Router{
+		source:       source,
+		health:       tracker,
+		fallback:     fallback,
+		defaultModel: defaultModel,
+		rand:         rand.New(src),
 	}
+	if affinityTTL > 0 {
+		r.affinity = newAffinityTable(affinityTTL)
+	}
+	return r
 }
 
-// RouteModel selects a model/upstream for the given subscription using weighted random selection.
-// Returns the routing result (model, upstream details, quota_item_id).
-func (r *Router) RouteModel(subID int64, fallbackModel string) (RoutingResult, error) {
-	items, err := r.db.GetQuotaItemsBySubID(subID)
+// RouteRequest describes a single routing decision.
+type RouteRequest struct {
+	SubID int64
+	Model string
+
+	// Exclude holds quota item IDs that must not be picked, e.g. items that
+	// already failed for this request.
+	Exclude map[int64]bool
+
+	// SessionKey identifies the conversation (see conversationKey). Requests
+	// with the same key stick to the same quota item while it stays usable.
+	SessionKey string
+
+	// Profile describes the request's size and features; models that cannot
+	// serve it are left out of the draw.
+	Profile RequestProfile
+}
+
+// RouteModel selects a model/upstream for the given subscription.
+// Quota items whose model name or alias matches requestedModel are preferred; the
+// weighted roll then runs only among those. When nothing matches, the router's
+// fallback policy decides what happens.
+func (r *Router) RouteModel(subID int64, requestedModel string) (RoutingResult, error) {
+	return r.Route(RouteRequest{SubID: subID, Model: requestedModel})
+}
+
+// Route selects a model/upstream for req. See RouteModel.
+func (r *Router) Route(req RouteRequest) (RoutingResult, error) {
+	items, err := r.source.GetQuotaItemsBySubID(req.SubID)
 	if err != nil {
 		return RoutingResult{}, fmt.Errorf("route model: %w", err)
 	}
 
 	if len(items) == 0 {
-		return RoutingResult{}, fmt.Errorf("no quota items configured for sub_id=%d", subID)
+		return RoutingResult{}, fmt.Errorf("no quota items configured for sub_id=%d", req.SubID)
+	}
+
+	if len(req.Exclude) > 0 {
+		var remaining []database.QuotaItem
+		for _, item := range items {
+			if !req.Exclude[item.QuotaID] {
+				remaining = append(remaining, item)
+			}
+		}
+		if len(remaining) == 0 {
+			return RoutingResult{}, fmt.Errorf("sub_id=%d: %w", req.SubID, ErrNoRouteLeft)
+		}
+		items = remaining
+	}
+
+	candidates, err := r.candidatesFor(items, req.Model)
+	if err != nil {
+		return RoutingResult{}, fmt.Errorf("sub_id=%d model=%q: %w", req.SubID, req.Model, err)
+	}
+	candidates, err = filterCapable(candidates, req.Profile)
+	if err != nil {
+		return RoutingResult{}, fmt.Errorf("sub_id=%d model=%q: %w", req.SubID, req.Model, err)
+	}
+
+	pinKey := r.pinKey(req)
+	if item, ok := r.pinned(pinKey, candidates); ok {
+		result := routingResultFromItem(item)
+		result.Pinned = true
+		result.acquired = r.health != nil
+		return result, nil
+	}
+
+	item, acquired, err := r.pickHealthy(candidates)
+	if err != nil {
+		return RoutingResult{}, fmt.Errorf("sub_id=%d: %w", req.SubID, err)
+	}
+	if pinKey != "" {
+		r.affinity.pin(pinKey, item.QuotaID)
+	}
+	result := routingResultFromItem(item)
+	result.acquired = acquired
+	return result, nil
+}
+
+// Release hands back the breaker slot taken for routing when the request is
+// not sent to it, e.g. because its model is rate-limited and another item is
+// used instead. Otherwise a half-open breaker's trial slot would stay taken
+// until TrialTimeout.
+func (r *Router) Release(routing RoutingResult) {
+	if r.health != nil && routing.acquired {
+		r.health.Release(routing.ProviderID, routing.LLMModelID)
+	}
+}
+
+// Unpin releases the session-affinity pin of a conversation, e.g. because its
+// quota item is rate-limited. The next request is routed afresh and re-pinned.
+func (r *Router) Unpin(subID int64, sessionKey string) {
+	if key := r.pinKey(RouteRequest{SubID: subID, SessionKey: sessionKey}); key != "" {
+		r.affinity.release(key)
+	}
+}
+
+func (r *Router) pinKey(req RouteRequest) string {
+	if r.affinity == nil || req.SessionKey == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d|%s", req.SubID, req.SessionKey)
+}
+
+// pinned returns the conversation's pinned quota item if it is still among the
+// candidates and healthy. A pin that can no longer be honoured is released.
+func (r *Router) pinned(key string, candidates []database.QuotaItem) (database.QuotaItem, bool) {
+	if key == "" {
+		return database.QuotaItem{}, false
+	}
+	quotaID, ok := r.affinity.lookup(key)
+	if !ok {
+		return database.QuotaItem{}, false
+	}
+	for _, item := range candidates {
+		if item.QuotaID != quotaID {
+			continue
+		}
+		if r.health != nil && !r.health.Acquire(item.ProviderID, item.LLMModelID) {
+			break
+		}
+		r.affinity.pin(key, quotaID) // slide the TTL forward
+		return item, true
+	}
+	r.affinity.release(key)
+	return database.QuotaItem{}, false
+}
+
+// pickHealthy drops items whose provider or model breaker is open, so their
+// weight is spread across the healthy ones, then draws among the rest. Half-open
+// items are only returned if they can take a trial request. If every candidate
+// is unhealthy the draw falls back to all of them rather than failing outright.
+// It reports whether a breaker slot was acquired for the returned item.
+func (r *Router) pickHealthy(candidates []database.QuotaItem) (database.QuotaItem, bool, error) {
+	if r.health == nil {
+		item, err := r.pickWeighted(candidates)
+		return item, false, err
+	}
+
+	var healthy []database.QuotaItem
+	for _, item := range candidates {
+		if r.health.Available(item.ProviderID, item.LLMModelID) {
+			healthy = append(healthy, item)
+		}
+	}
+
+	for len(healthy) > 0 {
+		item, err := r.pickWeighted(healthy)
+		if err != nil {
+			break
+		}
+		if r.health.Acquire(item.ProviderID, item.LLMModelID) {
+			return item, true, nil
+		}
+		healthy = removeQuotaItem(healthy, item.QuotaID)
+	}
+
+	item, err := r.pickWeighted(candidates)
+	return item, false, err
+}
+
+func removeQuotaItem(items []database.QuotaItem, quotaID int64) []database.QuotaItem {
+	out := make([]database.QuotaItem, 0, len(items))
+	for _, item := range items {
+		if item.QuotaID != quotaID {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// candidatesFor narrows the plan's quota items to the ones that may serve requestedModel.
+func (r *Router) candidatesFor(items []database.QuotaItem, requestedModel string) ([]database.QuotaItem, error) {
+	if matched := matchModel(items, requestedModel); len(matched) > 0 {
+		return matched, nil
 	}
 
-	// Weighted random selection
+	switch r.fallback {
+	case FallbackReject:
+		return nil, ErrModelNotAvailable
+	case FallbackDefault:
+		if matched := matchModel(items, r.defaultModel); len(matched) > 0 {
+			return matched, nil
+		}
+		return nil, ErrModelNotAvailable
+	default:
+		return items, nil
+	}
+}
+
+// matchModel returns the items whose model_name equals model (case-insensitive).
+// If there is no exact match, items listing model among their aliases are returned.
+func matchModel(items []database.QuotaItem, model string) []database.QuotaItem {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		return nil
+	}
+
+	var exact, aliased []database.QuotaItem
+	for _, item := range items {
+		if strings.EqualFold(item.ModelName, model) {
+			exact = append(exact, item)
+			continue
+		}
+		for _, alias := range item.Aliases {
+			if strings.EqualFold(alias, model) {
+				aliased = append(aliased, item)
+				break
+			}
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return aliased
+}
+
+// pickWeighted performs a weighted random draw over items by percentage_weight.
+func (r *Router) pickWeighted(items []database.QuotaItem) (database.QuotaItem, error) {
 	totalWeight := 0
 	for _, item := range items {
 		totalWeight += item.PercentageWeight
 	}
 
 	if totalWeight <= 0 {
-		return RoutingResult{}, fmt.Errorf("total weight must be greater than 0 for sub_id=%d", subID)
+		return database.QuotaItem{}, fmt.Errorf("total weight must be greater than 0")
 	}
 
+	r.randMu.Lock()
 	roll := r.rand.Intn(totalWeight)
+	r.randMu.Unlock()
+
 	cumulative := 0
 	for _, item := range items {
 		cumulative += item.PercentageWeight
 		if roll < cumulative {
-			return RoutingResult{
-				Model:        item.ModelName,
-				BaseURL:      item.BaseURL,
-				APIKey:       item.APIKey,
-				ProviderType: item.ProviderType,
-				QuotaItemID:  item.QuotaID,
-				ProviderID:   item.ProviderID,
-				LLMModelID:   item.LLMModelID,
-				RPM:          item.RPM,
-				TPM:          item.TPM,
-				RPD:          item.RPD,
-			}, nil
+			return item, nil
 		}
 	}
 
 	// Fallback
-	last := items[len(items)-1]
+	return items[len(items)-1], nil
+}
+
+func routingResultFromItem(item database.QuotaItem) RoutingResult {
 	return RoutingResult{
-		Model:        last.ModelName,
-		BaseURL:      last.BaseURL,
-		APIKey:       last.APIKey,
-		ProviderType: last.ProviderType,
-		QuotaItemID:  last.QuotaID,
-		ProviderID:   last.ProviderID,
-		LLMModelID:   last.LLMModelID,
-		RPM:          last.RPM,
-		TPM:          last.TPM,
-		RPD:          last.RPD,
-	}, nil
+		Model:        item.ModelName,
+		BaseURL:      item.BaseURL,
+		APIKey:       item.APIKey,
+		ProviderType: item.ProviderType,
+		QuotaItemID:  item.QuotaID,
+		ProviderID:   item.ProviderID,
+		LLMModelID:   item.LLMModelID,
+		RPM:          item.RPM,
+		TPM:          item.TPM,
+		RPD:          item.RPD,
+	}
 }
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+func testQuotaItems() []database.QuotaItem {
+	return []database.QuotaItem{
+		{QuotaID: 1, ModelName: "gpt-4o", PercentageWeight: 50},
+		{QuotaID: 2, ModelName: "gemini-2.5-flash", Aliases: []string{"flash"}, PercentageWeight: 30},
+		{QuotaID: 3, ModelName: "GPT-4o", PercentageWeight: 20},
+	}
+}
+
+func quotaIDs(items []database.QuotaItem) []int64 {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.QuotaID
+	}
+	return ids
+}
+
+func TestMatchModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  []int64
+	}{
+		{"gpt-4o", []int64{1, 3}},
+		{"flash", []int64{2}},
+		{"Gemini-2.5-Flash", []int64{2}},
+		{"claude-sonnet-4", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got := quotaIDs(matchModel(testQuotaItems(), tt.model))
+			if len(got) != len(tt.want) {
+				t.Fatalf("matchModel(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("matchModel(%q) = %v, want %v", tt.model, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCandidatesForFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		fallback     string
+		defaultModel string
+		want         int
+		wantErr      error
+	}{
+		{"weighted", FallbackWeighted, "", 3, nil},
+		{"reject", FallbackReject, "", 0, ErrModelNotAvailable},
+		{"default", FallbackDefault, "flash", 1, nil},
+		{"default missing", FallbackDefault, "o3", 0, ErrModelNotAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter(nil, nil, 0, tt.fallback, tt.defaultModel)
+			got, err := r.candidatesFor(testQuotaItems(), "claude-sonnet-4")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("len(candidates) = %d, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestPickWeightedStaysWithinCandidates(t *testing.T) {
+	r := NewRouter(nil, nil, 0, FallbackWeighted, "")
+	candidates := matchModel(testQuotaItems(), "gpt-4o")
+	for i := 0; i < 200; i++ {
+		item, err := r.pickWeighted(candidates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.QuotaID == 2 {
+			t.Fatalf("picked non-matching quota item %d", item.QuotaID)
+		}
+	}
+}
+
+func TestRouteSessionAffinity(t *testing.T) {
+	src := &fakeQuotaSource{items: []database.QuotaItem{
+		{QuotaID: 1, ModelName: "gpt-4o", PercentageWeight: 50},
+		{QuotaID: 2, ModelName: "gpt-4o", PercentageWeight: 50},
+	}}
+	r := NewRouter(src, nil, time.Hour, FallbackWeighted, "")
+	req := RouteRequest{SubID: 7, Model: "gpt-4o", SessionKey: "session:abc"}
+
+	first, err := r.Route(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pinned {
+		t.Fatal("first request should not be pinned")
+	}
+	for i := 0; i < 20; i++ {
+		got, err := r.Route(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Pinned || got.QuotaItemID != first.QuotaItemID {
+			t.Fatalf("turn %d routed to %d (pinned=%v), want pinned %d", i, got.QuotaItemID, got.Pinned, first.QuotaItemID)
+		}
+	}
+
+	// Excluding the pinned item (failover) moves the pin.
+	req.Exclude = map[int64]bool{first.QuotaItemID: true}
+	moved, err := r.Route(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Pinned || moved.QuotaItemID == first.QuotaItemID {
+		t.Fatalf("expected re-route away from %d, got %d (pinned=%v)", first.QuotaItemID, moved.QuotaItemID, moved.Pinned)
+	}
+	req.Exclude = nil
+	if got, _ := r.Route(req); !got.Pinned || got.QuotaItemID != moved.QuotaItemID {
+		t.Fatalf("pin did not move: got %d, want %d", got.QuotaItemID, moved.QuotaItemID)
+	}
+
+	r.Unpin(7, "session:abc")
+	if got, _ := r.Route(req); got.Pinned {
+		t.Fatal("request after Unpin should not be pinned")
+	}
+}
+
+func TestConversationKey(t *testing.T) {
+	turn1 := []byte(`{"system":"be brief","messages":[{"role":"user","content":"hi"}]}`)
+	turn2 := []byte(`{"system":"be brief","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"more"}]}`)
+	other := []byte(`{"system":"be brief","messages":[{"role":"user","content":"bye"}]}`)
+
+	plain := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	if k1, k2 := conversationKey(plain, turn1), conversationKey(plain, turn2); k1 == "" || k1 != k2 {
+		t.Fatalf("turns of one conversation got keys %q and %q", k1, k2)
+	}
+	if conversationKey(plain, turn1) == conversationKey(plain, other) {
+		t.Fatal("different conversations share a key")
+	}
+	if got := conversationKey(plain, []byte(`{"metadata":{"user_id":"u1"},"messages":[]}`)); got != "user:u1" {
+		t.Fatalf("metadata.user_id key = %q", got)
+	}
+
+	withHeader := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	withHeader.Header.Set(sessionHeader, "s1")
+	if got := conversationKey(withHeader, turn1); got != "session:s1" {
+		t.Fatalf("header key = %q", got)
+	}
+}
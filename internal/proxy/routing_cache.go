@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+// maxStaleRouting bounds how long a cached routing table may be served while the
+// database is unreachable.
+const maxStaleRouting = time.Hour
+
+// QuotaItemSource loads the weighted quota items of a subscription.
+// Both *database.DB and *RoutingCache satisfy it.
+type QuotaItemSource interface {
+	GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error)
+}
+
+// RoutingSource is all the routing data the proxy reads: quota items, pooled
+// provider accounts, and where usage is recorded. *database.DB (Postgres) and
+// *config.StaticConfigLoader (a static config file that declares providers)
+// implement it.
+type RoutingSource interface {
+	QuotaItemSource
+	GetAccountsForProvider(providerID uint) ([]database.ProviderAccount, error)
+	LogUsage(ctx database.UsageContext, inputTokens, outputTokens int) error
+}
+
+// RoutingCache keeps each subscription's quota items in memory so routing does
+// not hit Postgres on every request. Entries expire after ttl and are
+// invalidated immediately when the routing triggers NOTIFY a change. If a
+// refresh fails, the last known table is served for up to maxStaleRouting.
+type RoutingCache struct {
+	source QuotaItemSource
+	ttl    time.Duration
+	logger *log.Logger
+
+	mu      sync.RWMutex
+	entries map[int64]*routingEntry
+	gen     uint64 // bumped on every invalidation, so a reload racing a NOTIFY is not marked fresh
+}
+
+type routingEntry struct {
+	items     []database.QuotaItem
+	fetchedAt time.Time
+	fresh     bool // cleared by invalidation; the items stay around as a stale fallback
+}
+
+// NewRoutingCache wraps source with a TTL cache keyed by sub_id.
+func NewRoutingCache(source QuotaItemSource, ttl time.Duration, logger *log.Logger) *RoutingCache {
+	return &RoutingCache{
+		source:  source,
+		ttl:     ttl,
+		logger:  logger,
+		entries: make(map[int64]*routingEntry),
+	}
+}
+
+// GetQuotaItemsBySubID returns the cached routing table for subID, reloading it
+// when it has expired or been invalidated.
+func (c *RoutingCache) GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error) {
+	c.mu.RLock()
+	var cached routingEntry
+	entry, ok := c.entries[subID]
+	if ok {
+		cached = *entry
+	}
+	gen := c.gen
+	c.mu.RUnlock()
+
+	if ok && cached.fresh && time.Since(cached.fetchedAt) < c.ttl {
+		return cached.items, nil
+	}
+
+	items, err := c.source.GetQuotaItemsBySubID(subID)
+	if err != nil {
+		if ok && time.Since(cached.fetchedAt) < maxStaleRouting {
+			c.logger.Printf("WARN [routing_cache] sub_id=%d serving stale routing (age=%s): %v", subID, time.Since(cached.fetchedAt).Round(time.Second), err)
+			return cached.items, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[subID] = &routingEntry{items: items, fetchedAt: time.Now(), fresh: gen == c.gen}
+	c.mu.Unlock()
+	return items, nil
+}
+
+// Invalidate marks one subscription's routing table as needing a reload.
+func (c *RoutingCache) Invalidate(subID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if entry, ok := c.entries[subID]; ok {
+		entry.fresh = false
+	}
+}
+
+// InvalidateAll marks every cached routing table as needing a reload.
+func (c *RoutingCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, entry := range c.entries {
+		entry.fresh = false
+	}
+}
+
+// HandleNotify applies a routing change notification (a sub_id or NotifyAll).
+func (c *RoutingCache) HandleNotify(payload string) {
+	if payload == database.NotifyAll || payload == "" {
+		c.InvalidateAll()
+		return
+	}
+	subID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		c.logger.Printf("WARN [routing_cache] unexpected notify payload %q, invalidating all", payload)
+		c.InvalidateAll()
+		return
+	}
+	c.Invalidate(subID)
+}
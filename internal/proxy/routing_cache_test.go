@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/rpay/apipod-smart-proxy/internal/database"
+)
+
+type fakeQuotaSource struct {
+	calls int
+	items []database.QuotaItem
+	err   error
+}
+
+func (f *fakeQuotaSource) GetQuotaItemsBySubID(subID int64) ([]database.QuotaItem, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.items, nil
+}
+
+func TestRoutingCacheInvalidation(t *testing.T) {
+	src := &fakeQuotaSource{items: []database.QuotaItem{{QuotaID: 1, SubID: 7}}}
+	cache := NewRoutingCache(src, time.Hour, log.New(io.Discard, "", 0))
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetQuotaItemsBySubID(7); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.calls != 1 {
+		t.Fatalf("source calls = %d, want 1 (cached)", src.calls)
+	}
+
+	cache.HandleNotify("8") // different subscription
+	cache.GetQuotaItemsBySubID(7)
+	if src.calls != 1 {
+		t.Fatalf("source calls = %d, want 1 after unrelated notify", src.calls)
+	}
+
+	cache.HandleNotify("7")
+	cache.GetQuotaItemsBySubID(7)
+	if src.calls != 2 {
+		t.Fatalf("source calls = %d, want 2 after notify", src.calls)
+	}
+
+	cache.HandleNotify(database.NotifyAll)
+	cache.GetQuotaItemsBySubID(7)
+	if src.calls != 3 {
+		t.Fatalf("source calls = %d, want 3 after notify all", src.calls)
+	}
+}
+
+func TestRoutingCacheServesStaleOnError(t *testing.T) {
+	src := &fakeQuotaSource{items: []database.QuotaItem{{QuotaID: 1, SubID: 7}}}
+	cache := NewRoutingCache(src, time.Hour, log.New(io.Discard, "", 0))
+	cache.GetQuotaItemsBySubID(7)
+
+	cache.Invalidate(7)
+	src.err = errors.New("connection refused")
+
+	items, err := cache.GetQuotaItemsBySubID(7)
+	if err != nil {
+		t.Fatalf("expected stale entry, got error %v", err)
+	}
+	if len(items) != 1 || items[0].QuotaID != 1 {
+		t.Fatalf("items = %+v", items)
+	}
+
+	if _, err := cache.GetQuotaItemsBySubID(9); err == nil {
+		t.Fatal("expected error for a subscription that was never cached")
+	}
+}
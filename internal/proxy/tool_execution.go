@@ -3,13 +3,13 @@ package proxy
 import (
 	"encoding/json"
 	"io"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/rpay/apipod-smart-proxy/internal/config"
 	"github.com/rpay/apipod-smart-proxy/internal/tools"
 	"github.com/rpay/apipod-smart-proxy/internal/upstream/anthropiccompat"
-	"github.com/rpay/apipod-smart-proxy/internal/upstream/openaicompat"
 )
 
 // AnthropicResponse represents a response from the Anthropic API
@@ -221,9 +221,9 @@ type openAIChatResponse struct {
 }
 
 // handleToolExecutionOpenAI intercepts OpenAI-format responses with tool_calls,
-// executes them locally, and sends a follow-up request through the OpenAI-compat endpoint.
+// executes them locally, and sends a follow-up request through the OpenAI-compat endpoint with send.
 // Returns the final Anthropic-format response bytes, input/output tokens, hasToolCall, and error.
-func (h *Handler) handleToolExecutionOpenAI(openaiRespBytes []byte, routing RoutingResult, openaiRequestBytes []byte, model string, path string) ([]byte, int, int, bool, error) {
+func (h *Handler) handleToolExecutionOpenAI(openaiRespBytes []byte, routing RoutingResult, openaiRequestBytes []byte, model string, send continuationSender) ([]byte, int, int, bool, error) {
 	const maxToolRounds = 15
 	const maxThinkingRetries = 2
 	const thinkingThreshold = 500 // chars of text that looks like reasoning about tools
@@ -294,7 +294,7 @@ func (h *Handler) handleToolExecutionOpenAI(openaiRespBytes []byte, routing Rout
 				apiKey := h.resolveAPIKey(routing)
 				timeouts := config.GetModelTimeouts(routing.Model)
 
-				followupRespBytes, err := h.executeToolContinuationOpenAIWithRetry(routing.BaseURL, apiKey, path, followupBytes, timeouts, model)
+				followupRespBytes, err := h.executeToolContinuationOpenAIWithRetry(send, apiKey, followupBytes, timeouts, model)
 				if err != nil {
 					h.runnerLogger.Printf("[tool_execution] thinking nudge failed: %v", err)
 					break
@@ -372,7 +372,7 @@ func (h *Handler) handleToolExecutionOpenAI(openaiRespBytes []byte, routing Rout
 		apiKey := h.resolveAPIKey(routing)
 		timeouts := config.GetModelTimeouts(routing.Model)
 
-		followupRespBytes, err := h.executeToolContinuationOpenAIWithRetry(routing.BaseURL, apiKey, path, followupBytes, timeouts, routing.Model)
+		followupRespBytes, err := h.executeToolContinuationOpenAIWithRetry(send, apiKey, followupBytes, timeouts, routing.Model)
 		if err != nil {
 			h.runnerLogger.Printf("[tool_execution] follow-up request failed at round %d: %v", round+1, err)
 			break
@@ -393,8 +393,12 @@ func (h *Handler) handleToolExecutionOpenAI(openaiRespBytes []byte, routing Rout
 	return anthropicResp, totalInputTokens, totalOutputTokens, hasToolCall || tc, nil
 }
 
+// continuationSender posts an OpenAI-format follow-up request to the provider
+// the tool call came from.
+type continuationSender func(apiKey string, body []byte) (*http.Response, error)
+
 // executeToolContinuationOpenAIWithRetry sends a follow-up request to an OpenAI-compat endpoint with retry logic.
-func (h *Handler) executeToolContinuationOpenAIWithRetry(baseURL, apiKey, path string, requestBytes []byte, timeouts config.ModelTimeouts, model string) ([]byte, error) {
+func (h *Handler) executeToolContinuationOpenAIWithRetry(send continuationSender, apiKey string, requestBytes []byte, timeouts config.ModelTimeouts, model string) ([]byte, error) {
 	var lastErr error
 
 	for attempt := 0; attempt <= timeouts.MaxRetries; attempt++ {
@@ -404,7 +408,7 @@ func (h *Handler) executeToolContinuationOpenAIWithRetry(baseURL, apiKey, path s
 			time.Sleep(delay)
 		}
 
-		resp, err := openaicompat.Proxy(baseURL, apiKey, path, requestBytes)
+		resp, err := send(apiKey, requestBytes)
 		if err != nil {
 			lastErr = err
 			h.runnerLogger.Printf("[tool_execution] attempt %d failed for model=%s: %v", attempt+1, model, err)
@@ -0,0 +1,221 @@
+// Package secrets encrypts upstream credentials at rest with envelope
+// encryption: each value is sealed with its own random data key, which is in
+// turn sealed with a master key. Sealed values look like
+//
+//	enc:v1:<key id>:<sealed data key>:<sealed value>
+//
+// so the master key that protects them can be identified and rotated. Values
+// without the enc: prefix are treated as plaintext, which lets existing rows be
+// migrated gradually.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+// ErrNoKeyring is returned when decrypting a sealed value without master keys.
+var ErrNoKeyring = errors.New("value is encrypted but no master key is configured")
+
+var b64 = base64.RawURLEncoding
+
+// Keyring holds the master keys by ID. The primary key seals new values; the
+// others only open values sealed before a rotation. A nil *Keyring passes
+// plaintext through and cannot open sealed values.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeys parses "id:base64key[,id:base64key...]" (commas or newlines).
+// Keys must be 32 bytes (AES-256). The first key is the primary.
+func ParseKeys(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid master key entry %q (expected id:base64key)", maskEntry(entry))
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 bytes, base64-encoded", id)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate master key id %q", id)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	if k.primary == "" {
+		return nil, errors.New("no master keys given")
+	}
+	return k, nil
+}
+
+// Load reads master keys from spec, or from file when spec is empty. It returns
+// nil (plaintext only) when neither is set.
+func Load(spec, file string) (*Keyring, error) {
+	if spec == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		spec = string(data)
+	}
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseKeys(spec)
+}
+
+// GenerateKey returns a new random master key, base64-encoded.
+func GenerateKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// PrimaryID returns the ID of the key new values are sealed with.
+func (k *Keyring) PrimaryID() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// IsEncrypted reports whether value is a sealed value.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals plaintext with the primary key. Empty values stay empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+	if k == nil {
+		return "", errors.New("no master key configured")
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	valueAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(valueAEAD, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary + ":" + b64.EncodeToString(sealedKey) + ":" + b64.EncodeToString(sealedValue), nil
+}
+
+// Decrypt opens a sealed value; plaintext values are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	master, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with unknown master key %q", parts[0])
+	}
+	sealedKey, err1 := b64.DecodeString(parts[1])
+	sealedValue, err2 := b64.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	dataKey, err := open(master, sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key with master key %q", parts[0])
+	}
+	valueAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(valueAEAD, sealedValue)
+	if err != nil {
+		return "", errors.New("failed to decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRekey reports whether value should be (re-)encrypted: it is plaintext,
+// or sealed with a key other than the primary.
+func (k *Keyring) NeedsRekey(value string) bool {
+	if value == "" || k == nil {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id != k.primary
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// maskEntry hides the key material of a malformed entry in error messages.
+func maskEntry(entry string) string {
+	if len(entry) <= 4 {
+		return "***"
+	}
+	return entry[:4] + "***"
+}
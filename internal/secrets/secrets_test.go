@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	var entries []string
+	for _, id := range ids {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, id+":"+key)
+	}
+	k, err := ParseKeys(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k := testKeyring(t, "k1")
+
+	sealed, err := k.Encrypt("sk-live-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "enc:v1:k1:") || strings.Contains(sealed, "sk-live") {
+		t.Fatalf("unexpected sealed value %q", sealed)
+	}
+	if again, _ := k.Encrypt("sk-live-123"); again == sealed {
+		t.Fatal("sealing the same value twice should differ")
+	}
+	if got, err := k.Decrypt(sealed); err != nil || got != "sk-live-123" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if got, err := k.Decrypt("plain-key"); err != nil || got != "plain-key" {
+		t.Fatalf("plaintext should pass through, got %q, %v", got, err)
+	}
+}
+
+func TestTamperedValueIsRejected(t *testing.T) {
+	k := testKeyring(t, "k1")
+	sealed, _ := k.Encrypt("sk-live-123")
+
+	i := len(sealed) - 10 // inside the sealed value, clear of trailing bits
+	flipped := byte('A')
+	if sealed[i] == 'A' {
+		flipped = 'B'
+	}
+	tampered := sealed[:i] + string(flipped) + sealed[i+1:]
+	if _, err := k.Decrypt(tampered); err == nil {
+		t.Fatal("tampered value decrypted")
+	}
+	if _, err := testKeyring(t, "k1").Decrypt(sealed); err == nil {
+		t.Fatal("value opened with a different key of the same id")
+	}
+	var none *Keyring
+	if _, err := none.Decrypt(sealed); err != ErrNoKeyring {
+		t.Fatalf("got %v, want ErrNoKeyring", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	k1, _ := GenerateKey()
+	k2, _ := GenerateKey()
+	old, _ := ParseKeys("k1:" + k1)
+	sealed, _ := old.Encrypt("sk-live-123")
+
+	// k2 becomes primary, k1 stays available to open existing values.
+	rotated, err := ParseKeys("k2:" + k2 + "\nk1:" + k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.NeedsRekey(sealed) || !rotated.NeedsRekey("plaintext") || rotated.NeedsRekey("") {
+		t.Fatal("values under k1 and plaintext need re-keying, empty values do not")
+	}
+	plain, err := rotated.Decrypt(sealed)
+	if err != nil || plain != "sk-live-123" {
+		t.Fatalf("got %q, %v", plain, err)
+	}
+	resealed, _ := rotated.Encrypt(plain)
+	if !strings.HasPrefix(resealed, "enc:v1:k2:") || rotated.NeedsRekey(resealed) {
+		t.Fatalf("re-keyed value %q should use k2", resealed)
+	}
+}
+
+func TestParseKeysRejectsBadInput(t *testing.T) {
+	for _, spec := range []string{"", "nokey", "k1:not-base64!", "k1:c2hvcnQ=", "k1:" + strings.Repeat("A", 44) + ",k1:" + strings.Repeat("A", 44)} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q) should fail", spec)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rpay/apipod-smart-proxy/internal/config"
 	"github.com/rpay/apipod-smart-proxy/internal/orchestrator"
 )
 
@@ -707,6 +708,8 @@ func OpenAIResponseToAnthropic(body []byte, model string) ([]byte, int, int, boo
 		case "tool_calls":
 			stopReason = "tool_use"
 			hasToolCall = true
+		case "content_filter":
+			stopReason = "refusal"
 		}
 
 		if choice.Message.ReasoningContent != nil && *choice.Message.ReasoningContent != "" {
@@ -836,6 +839,11 @@ func OpenAIStreamToAnthropicStream(r io.Reader, w io.Writer, model string) (int,
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			continue
 		}
+		// Skip chunks with neither choices nor usage, e.g. Azure OpenAI's
+		// prompt_filter_results chunk, which has no id for message_start.
+		if len(chunk.Choices) == 0 && chunk.Usage == nil {
+			continue
+		}
 
 		if chunk.Usage != nil {
 			inputTokens = chunk.Usage.PromptTokens
@@ -996,6 +1004,8 @@ func OpenAIStreamToAnthropicStream(r io.Reader, w io.Writer, model string) (int,
 				stopReason = "max_tokens"
 			case "tool_calls":
 				stopReason = "tool_use"
+			case "content_filter":
+				stopReason = "refusal"
 			}
 
 			writeSSE(w, map[string]interface{}{
@@ -1022,6 +1032,12 @@ func ProxyDirect(baseURL string, apiKey string, body []byte) (*http.Response, er
 }
 
 func ProxyDirectWithTimeout(baseURL string, apiKey string, body []byte, timeout time.Duration) (*http.Response, error) {
+	return ProxyDirectWithHeaders(baseURL, apiKey, body, nil, timeout)
+}
+
+// ProxyDirectWithHeaders is ProxyDirectWithTimeout with extra request headers,
+// e.g. anthropic-beta. An anthropic-version in header replaces the default.
+func ProxyDirectWithHeaders(baseURL string, apiKey string, body []byte, header http.Header, timeout time.Duration) (*http.Response, error) {
 	apiURL := strings.TrimRight(baseURL, "/") + "/v1/messages"
 
 	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
@@ -1031,6 +1047,9 @@ func ProxyDirectWithTimeout(baseURL string, apiKey string, body []byte, timeout
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 
 	client := &http.Client{
 		Transport: transport,
@@ -1261,41 +1280,9 @@ func StripThinking(body []byte) []byte {
 }
 
 func getMaxTokensForModel(model string, requestedTokens int) int {
-	modelLimits := map[string]int{
-		"gpt-3.5-turbo":     4096,
-		"gpt-4":             8192,
-		"gpt-4-turbo":       16384,
-		"gpt-4o":            16384,
-		"gpt-4o-mini":       16384,
-		"claude-3-haiku":    4096,
-		"claude-3-sonnet":   4096,
-		"claude-3-opus":     4096,
-		"claude-3.5-sonnet": 8192,
-		"claude-sonnet-4":   16384,
-		"claude-sonnet-4.5": 16384,
-		"claude-sonnet-4-5": 16384,
-		"claude-opus-4":     32768,
-		"claude-opus-4-6":   32768,
-		"llama3-8b-8192":    8192,
-		"llama3-70b-8192":   8192,
-		"mixtral-8x7b-32768":              32768,
-		"gemma-7b-it":                     8192,
-		"moonshotai/kimi-k2-instruct-0905": 16384,
-		"moonshot-v1-8k":                  8192,
-		"moonshot-v1-32k":                 32768,
-		"moonshot-v1-128k":                128000,
-		"deepseek-chat":                   8192,
-		"deepseek-reasoner":               64000,
-	}
-
-	defaultLimit := 8192
+	modelLimit := config.LookupModel(model).MaxOutputTokens
 	maxSafeLimit := 128000
 
-	modelLimit, exists := modelLimits[model]
-	if !exists {
-		modelLimit = defaultLimit
-	}
-
 	if requestedTokens <= 0 {
 		return modelLimit
 	}
@@ -0,0 +1,111 @@
+package anthropiccompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Usage is the token usage of a Messages response. Prompt-cache writes and
+// reads are reported apart from input_tokens, which only counts the uncached
+// part of the prompt.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// merge takes the non-zero counts of v. Stream events repeat counts
+// cumulatively, so later ones win.
+func (u *Usage) merge(v Usage) {
+	if v.InputTokens > 0 {
+		u.InputTokens = v.InputTokens
+	}
+	if v.OutputTokens > 0 {
+		u.OutputTokens = v.OutputTokens
+	}
+	if v.CacheCreationInputTokens > 0 {
+		u.CacheCreationInputTokens = v.CacheCreationInputTokens
+	}
+	if v.CacheReadInputTokens > 0 {
+		u.CacheReadInputTokens = v.CacheReadInputTokens
+	}
+}
+
+// ExtractUsage returns the usage of a non-streaming Messages response.
+func ExtractUsage(body []byte) Usage {
+	var resp struct {
+		Usage Usage `json:"usage"`
+	}
+	json.Unmarshal(body, &resp)
+	return resp.Usage
+}
+
+// UsageReader passes a Messages SSE stream through unchanged while picking up
+// the usage of message_start and message_delta events, so it can sit in front
+// of any stream transform.
+type UsageReader struct {
+	r     io.Reader
+	line  []byte // unterminated tail of the last read
+	usage Usage
+}
+
+// NewUsageReader wraps an Anthropic SSE response body.
+func NewUsageReader(r io.Reader) *UsageReader {
+	return &UsageReader{r: r}
+}
+
+func (u *UsageReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.scan(p[:n])
+	if err == io.EOF {
+		u.scanLine(u.line)
+		u.line = nil
+	}
+	return n, err
+}
+
+// Usage returns the usage seen in the stream so far.
+func (u *UsageReader) Usage() Usage {
+	return u.usage
+}
+
+func (u *UsageReader) scan(b []byte) {
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			u.line = append(u.line, b...)
+			return
+		}
+		if len(u.line) > 0 {
+			u.scanLine(append(u.line, b[:i]...))
+			u.line = u.line[:0]
+		} else {
+			u.scanLine(b[:i])
+		}
+		b = b[i+1:]
+	}
+}
+
+func (u *UsageReader) scanLine(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok || !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Usage Usage `json:"usage"`
+		} `json:"message"` // message_start
+		Usage Usage `json:"usage"` // message_delta
+	}
+	if json.Unmarshal(bytes.TrimSpace(data), &event) != nil {
+		return
+	}
+	if event.Type == "message_start" {
+		u.usage.merge(event.Message.Usage)
+	} else {
+		u.usage.merge(event.Usage)
+	}
+}
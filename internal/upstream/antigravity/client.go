@@ -17,13 +17,20 @@ var transport = &http.Transport{
 	IdleConnTimeout:     120 * time.Second,
 }
 
-func ExchangeRefreshToken(refreshToken string) (string, error) {
-	return "internal-managed", nil
-}
-
 // ProxyToAntigravity converts an OpenAI chat completions request to Anthropic Messages format
 // (including tools, tool_calls, and tool results) and sends it to the upstream.
 func ProxyToAntigravity(baseURL string, apiKey string, model string, body []byte, stream bool) (*http.Response, error) {
+	converted, err := OpenAIToAnthropic(body, model, stream)
+	if err != nil {
+		// Fallback: send as-is
+		return proxyRaw(baseURL, apiKey, body)
+	}
+	return proxyRaw(baseURL, apiKey, converted)
+}
+
+// OpenAIToAnthropic converts an OpenAI chat completions request to an Anthropic
+// Messages request for model, including tools, tool_calls, and tool results.
+func OpenAIToAnthropic(body []byte, model string, stream bool) ([]byte, error) {
 	// Parse full OpenAI request
 	var openAIReq struct {
 		Messages    []json.RawMessage        `json:"messages"`
@@ -35,8 +42,7 @@ func ProxyToAntigravity(baseURL string, apiKey string, model string, body []byte
 		Stop        interface{}              `json:"stop,omitempty"`
 	}
 	if err := json.Unmarshal(body, &openAIReq); err != nil {
-		// Fallback: send as-is
-		return proxyRaw(baseURL, apiKey, body)
+		return nil, err
 	}
 
 	// Convert messages
@@ -211,8 +217,7 @@ func ProxyToAntigravity(baseURL string, apiKey string, model string, body []byte
 		}
 	}
 
-	finalBody, _ := json.Marshal(upstreamBody)
-	return proxyRaw(baseURL, apiKey, finalBody)
+	return json.Marshal(upstreamBody)
 }
 
 func proxyRaw(baseURL string, apiKey string, body []byte) (*http.Response, error) {
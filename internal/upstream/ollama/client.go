@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var transport = &http.Transport{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 20,
+	IdleConnTimeout:     120 * time.Second,
+}
+
+// Proxy sends an Ollama-format request to {baseURL}/api/chat. Local boxes
+// usually need no key; one is sent as a Bearer token for boxes behind an
+// authenticating reverse proxy.
+func Proxy(baseURL string, apiKey string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", strings.TrimRight(baseURL, "/")+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Minute} // CPU boxes are slow
+	return client.Do(req)
+}
+
+// Model is an installed model as listed by /api/tags.
+type Model struct {
+	Name    string `json:"name"` // e.g. "llama3.2:3b"
+	Size    int64  `json:"size"`
+	Details struct {
+		Family            string `json:"family"`
+		ParameterSize     string `json:"parameter_size"`
+		QuantizationLevel string `json:"quantization_level"`
+	} `json:"details"`
+}
+
+// ListModels returns the models installed on an Ollama server.
+func ListModels(baseURL string, apiKey string) ([]Model, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(baseURL, "/")+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /api/tags: status %d", resp.StatusCode)
+	}
+
+	var tags struct {
+		Models []Model `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("GET /api/tags: %w", err)
+	}
+	return tags.Models, nil
+}
@@ -0,0 +1,216 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// --- OpenAI request types (subset) ---
+
+type openAIRequest struct {
+	Model               string            `json:"model"`
+	Messages            []openAIMessage   `json:"messages"`
+	Stream              bool              `json:"stream,omitempty"`
+	Temperature         *float64          `json:"temperature,omitempty"`
+	TopP                *float64          `json:"top_p,omitempty"`
+	MaxTokens           *int              `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int              `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage   `json:"stop,omitempty"` // string or array
+	Seed                *int              `json:"seed,omitempty"`
+	PresencePenalty     *float64          `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64          `json:"frequency_penalty,omitempty"`
+	Tools               []json.RawMessage `json:"tools,omitempty"` // same shape in Ollama
+	ResponseFormat      *struct {
+		Type       string `json:"type"`
+		JSONSchema *struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema,omitempty"`
+	} `json:"response_format,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content"` // string, array of parts or null
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// --- Ollama types ---
+
+type chatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []message              `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Tools    []json.RawMessage      `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    []string   `json:"images,omitempty"` // base64, no data: prefix
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type toolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"` // an object, not a string
+	} `json:"function"`
+}
+
+// chatResponse is a /api/chat response, or one NDJSON line of a stream.
+type chatResponse struct {
+	Model           string  `json:"model"`
+	Message         message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	Error           string  `json:"error"`
+}
+
+// OpenAIToOllama converts an OpenAI Chat Completions request to an Ollama
+// /api/chat request. Returns the body and whether the client asked to stream.
+func OpenAIToOllama(body []byte) ([]byte, bool, error) {
+	var req openAIRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false, fmt.Errorf("invalid OpenAI request: %w", err)
+	}
+
+	out := chatRequest{
+		Model:  req.Model,
+		Stream: req.Stream,
+		Tools:  req.Tools,
+	}
+
+	// Ollama tool results carry the tool's name rather than a call ID.
+	toolNames := make(map[string]string)
+	for _, m := range req.Messages {
+		msg := message{Role: m.Role}
+		text, images := contentParts(m.Content)
+		msg.Content, msg.Images = text, images
+		for _, tc := range m.ToolCalls {
+			toolNames[tc.ID] = tc.Function.Name
+			var call toolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(call.Function.Arguments) {
+				call.Function.Arguments = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		if m.Role == "tool" {
+			msg.ToolName = toolNames[m.ToolCallID]
+		}
+		if m.Role == "developer" {
+			msg.Role = "system"
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+
+	opts := make(map[string]interface{})
+	if req.Temperature != nil {
+		opts["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		opts["top_p"] = *req.TopP
+	}
+	if req.MaxCompletionTokens != nil {
+		opts["num_predict"] = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		opts["num_predict"] = *req.MaxTokens
+	}
+	if stop := stopSequences(req.Stop); len(stop) > 0 {
+		opts["stop"] = stop
+	}
+	if req.Seed != nil {
+		opts["seed"] = *req.Seed
+	}
+	if req.PresencePenalty != nil {
+		opts["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		opts["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	if len(opts) > 0 {
+		out.Options = opts
+	}
+
+	if rf := req.ResponseFormat; rf != nil {
+		switch {
+		case rf.Type == "json_schema" && rf.JSONSchema != nil && len(rf.JSONSchema.Schema) > 0:
+			out.Format = rf.JSONSchema.Schema
+		case rf.Type == "json_object" || rf.Type == "json_schema":
+			out.Format = json.RawMessage(`"json"`)
+		}
+	}
+
+	result, err := json.Marshal(out)
+	return result, req.Stream, err
+}
+
+// contentParts flattens OpenAI message content into text and base64 images.
+// Remote image URLs cannot be passed to Ollama and are dropped.
+func contentParts(raw json.RawMessage) (string, []string) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if json.Unmarshal(raw, &parts) != nil {
+		return "", nil
+	}
+	var text []string
+	var images []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			text = append(text, p.Text)
+		case "image_url":
+			if i := strings.Index(p.ImageURL.URL, ";base64,"); strings.HasPrefix(p.ImageURL.URL, "data:") && i >= 0 {
+				images = append(images, p.ImageURL.URL[i+len(";base64,"):])
+			}
+		}
+	}
+	return strings.Join(text, "\n"), images
+}
+
+func stopSequences(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		if one == "" {
+			return nil
+		}
+		return []string{one}
+	}
+	var many []string
+	json.Unmarshal(raw, &many)
+	return many
+}
@@ -0,0 +1,184 @@
+package ollama
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExtractTokens extracts token usage from a non-streaming /api/chat response:
+// prompt_eval_count and eval_count.
+func ExtractTokens(body []byte) (int, int) {
+	var resp chatResponse
+	if json.Unmarshal(body, &resp) != nil {
+		return 0, 0
+	}
+	return resp.PromptEvalCount, resp.EvalCount
+}
+
+// OllamaToOpenAI converts a non-streaming /api/chat response to an OpenAI
+// Chat Completions response. Returns the body, input tokens, output tokens and
+// hasToolCall.
+func OllamaToOpenAI(body []byte, model string) ([]byte, int, int, bool, error) {
+	var resp chatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, 0, 0, false, fmt.Errorf("invalid Ollama response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, 0, 0, false, fmt.Errorf("ollama: %s", resp.Error)
+	}
+
+	msg := map[string]interface{}{
+		"role":    "assistant",
+		"content": resp.Message.Content,
+	}
+	if resp.Message.Thinking != "" {
+		msg["reasoning_content"] = resp.Message.Thinking
+	}
+	calls := openAIToolCalls(resp.Message.ToolCalls, 0)
+	if len(calls) > 0 {
+		msg["tool_calls"] = calls
+	}
+
+	out := map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-ollama-%d", time.Now().UnixNano()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       msg,
+				"finish_reason": finishReason(resp.DoneReason, len(calls) > 0),
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     resp.PromptEvalCount,
+			"completion_tokens": resp.EvalCount,
+			"total_tokens":      resp.PromptEvalCount + resp.EvalCount,
+		},
+	}
+	result, err := json.Marshal(out)
+	return result, resp.PromptEvalCount, resp.EvalCount, len(calls) > 0, err
+}
+
+// StreamTransformToOpenAI converts an Ollama NDJSON stream to OpenAI SSE format.
+// Returns input tokens, output tokens and hasToolCall.
+func StreamTransformToOpenAI(r io.Reader, w io.Writer, model string) (int, int, bool) {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 1024*1024)
+	scanner.Buffer(buf, len(buf))
+
+	id := fmt.Sprintf("chatcmpl-ollama-%d", time.Now().UnixNano())
+	inputTokens, outputTokens := 0, 0
+	toolCalls := 0
+	sentRole := false
+
+	emit := func(delta map[string]interface{}, finish interface{}, usage map[string]interface{}) {
+		chunk := map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []map[string]interface{}{
+				{"index": 0, "delta": delta, "finish_reason": finish},
+			},
+		}
+		if usage != nil {
+			chunk["usage"] = usage
+		}
+		chunkBytes, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", chunkBytes)
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var resp chatResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			continue
+		}
+		if resp.Error != "" {
+			errBytes, _ := json.Marshal(map[string]interface{}{
+				"error": map[string]interface{}{"message": resp.Error, "type": "upstream_error"},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", errBytes)
+			break
+		}
+
+		delta := map[string]interface{}{}
+		if resp.Message.Content != "" {
+			delta["content"] = resp.Message.Content
+		}
+		if resp.Message.Thinking != "" {
+			delta["reasoning_content"] = resp.Message.Thinking
+		}
+		if calls := openAIToolCalls(resp.Message.ToolCalls, toolCalls); len(calls) > 0 {
+			delta["tool_calls"] = calls
+			toolCalls += len(calls)
+		}
+		if len(delta) > 0 {
+			if !sentRole {
+				delta["role"] = "assistant"
+				sentRole = true
+			}
+			emit(delta, nil, nil)
+		}
+
+		if resp.Done {
+			inputTokens, outputTokens = resp.PromptEvalCount, resp.EvalCount
+			emit(map[string]interface{}{}, finishReason(resp.DoneReason, toolCalls > 0), map[string]interface{}{
+				"prompt_tokens":     inputTokens,
+				"completion_tokens": outputTokens,
+				"total_tokens":      inputTokens + outputTokens,
+			})
+			break
+		}
+	}
+
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	if f, ok := w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	return inputTokens, outputTokens, toolCalls > 0
+}
+
+// openAIToolCalls converts Ollama tool calls, numbering them from first.
+// Ollama has no call IDs, so they are made up from the position.
+func openAIToolCalls(calls []toolCall, first int) []map[string]interface{} {
+	var out []map[string]interface{}
+	for i, tc := range calls {
+		args := string(tc.Function.Arguments)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		out = append(out, map[string]interface{}{
+			"index": first + i,
+			"id":    fmt.Sprintf("call_%d_%s", first+i, tc.Function.Name),
+			"type":  "function",
+			"function": map[string]interface{}{
+				"name":      tc.Function.Name,
+				"arguments": args,
+			},
+		})
+	}
+	return out
+}
+
+func finishReason(doneReason string, hasToolCall bool) string {
+	switch {
+	case hasToolCall:
+		return "tool_calls"
+	case doneReason == "length":
+		return "length"
+	default:
+		return "stop"
+	}
+}
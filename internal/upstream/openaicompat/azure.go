@@ -0,0 +1,81 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI api-version used when neither the
+// configuration nor the provider's base URL sets one.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// AzureURL returns the chat completions URL of an Azure OpenAI deployment:
+// {baseURL}/openai/deployments/{deployment}/chat/completions?api-version=...
+// An api-version query parameter on baseURL overrides apiVersion.
+func AzureURL(baseURL, deployment, apiVersion string) string {
+	if u, err := url.Parse(baseURL); err == nil {
+		if v := u.Query().Get("api-version"); v != "" {
+			apiVersion = v
+		}
+		u.RawQuery = ""
+		baseURL = u.String()
+	}
+	return strings.TrimRight(baseURL, "/") + "/openai/deployments/" + url.PathEscape(deployment) +
+		"/chat/completions?api-version=" + url.QueryEscape(apiVersion)
+}
+
+// AzureProxy sends the request body as-is to apiURL (see AzureURL). Azure
+// authenticates with the api-key header instead of a Bearer token.
+func AzureProxy(apiURL string, apiKey string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", apiKey)
+
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Minute}
+	return client.Do(req)
+}
+
+// ContentFilterError reports whether body is an Azure OpenAI content-filter
+// error (error.code "content_filter") and returns its message, with the
+// filtered categories from innererror.content_filter_result appended.
+func ContentFilterError(body []byte) (string, bool) {
+	var resp struct {
+		Error struct {
+			Message    string `json:"message"`
+			Code       string `json:"code"`
+			InnerError struct {
+				ContentFilterResult map[string]struct {
+					Filtered bool `json:"filtered"`
+				} `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Error.Code != "content_filter" {
+		return "", false
+	}
+
+	var filtered []string
+	for category, result := range resp.Error.InnerError.ContentFilterResult {
+		if result.Filtered {
+			filtered = append(filtered, category)
+		}
+	}
+	message := resp.Error.Message
+	if message == "" {
+		message = "The request was blocked by the Azure OpenAI content filter"
+	}
+	if len(filtered) > 0 {
+		sort.Strings(filtered)
+		message += " (filtered: " + strings.Join(filtered, ", ") + ")"
+	}
+	return message, true
+}
@@ -18,6 +18,12 @@ var transport = &http.Transport{
 // e.g. Groq: baseURL="https://api.groq.com", path="/openai/v1/responses"
 //      OpenAI: baseURL="https://api.openai.com", path="/v1/chat/completions"
 func Proxy(baseURL string, apiKey string, path string, body []byte) (*http.Response, error) {
+	return ProxyWithHeaders(baseURL, apiKey, path, body, nil)
+}
+
+// ProxyWithHeaders is Proxy with extra request headers, e.g. OpenRouter's
+// HTTP-Referer and X-Title.
+func ProxyWithHeaders(baseURL string, apiKey string, path string, body []byte, header http.Header) (*http.Response, error) {
 	apiURL := strings.TrimRight(baseURL, "/") + path
 
 	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(body))
@@ -27,6 +33,9 @@ func Proxy(baseURL string, apiKey string, path string, body []byte) (*http.Respo
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apiKey)
+	for k, v := range header {
+		req.Header[k] = v
+	}
 
 	client := &http.Client{Transport: transport, Timeout: 5 * time.Minute}
 	return client.Do(req)
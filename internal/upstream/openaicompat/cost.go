@@ -0,0 +1,80 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// costUsage is the part of a response or stream chunk that carries the price
+// OpenRouter charged for the request (usage.cost, in USD).
+type costUsage struct {
+	Usage *struct {
+		Cost float64 `json:"cost"`
+	} `json:"usage"`
+}
+
+// ExtractCost returns the usage.cost of a non-streaming response, or 0 if the
+// provider does not report one.
+func ExtractCost(body []byte) float64 {
+	var resp costUsage
+	if json.Unmarshal(body, &resp) != nil || resp.Usage == nil {
+		return 0
+	}
+	return resp.Usage.Cost
+}
+
+// CostReader passes an SSE stream through unchanged while picking up the
+// usage.cost of its chunks, so it can sit in front of any stream transform.
+type CostReader struct {
+	r    io.Reader
+	line []byte // unterminated tail of the last read
+	cost float64
+}
+
+// NewCostReader wraps an OpenAI-compatible SSE response body.
+func NewCostReader(r io.Reader) *CostReader {
+	return &CostReader{r: r}
+}
+
+func (c *CostReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.scan(p[:n])
+	if err == io.EOF {
+		c.scanLine(c.line)
+		c.line = nil
+	}
+	return n, err
+}
+
+// Cost returns the last usage.cost seen in the stream.
+func (c *CostReader) Cost() float64 {
+	return c.cost
+}
+
+func (c *CostReader) scan(b []byte) {
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			c.line = append(c.line, b...)
+			return
+		}
+		if len(c.line) > 0 {
+			c.scanLine(append(c.line, b[:i]...))
+			c.line = c.line[:0]
+		} else {
+			c.scanLine(b[:i])
+		}
+		b = b[i+1:]
+	}
+}
+
+func (c *CostReader) scanLine(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok || !bytes.Contains(data, []byte(`"cost"`)) {
+		return
+	}
+	if cost := ExtractCost(bytes.TrimSpace(data)); cost > 0 {
+		c.cost = cost
+	}
+}
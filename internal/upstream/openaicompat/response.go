@@ -42,7 +42,9 @@ type StreamChunk struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	Model   string `json:"model"`
-	Choices []struct {
+	// Azure OpenAI content filter annotations on the prompt
+	PromptFilterResults json.RawMessage `json:"prompt_filter_results,omitempty"`
+	Choices             []struct {
 		Index int `json:"index"`
 		Delta struct {
 			Role      string          `json:"role,omitempty"`
@@ -104,23 +106,28 @@ func StreamTransform(r io.Reader, w io.Writer) (int, int, bool, bool) {
 	hasToolCall := false
 	cacheHit := false
 
+	// Azure OpenAI opens the stream with a chunk that only carries
+	// prompt_filter_results: no id, no choices. Clients that read choices[0]
+	// choke on it, so its annotations ride on the next chunk with choices.
+	var promptFilterResults json.RawMessage
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		// Pass through all lines
-		fmt.Fprintf(w, "%s\n", line)
-
 		// Extract usage from data lines
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-
-			// Skip [DONE] marker
-			if strings.TrimSpace(data) == "[DONE]" {
-				continue
-			}
-
+		if data := strings.TrimPrefix(line, "data: "); data != line && strings.TrimSpace(data) != "[DONE]" {
 			var chunk StreamChunk
 			if err := json.Unmarshal([]byte(data), &chunk); err == nil {
+				if len(chunk.PromptFilterResults) > 0 && len(chunk.Choices) == 0 && chunk.Usage == nil {
+					promptFilterResults = chunk.PromptFilterResults
+					continue
+				}
+				if promptFilterResults != nil && len(chunk.Choices) > 0 && len(chunk.PromptFilterResults) == 0 {
+					if withResults, ok := setPromptFilterResults(data, promptFilterResults); ok {
+						line = "data: " + withResults
+						promptFilterResults = nil
+					}
+				}
 				if chunk.Usage != nil {
 					inputTokens = chunk.Usage.PromptTokens
 					outputTokens = chunk.Usage.CompletionTokens
@@ -135,7 +142,30 @@ func StreamTransform(r io.Reader, w io.Writer) (int, int, bool, bool) {
 				}
 			}
 		}
+
+		if promptFilterResults != nil && strings.TrimSpace(strings.TrimPrefix(line, "data: ")) == "[DONE]" {
+			// No chunk with choices followed: send the annotations on their own.
+			fmt.Fprintf(w, "data: {\"object\":\"chat.completion.chunk\",\"choices\":[],\"prompt_filter_results\":%s}\n\n", promptFilterResults)
+			promptFilterResults = nil
+		}
+
+		// Pass through all other lines
+		fmt.Fprintf(w, "%s\n", line)
 	}
 
 	return inputTokens, outputTokens, hasToolCall, cacheHit
 }
+
+// setPromptFilterResults adds prompt_filter_results to a chunk's JSON.
+func setPromptFilterResults(data string, results json.RawMessage) (string, bool) {
+	var chunk map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return "", false
+	}
+	chunk["prompt_filter_results"] = results
+	out, err := json.Marshal(chunk)
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}